@@ -0,0 +1,139 @@
+/*
+Package audit records instances retrieving sensitive data, such as userdata and vendor-data, as
+JSON lines. The
+audit log is separate from the request log so it can be retained as evidence of which instances
+retrieved secrets and when.
+*/
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/http/request"
+)
+
+// Outcomes of a retrieval.
+const (
+	// OutcomeServed indicates the data was served.
+	OutcomeServed = "served"
+
+	// OutcomeDenied indicates the data was withheld by policy.
+	OutcomeDenied = "denied"
+
+	// OutcomeNotFound indicates no instance was found for the requester.
+	OutcomeNotFound = "not_found"
+
+	// OutcomeInvalid indicates the request was invalid.
+	OutcomeInvalid = "invalid"
+
+	// OutcomeError indicates the data couldn't be retrieved.
+	OutcomeError = "error"
+)
+
+// Entry is a single audit record.
+type Entry struct {
+	// Time is when the request was served.
+	Time time.Time `json:"time"`
+
+	// InstanceID and Namespace identify the instance the requester resolved to. They're empty if
+	// no instance was resolved.
+	InstanceID string `json:"instance_id,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+
+	// IP is the address of the requester after applying trusted proxy headers.
+	IP string `json:"ip"`
+
+	// Peer is the address of the peer that connected to Hegel. It differs from IP when the
+	// request was forwarded by a trusted proxy.
+	Peer string `json:"peer"`
+
+	// Endpoint is the path requested and Payload is the data it serves, for example user-data.
+	Endpoint string `json:"endpoint"`
+	Payload  string `json:"payload"`
+
+	// Outcome is one of the Outcome constants and Status is the HTTP status code returned.
+	Outcome string `json:"outcome"`
+	Status  int    `json:"status"`
+}
+
+// Log writes Entries to a writer as JSON lines. Entries are written synchronously so they aren't
+// lost if Hegel exits. Log is safe for concurrent use.
+type Log struct {
+	logger logr.Logger
+
+	mtx sync.Mutex
+	enc *json.Encoder
+
+	// now is used to retrieve the current time. It exists for testing.
+	now func() time.Time
+}
+
+// New creates a Log writing to w. Errors writing entries are logged to logger.
+func New(w io.Writer, logger logr.Logger) *Log {
+	if logger.GetSink() == nil {
+		logger = logr.Discard()
+	}
+
+	return &Log{
+		logger: logger,
+		enc:    json.NewEncoder(w),
+		now:    time.Now,
+	}
+}
+
+// Record writes e.
+func (l *Log) Record(e Entry) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if err := l.enc.Encode(e); err != nil {
+		l.logger.Error(err, "Failed to write audit entry", "entry", e)
+	}
+}
+
+// AuditPayload satisfies ec2.Auditor.
+func (l *Log) AuditPayload(r *http.Request, instance ec2.Instance, payload ec2.Payload, status int) {
+	// Addresses that can't be parsed are recorded as received.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	peer, err := request.PeerIP(r)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	l.Record(Entry{
+		Time:       l.now(),
+		InstanceID: instance.Metadata.InstanceID,
+		Namespace:  instance.Namespace,
+		IP:         ip,
+		Peer:       peer,
+		Endpoint:   r.URL.Path,
+		Payload:    string(payload),
+		Outcome:    outcome(status),
+		Status:     status,
+	})
+}
+
+// outcome maps an HTTP status code to an outcome.
+func outcome(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return OutcomeServed
+	case status == http.StatusForbidden:
+		return OutcomeDenied
+	case status == http.StatusNotFound:
+		return OutcomeNotFound
+	case status >= 400 && status < 500:
+		return OutcomeInvalid
+	default:
+		return OutcomeError
+	}
+}
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/http/request"
+)
+
+func TestLogAuditPayload(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, logr.Discard())
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	// Simulate a request forwarded by a trusted proxy.
+	r := httptest.NewRequest(http.MethodGet, "/2009-04-04/vendor-data", nil)
+	r.RemoteAddr = "192.168.0.1:1234"
+	r = request.WithPeer(r)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	var instance ec2.Instance
+	instance.Metadata.InstanceID = "instance"
+	instance.Namespace = "default"
+
+	l.AuditPayload(r, instance, ec2.PayloadVendordata, http.StatusForbidden)
+
+	var entry Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Entry{
+		Time:       now,
+		InstanceID: "instance",
+		Namespace:  "default",
+		IP:         "10.10.10.10",
+		Peer:       "192.168.0.1",
+		Endpoint:   "/2009-04-04/vendor-data",
+		Payload:    "vendor-data",
+		Outcome:    OutcomeDenied,
+		Status:     http.StatusForbidden,
+	}
+	if diff := cmp.Diff(expect, entry); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	cases := map[int]string{
+		http.StatusOK:                  OutcomeServed,
+		http.StatusForbidden:           OutcomeDenied,
+		http.StatusNotFound:            OutcomeNotFound,
+		http.StatusBadRequest:          OutcomeInvalid,
+		http.StatusInternalServerError: OutcomeError,
+	}
+	for status, expect := range cases {
+		if o := outcome(status); o != expect {
+			t.Fatalf("%v: Expected %v; Received %v", status, expect, o)
+		}
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f, err := OpenFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each write fills the file so every subsequent write rotates it.
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range expect {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("%v: Expected %q; Received %q", p, content, b)
+		}
+	}
+
+	// The oldest backup is removed.
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Expected %v.3 to not exist; Received %v", path, err)
+	}
+}
+
+func TestFileRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	f, err := OpenFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// A directory at the backup path prevents the file from being renamed.
+	if err := os.Mkdir(path+".1", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("second\n")); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// The file is reopened so writes continue once rotation succeeds.
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("third\n")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expect := map[string]string{
+		path:        "third\n",
+		path + ".1": "first\n",
+	}
+	for p, content := range expect {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Fatalf("%v: Expected %q; Received %q", p, content, b)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an io.WriteCloser appending to a file that is rotated when it reaches a maximum size.
+// Rotated files are renamed with a numeric suffix, path.1 being the most recent, and the oldest
+// are removed once there are more than the maximum number of backups. File is safe for
+// concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mtx  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens the file at path for appending, creating it if necessary. The file is rotated
+// when a write would grow it beyond maxSize bytes; if maxSize is 0 it is never rotated.
+// maxBackups rotated files are retained.
+func OpenFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+
+	f.f = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write satisfies io.Writer.
+func (f *File) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file to the first backup, shifting existing backups, and opens a
+// new file. If rotation fails the file at path is reopened so subsequent writes are appended to
+// it. f.mtx must be held.
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return f.reopen(fmt.Errorf("close audit log: %w", err))
+	}
+
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil {
+			return f.reopen(fmt.Errorf("remove audit log: %w", err))
+		}
+		return f.open()
+	}
+
+	// Renaming over the oldest backup removes it.
+	for i := f.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(backupPath(f.path, i), backupPath(f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return f.reopen(fmt.Errorf("rotate audit log: %w", err))
+		}
+	}
+	if err := os.Rename(f.path, backupPath(f.path, 1)); err != nil {
+		return f.reopen(fmt.Errorf("rotate audit log: %w", err))
+	}
+
+	return f.open()
+}
+
+// reopen opens the file at path after rotation failed with err and returns err. If the file
+// can't be opened the next write attempts to rotate, and reopen, it again.
+func (f *File) reopen(err error) error {
+	if openErr := f.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// Close satisfies io.Closer.
+func (f *File) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.f.Close()
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%v.%d", path, i)
+}
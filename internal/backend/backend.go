@@ -4,9 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
 
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/composite"
+	"github.com/tinkerbell/hegel/internal/backend/crd"
 	"github.com/tinkerbell/hegel/internal/backend/flatfile"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	"github.com/tinkerbell/hegel/internal/backend/rest"
+	"github.com/tinkerbell/hegel/internal/backend/sqldb"
+	"github.com/tinkerbell/hegel/internal/backend/tinkgrpc"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 	"github.com/tinkerbell/hegel/internal/frontend/hack"
 	"github.com/tinkerbell/hegel/internal/healthcheck"
@@ -15,8 +23,16 @@ import (
 // ErrMissingBackendConfig indicates New was called without a backend configuration.
 var ErrMissingBackendConfig = errors.New("no backend configuration specified in options")
 
-// ErrMultipleBackends indicates the backend Options contains more than one backend configuration.
-var ErrMultipleBackends = errors.New("only one backend option can be specified")
+// ErrMultipleBackends indicates the backend Options contains more than one backend configuration
+// without specifying the order to query them in.
+var ErrMultipleBackends = errors.New("multiple backends require an order")
+
+// ErrUnconfiguredBackend indicates the backend Options refers to a backend that isn't configured.
+var ErrUnconfiguredBackend = errors.New("backend is not configured")
+
+// ErrAPIServerWithContexts indicates the backend Options specifies a Kubernetes API server address
+// with multiple contexts. The address would override the server of every context's cluster.
+var ErrAPIServerWithContexts = errors.New("kubernetes api server address requires a single context")
 
 // Client is an abstraction for all frontend clients. Each backend implementation should satisfy
 // this interface.
@@ -26,60 +42,268 @@ type Client interface {
 	healthcheck.Client
 }
 
-// New creates a backend instance for the configuration specified by opts. Consumers may only
-// supply 1 backend configuration. If no backend configuration is supplied, it returns
-// ErrMissingBackendConfig.
+// Runner is implemented by backends that must run to serve data, for example to synchronize
+// caches. Start blocks until ctx is cancelled or an unrecoverable error occurs.
+type Runner interface {
+	Start(ctx context.Context) error
+}
+
+// ReadyWaiter is implemented by backends that must perform work, such as populating a cache,
+// before they can serve data.
+type ReadyWaiter interface {
+	// WaitForReady blocks until the backend can serve data or ctx is cancelled. It returns false
+	// if the backend isn't ready.
+	WaitForReady(ctx context.Context) bool
+}
+
+// Backend names used to identify backends in Options.Order and Options.Routes.
+const (
+	NameFlatfile   = "flatfile"
+	NameKubernetes = "kubernetes"
+	NameREST       = "rest"
+	NameTink       = "tink"
+	NameSQL        = "sql"
+	NameCRD        = "crd"
+)
+
+// New creates a backend instance for the configuration specified by opts. If no backend
+// configuration is supplied, it returns ErrMissingBackendConfig. If multiple backends are
+// configured, or routes are configured, the backends are combined into a composite backend that
+// queries them in opts.Order.
+//
+// Backends that implement Runner must be started by the caller.
 func New(ctx context.Context, opts Options) (Client, error) {
 	if err := opts.validate(); err != nil {
 		return nil, err
 	}
 
-	switch {
-	case opts.Flatfile != nil:
-		return flatfile.FromYAMLFile(opts.Flatfile.Path)
+	backends := map[string]Client{}
 
-	case opts.Kubernetes != nil:
-		kubeclient, err := kubernetes.NewBackend(ctx, kubernetes.Config{
-			Kubeconfig:       opts.Kubernetes.Kubeconfig,
-			APIServerAddress: opts.Kubernetes.APIServerAddress,
-			Namespace:        opts.Kubernetes.Namespace,
-		})
+	if opts.Flatfile != nil {
+		client, err := flatfile.FromYAMLFile(opts.Flatfile.Path)
+		if err != nil {
+			return nil, err
+		}
+		backends[NameFlatfile] = client
+	}
+
+	if opts.Kubernetes != nil {
+		cfg := kubernetes.Config{
+			Kubeconfig:           opts.Kubernetes.Kubeconfig,
+			Context:              opts.Kubernetes.Context,
+			APIServerAddress:     opts.Kubernetes.APIServerAddress,
+			Namespace:            opts.Kubernetes.Namespace,
+			Namespaces:           opts.Kubernetes.Namespaces,
+			LabelSelector:        opts.Kubernetes.LabelSelector,
+			IPSources:            opts.Kubernetes.IPSources,
+			ConflictPolicy:       opts.Kubernetes.ConflictPolicy,
+			StaleTolerance:       opts.Kubernetes.StaleTolerance,
+			SnapshotPath:         opts.Kubernetes.SnapshotPath,
+			SnapshotInterval:     opts.Kubernetes.SnapshotInterval,
+			Precompute:           opts.Kubernetes.Precompute,
+			LazyUserdata:         opts.Kubernetes.LazyUserdata,
+			UserdataCacheSize:    opts.Kubernetes.UserdataCacheSize,
+			RecordAccess:         opts.Kubernetes.RecordAccess,
+			AccessRecordInterval: opts.Kubernetes.AccessRecordInterval,
+			ForwardEvents:        opts.Kubernetes.ForwardEvents,
+			RescueUserdata:       opts.Kubernetes.RescueUserdata,
+			RescueVendordata:     opts.Kubernetes.RescueVendordata,
+			Logger:               opts.Logger,
+			Registerer:           opts.Registerer,
+		}
+
+		kubeclient, err := newKubernetesBackend(ctx, cfg, opts.KubernetesContexts)
 		if err != nil {
 			return nil, fmt.Errorf("kubernetes client: %v", err)
 		}
-		if ok := kubeclient.WaitForCacheSync(ctx); !ok {
-			return nil, errors.New("failed to sync kubernetes cache")
+		backends[NameKubernetes] = kubeclient
+	}
+
+	if opts.REST != nil {
+		cfg := *opts.REST
+		cfg.Registerer = opts.Registerer
+
+		client, err := rest.NewBackend(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rest client: %v", err)
+		}
+		backends[NameREST] = client
+	}
+
+	if opts.Tink != nil {
+		cfg := *opts.Tink
+		cfg.Logger = opts.Logger
+
+		client, err := tinkgrpc.NewBackend(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("tink client: %v", err)
+		}
+		backends[NameTink] = client
+	}
+
+	if opts.SQL != nil {
+		cfg := *opts.SQL
+		cfg.Logger = opts.Logger
+
+		client, err := sqldb.NewBackend(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("sql client: %v", err)
+		}
+		backends[NameSQL] = client
+	}
+
+	if opts.CRD != nil {
+		cfg := *opts.CRD
+		cfg.Logger = opts.Logger
+
+		client, err := crd.NewBackend(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("crd client: %v", err)
+		}
+		backends[NameCRD] = client
+	}
+
+	if len(backends) == 1 && len(opts.Routes) == 0 {
+		for _, client := range backends {
+			return client, nil
+		}
+	}
+
+	order := opts.Order
+	if len(order) == 0 {
+		for name := range backends {
+			order = append(order, name)
 		}
+	}
+
+	sources := make([]composite.Source, 0, len(order))
+	for _, name := range order {
+		sources = append(sources, composite.Source{
+			Name:   name,
+			Client: backends[name],
+			CIDRs:  opts.Routes[name],
+		})
+	}
+
+	return composite.New(sources, composite.Options{
+		Logger:     opts.Logger,
+		Registerer: opts.Registerer,
+	})
+}
+
+// newKubernetesBackend creates a Kubernetes backend. If contexts are specified, Hardware is served
+// from the cluster of each context using a multi-cluster backend.
+func newKubernetesBackend(ctx context.Context, cfg kubernetes.Config, contexts []string) (Client, error) {
+	if len(contexts) == 0 {
+		return kubernetes.NewBackend(ctx, cfg)
+	}
 
-		return kubeclient, nil
+	clusters := make([]kubernetes.ClusterConfig, 0, len(contexts))
+	for _, name := range contexts {
+		clusterCfg := cfg
+		clusterCfg.Context = name
+
+		// Each cluster has its own cache so each needs its own snapshot.
+		if cfg.SnapshotPath != "" {
+			clusterCfg.SnapshotPath = cfg.SnapshotPath + "." + name
+		}
 
-	default:
-		return nil, ErrMissingBackendConfig
+		clusters = append(clusters, kubernetes.ClusterConfig{Name: name, Config: clusterCfg})
 	}
+
+	return kubernetes.NewMultiClusterBackend(ctx, kubernetes.MultiClusterConfig{
+		Clusters:   clusters,
+		Logger:     cfg.Logger,
+		Registerer: cfg.Registerer,
+	})
 }
 
-// Options contains all options for all backend implementations. Only one backend option can be
-// specified at a time.
+// Options contains all options for all backend implementations. When more than one backend is
+// configured, Order must specify the order they're queried in.
 type Options struct {
 	Flatfile   *Flatfile
 	Kubernetes *kubernetes.Config
+	REST       *rest.Config
+	Tink       *tinkgrpc.Config
+	SQL        *sqldb.Config
+	CRD        *crd.Config
+
+	// KubernetesContexts serves Hardware from the cluster of each kubeconfig context using the
+	// Kubernetes configuration. Lookups query all clusters. Optional.
+	KubernetesContexts []string
+
+	// Order lists the names of the configured backends in the order they're queried. It is
+	// required when more than one backend is configured and must include every configured
+	// backend.
+	Order []string
+
+	// Routes restricts backends, by name, to lookups for IPs within the CIDRs. Backends without
+	// routes serve lookups for all IPs. Optional.
+	Routes map[string][]netip.Prefix
+
+	// Registerer is used by backends to register metrics. Optional.
+	Registerer prometheus.Registerer
+
+	// Logger is used by backends to log events. Optional.
+	Logger logr.Logger
 }
 
 func (o Options) validate() error {
-	var count int
+	configured := map[string]bool{
+		NameFlatfile:   o.Flatfile != nil,
+		NameKubernetes: o.Kubernetes != nil,
+		NameREST:       o.REST != nil,
+		NameTink:       o.Tink != nil,
+		NameSQL:        o.SQL != nil,
+		NameCRD:        o.CRD != nil,
+	}
 
-	if o.Flatfile != nil {
-		count++
+	var count int
+	for _, ok := range configured {
+		if ok {
+			count++
+		}
 	}
 
-	if o.Kubernetes != nil {
-		count++
+	if count == 0 {
+		return ErrMissingBackendConfig
 	}
 
-	if count > 1 {
+	if count > 1 && len(o.Order) == 0 {
 		return ErrMultipleBackends
 	}
 
+	if len(o.Order) > 0 {
+		seen := map[string]bool{}
+		for _, name := range o.Order {
+			if !configured[name] {
+				return fmt.Errorf("%w: %v", ErrUnconfiguredBackend, name)
+			}
+			if seen[name] {
+				return fmt.Errorf("duplicate backend in order: %v", name)
+			}
+			seen[name] = true
+		}
+
+		if len(seen) != count {
+			return errors.New("order must include every configured backend")
+		}
+	}
+
+	if len(o.KubernetesContexts) > 0 && o.Kubernetes == nil {
+		return fmt.Errorf("%w: %v", ErrUnconfiguredBackend, NameKubernetes)
+	}
+
+	if len(o.KubernetesContexts) > 1 && o.Kubernetes.APIServerAddress != "" {
+		return ErrAPIServerWithContexts
+	}
+
+	for name := range o.Routes {
+		if !configured[name] {
+			return fmt.Errorf("%w: %v", ErrUnconfiguredBackend, name)
+		}
+	}
+
 	return nil
 }
 
@@ -3,9 +3,13 @@ package backend_test
 import (
 	"context"
 	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/backend/composite"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
 )
 
@@ -28,6 +32,32 @@ func TestNew(t *testing.T) {
 			Options: Options{},
 			Error:   ErrMissingBackendConfig,
 		},
+		{
+			Name: "UnconfiguredBackendInOrder",
+			Options: Options{
+				Flatfile: &Flatfile{},
+				Order:    []string{NameFlatfile, NameKubernetes},
+			},
+			Error: ErrUnconfiguredBackend,
+		},
+		{
+			Name: "UnconfiguredBackendInRoutes",
+			Options: Options{
+				Flatfile: &Flatfile{},
+				Routes: map[string][]netip.Prefix{
+					NameKubernetes: {netip.MustParsePrefix("10.0.0.0/24")},
+				},
+			},
+			Error: ErrUnconfiguredBackend,
+		},
+		{
+			Name: "APIServerWithMultipleContexts",
+			Options: Options{
+				Kubernetes:         &kubernetes.Config{APIServerAddress: "https://10.0.0.1:6443"},
+				KubernetesContexts: []string{"eu-west", "us-east"},
+			},
+			Error: ErrAPIServerWithContexts,
+		},
 	}
 
 	for _, tc := range cases {
@@ -44,3 +74,33 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestNewComposite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flatfile.yml")
+	err := os.WriteFile(path, []byte("- ips: [\"10.0.0.1\"]\n  metadata:\n    id: machine\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := New(context.Background(), Options{
+		Flatfile: &Flatfile{Path: path},
+		Routes: map[string][]netip.Prefix{
+			NameFlatfile: {netip.MustParsePrefix("10.0.0.0/24")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, ok := client.(*composite.Backend); !ok {
+		t.Fatalf("Expected *composite.Backend; Received %T", client)
+	}
+
+	instance, err := client.GetEC2Instance(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if instance.Metadata.InstanceID != "machine" {
+		t.Fatalf("Expected instance 'machine'; Received %q", instance.Metadata.InstanceID)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Package cache provides a decorator for backend clients that caches per-IP lookups and collapses
+concurrent identical lookups into a single backend call. It is useful for backends where each
+lookup is expensive, such as backends that map large objects or call remote services, because
+machines typically request many endpoints in quick succession.
+*/
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+// Client is the set of backend behaviors that can be cached.
+type Client interface {
+	ec2.Client
+	hack.Client
+}
+
+// Options configures a Cache.
+type Options struct {
+	// TTL is how long successful lookups are cached.
+	TTL time.Duration
+
+	// NegativeTTL is how long not found lookups are cached. If 0, not found lookups aren't
+	// cached. Optional.
+	NegativeTTL time.Duration
+
+	// Registerer is used to register cache metrics. Optional.
+	Registerer prometheus.Registerer
+}
+
+// Cache decorates a Client caching lookup results. It satisfies Client. Errors other than not
+// found errors are never cached.
+type Cache struct {
+	client Client
+	ec2    *lookupCache[ec2.Instance]
+	hack   *lookupCache[hack.Instance]
+}
+
+// New creates a Cache that decorates client.
+func New(client Client, opts Options) (*Cache, error) {
+	lookups := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backend_cache_lookups_total",
+			Help: "Count of cached backend lookups by lookup type and result",
+		},
+		[]string{"lookup", "result"},
+	)
+
+	if opts.Registerer != nil {
+		if err := opts.Registerer.Register(lookups); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Cache{
+		client: client,
+		ec2:    newLookupCache[ec2.Instance](opts, ec2.ErrInstanceNotFound, lookups.MustCurryWith(prometheus.Labels{"lookup": "ec2"})),
+		hack:   newLookupCache[hack.Instance](opts, hack.ErrInstanceNotFound, lookups.MustCurryWith(prometheus.Labels{"lookup": "hack"})),
+	}, nil
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (c *Cache) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return c.ec2.get(ctx, ip, c.client.GetEC2Instance)
+}
+
+// GetHackInstance satisfies hack.Client.
+func (c *Cache) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return c.hack.get(ctx, ip, c.client.GetHackInstance)
+}
+
+// Result label values.
+const (
+	resultHit       = "hit"
+	resultMiss      = "miss"
+	resultCoalesced = "coalesced"
+)
+
+type entry[T any] struct {
+	value   T
+	err     error
+	expires time.Time
+}
+
+// call is an in-flight backend lookup that concurrent lookups for the same IP wait on.
+type call[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// lookupCache caches the results of a single lookup function by IP.
+type lookupCache[T any] struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	notFound    error
+	results     *prometheus.CounterVec
+
+	mtx       sync.Mutex
+	entries   map[string]entry[T]
+	calls     map[string]*call[T]
+	lastSweep time.Time
+
+	// now is used to retrieve the current time. It exists for testing.
+	now func() time.Time
+}
+
+func newLookupCache[T any](opts Options, notFound error, results *prometheus.CounterVec) *lookupCache[T] {
+	return &lookupCache[T]{
+		ttl:         opts.TTL,
+		negativeTTL: opts.NegativeTTL,
+		notFound:    notFound,
+		results:     results,
+		entries:     map[string]entry[T]{},
+		calls:       map[string]*call[T]{},
+		now:         time.Now,
+	}
+}
+
+func (c *lookupCache[T]) get(
+	ctx context.Context,
+	ip string,
+	fetch func(context.Context, string) (T, error),
+) (T, error) {
+	// Equivalent representations of an address share entries and in-flight lookups. Invalid
+	// addresses are keyed as received and left for the backend to reject.
+	key := ip
+	if normalized, err := ipaddr.Normalize(ip); err == nil {
+		key = normalized
+	}
+
+	c.mtx.Lock()
+
+	if e, ok := c.entries[key]; ok && c.now().Before(e.expires) {
+		c.mtx.Unlock()
+		c.results.WithLabelValues(resultHit).Inc()
+		return e.value, e.err
+	}
+
+	// If a lookup for ip is already in-flight, wait for its result instead of calling the
+	// backend again.
+	if inflight, ok := c.calls[key]; ok {
+		c.mtx.Unlock()
+		c.results.WithLabelValues(resultCoalesced).Inc()
+
+		select {
+		case <-inflight.done:
+			return inflight.value, inflight.err
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+
+	inflight := &call[T]{done: make(chan struct{})}
+	c.calls[key] = inflight
+	c.mtx.Unlock()
+
+	c.results.WithLabelValues(resultMiss).Inc()
+
+	// Coalesced lookups wait on the call so it must be completed and removed even if fetch
+	// panics; otherwise every later lookup for ip would block forever. A panicking fetch isn't
+	// cached and coalesced lookups receive an error.
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+
+		r := recover()
+		inflight.err = fmt.Errorf("lookup panicked: %v", r)
+		close(inflight.done)
+
+		c.mtx.Lock()
+		delete(c.calls, key)
+		c.mtx.Unlock()
+
+		panic(r)
+	}()
+
+	// The result is shared with coalesced lookups so it mustn't be cancelled because the
+	// request that triggered it was.
+	inflight.value, inflight.err = fetch(context.WithoutCancel(ctx), ip)
+	completed = true
+	close(inflight.done)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.calls, key)
+	c.store(key, inflight.value, inflight.err)
+
+	return inflight.value, inflight.err
+}
+
+// store caches the result of a lookup under key. c.mtx must be held.
+func (c *lookupCache[T]) store(key string, value T, err error) {
+	now := c.now()
+
+	var ttl time.Duration
+	switch {
+	case err == nil:
+		ttl = c.ttl
+	case errors.Is(err, c.notFound):
+		ttl = c.negativeTTL
+	}
+
+	if ttl > 0 {
+		c.entries[key] = entry[T]{value: value, err: err, expires: now.Add(ttl)}
+	}
+
+	// Periodically remove expired entries so IPs that are never looked up again don't grow the
+	// cache indefinitely.
+	if now.Sub(c.lastSweep) > c.ttl {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+}
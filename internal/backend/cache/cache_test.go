@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+type fakeClient struct {
+	calls    atomic.Int32
+	instance ec2.Instance
+	err      error
+	block    chan struct{}
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	f.calls.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	f.calls.Add(1)
+	return hack.Instance{}, f.err
+}
+
+func TestCache(t *testing.T) {
+	cases := []struct {
+		Name          string
+		IPs           [2]string
+		Err           error
+		Options       Options
+		Advance       time.Duration
+		ExpectedCalls int32
+	}{
+		{
+			Name:          "Hit",
+			Options:       Options{TTL: time.Minute},
+			ExpectedCalls: 1,
+		},
+		{
+			Name:          "EquivalentIPHit",
+			IPs:           [2]string{"10.10.10.10", "::ffff:10.10.10.10"},
+			Options:       Options{TTL: time.Minute},
+			ExpectedCalls: 1,
+		},
+		{
+			Name:          "Expired",
+			Options:       Options{TTL: time.Minute},
+			Advance:       2 * time.Minute,
+			ExpectedCalls: 2,
+		},
+		{
+			Name:          "NegativeHit",
+			Err:           ec2.ErrInstanceNotFound,
+			Options:       Options{TTL: time.Minute, NegativeTTL: time.Minute},
+			ExpectedCalls: 1,
+		},
+		{
+			Name:          "NegativeCachingDisabled",
+			Err:           ec2.ErrInstanceNotFound,
+			Options:       Options{TTL: time.Minute},
+			ExpectedCalls: 2,
+		},
+		{
+			Name:          "ErrorNotCached",
+			Err:           errors.New("backend unavailable"),
+			Options:       Options{TTL: time.Minute, NegativeTTL: time.Minute},
+			ExpectedCalls: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			client := &fakeClient{
+				instance: ec2.Instance{Metadata: ec2.Metadata{Hostname: "foo"}},
+				err:      tc.Err,
+			}
+
+			c, err := New(client, tc.Options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Now()
+			c.ec2.now = func() time.Time { return now }
+
+			ips := tc.IPs
+			if ips == [2]string{} {
+				ips = [2]string{"10.10.10.10", "10.10.10.10"}
+			}
+
+			for _, ip := range ips {
+				instance, err := c.GetEC2Instance(context.Background(), ip)
+				if !errors.Is(err, tc.Err) {
+					t.Fatalf("Expected: %v; Received: %v", tc.Err, err)
+				}
+				if tc.Err == nil && instance.Metadata.Hostname != "foo" {
+					t.Fatalf("Unexpected instance: %+v", instance)
+				}
+				now = now.Add(tc.Advance)
+			}
+
+			if calls := client.calls.Load(); calls != tc.ExpectedCalls {
+				t.Fatalf("Expected calls: %v; Received: %v", tc.ExpectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestCacheCoalescesConcurrentLookups(t *testing.T) {
+	client := &fakeClient{block: make(chan struct{})}
+	registry := prometheus.NewRegistry()
+
+	c, err := New(client, Options{TTL: time.Minute, Registerer: registry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const lookups = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < lookups; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetEC2Instance(context.Background(), "10.10.10.10"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Wait for every lookup to either call the backend or wait on the in-flight call.
+	metric := func(result string) float64 {
+		return testutil.ToFloat64(c.ec2.results.WithLabelValues(result))
+	}
+	for metric(resultMiss)+metric(resultCoalesced) < lookups {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(client.block)
+	wg.Wait()
+
+	if calls := client.calls.Load(); calls != 1 {
+		t.Fatalf("Expected calls: 1; Received: %v", calls)
+	}
+}
+
+func TestCacheRecoversFromPanickingLookups(t *testing.T) {
+	c := newLookupCache[string](
+		Options{TTL: time.Minute},
+		ec2.ErrInstanceNotFound,
+		prometheus.NewCounterVec(prometheus.CounterOpts{Name: "results"}, []string{"result"}),
+	)
+
+	release := make(chan struct{})
+	fetching := make(chan struct{})
+	panicking := func(context.Context, string) (string, error) {
+		close(fetching)
+		<-release
+		panic("boom")
+	}
+
+	// The panic propagates to the caller that triggered the lookup.
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() { panicked <- recover() }()
+		_, _ = c.get(context.Background(), "10.10.10.10", panicking)
+	}()
+	<-fetching
+
+	// A lookup coalesced with the panicking lookup receives an error.
+	coalesced := make(chan error, 1)
+	go func() {
+		_, err := c.get(context.Background(), "10.10.10.10", panicking)
+		coalesced <- err
+	}()
+	for testutil.ToFloat64(c.results.WithLabelValues(resultCoalesced)) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+
+	if r := <-panicked; r != "boom" {
+		t.Fatalf("Expected panic: boom; Received: %v", r)
+	}
+	if err := <-coalesced; err == nil {
+		t.Fatal("Expected error for coalesced lookup")
+	}
+
+	// Later lookups call the backend again rather than blocking on the panicked call.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	value, err := c.get(ctx, "10.10.10.10", func(context.Context, string) (string, error) {
+		return "recovered", nil
+	})
+	if err != nil || value != "recovered" {
+		t.Fatalf("Expected recovered; Received: %v, %v", value, err)
+	}
+}
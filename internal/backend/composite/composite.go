@@ -0,0 +1,378 @@
+/*
+Package composite provides a backend that serves lookups from multiple backends. Backends are
+queried in order until one answers, and can be restricted to lookups for IPs within a set of
+CIDRs. This lets operators migrate sites between backends gradually, for example by serving a
+lab subnet from a flatfile while the rest of a site is served from Kubernetes.
+*/
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+// ErrNoSources indicates a Backend was created without sources.
+var ErrNoSources = errors.New("at least one source is required")
+
+// Client is the set of behaviors required of each source.
+type Client interface {
+	ec2.Client
+	hack.Client
+	healthcheck.Client
+}
+
+// Source is a named backend queried by a Backend.
+type Source struct {
+	// Name identifies the source in logs, metrics and health checks.
+	Name string
+
+	// Client serves lookups for the source.
+	Client Client
+
+	// CIDRs restricts the source to lookups for IPs within the CIDRs. If empty, the source
+	// serves lookups for all IPs. Optional.
+	CIDRs []netip.Prefix
+}
+
+func (s Source) serves(ip netip.Addr, valid bool) bool {
+	if len(s.CIDRs) == 0 {
+		return true
+	}
+
+	if !valid {
+		return false
+	}
+
+	for _, cidr := range s.CIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Options configures a Backend.
+type Options struct {
+	// Logger is used to log which source answered each lookup. Optional.
+	Logger logr.Logger
+
+	// Registerer is used to register metrics. Optional.
+	Registerer prometheus.Registerer
+}
+
+// Backend serves lookups from an ordered list of sources. Each lookup is served by the first
+// source that serves the requesting IP and has an instance for it. Errors other than not found
+// errors are returned immediately so a failing source can't cause lookups to be served from a
+// lower priority source.
+type Backend struct {
+	sources []Source
+	logger  logr.Logger
+	lookups *prometheus.CounterVec
+}
+
+// New creates a Backend that queries sources in order.
+func New(sources []Source, opts Options) (*Backend, error) {
+	if len(sources) == 0 {
+		return nil, ErrNoSources
+	}
+
+	seen := map[string]bool{}
+	for _, s := range sources {
+		if s.Name == "" || s.Client == nil {
+			return nil, errors.New("sources require a name and client")
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("duplicate source: %v", s.Name)
+		}
+		seen[s.Name] = true
+
+		for _, cidr := range s.CIDRs {
+			if !cidr.IsValid() {
+				return nil, fmt.Errorf("source %v: invalid cidr: %v", s.Name, cidr)
+			}
+		}
+	}
+
+	if opts.Logger.GetSink() == nil {
+		opts.Logger = logr.Discard()
+	}
+
+	lookups := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "composite_backend_lookups_total",
+			Help: "Count of composite backend lookups by lookup type and the source that answered",
+		},
+		[]string{"lookup", "source"},
+	)
+
+	if opts.Registerer != nil {
+		if err := opts.Registerer.Register(lookups); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Backend{
+		sources: sources,
+		logger:  opts.Logger,
+		lookups: lookups,
+	}, nil
+}
+
+// noSource is the source label value used when no source answered a lookup.
+const noSource = "none"
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return lookup(ctx, b, "ec2", ip, ec2.ErrInstanceNotFound, Client.GetEC2Instance)
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return lookup(ctx, b, "hack", ip, hack.ErrInstanceNotFound, Client.GetHackInstance)
+}
+
+// GetEC2Userdata satisfies ec2.UserdataClient. Sources that don't retrieve userdata separately
+// are asked for the instance's metadata.
+func (b *Backend) GetEC2Userdata(ctx context.Context, ip string) (string, error) {
+	return lookup(ctx, b, "userdata", ip, ec2.ErrInstanceNotFound,
+		func(c Client, ctx context.Context, ip string) (string, error) {
+			if uc, ok := c.(ec2.UserdataClient); ok {
+				return uc.GetEC2Userdata(ctx, ip)
+			}
+
+			instance, err := c.GetEC2Instance(ctx, ip)
+			if err != nil {
+				return "", err
+			}
+			return instance.Userdata, nil
+		})
+}
+
+// GetEC2Rescue satisfies ec2.RescueClient. Instances of sources that don't serve rescue payloads
+// have none.
+func (b *Backend) GetEC2Rescue(ctx context.Context, ip string) (ec2.Rescue, bool, error) {
+	type result struct {
+		rescue ec2.Rescue
+		ok     bool
+	}
+
+	r, err := lookup(ctx, b, "rescue", ip, ec2.ErrInstanceNotFound,
+		func(c Client, ctx context.Context, ip string) (result, error) {
+			if rc, ok := c.(ec2.RescueClient); ok {
+				rescue, ok, err := rc.GetEC2Rescue(ctx, ip)
+				return result{rescue: rescue, ok: ok}, err
+			}
+
+			_, err := c.GetEC2Instance(ctx, ip)
+			return result{}, err
+		})
+	return r.rescue, r.ok, err
+}
+
+// InstanceID satisfies phonehome.InstanceIdentifier. Sources that can't identify instances are
+// asked for the instance's metadata.
+func (b *Backend) InstanceID(ctx context.Context, ip string) (string, error) {
+	return lookup(ctx, b, "instance-id", ip, ec2.ErrInstanceNotFound,
+		func(c Client, ctx context.Context, ip string) (string, error) {
+			if identifier, ok := c.(phonehome.InstanceIdentifier); ok {
+				return identifier.InstanceID(ctx, ip)
+			}
+
+			instance, err := c.GetEC2Instance(ctx, ip)
+			if err != nil {
+				return "", err
+			}
+			return instance.Metadata.InstanceID, nil
+		})
+}
+
+func lookup[T any](
+	ctx context.Context,
+	b *Backend,
+	name, ip string,
+	notFound error,
+	get func(Client, context.Context, string) (T, error),
+) (T, error) {
+	var addr netip.Addr
+	normalized, err := ipaddr.Normalize(ip)
+	if err == nil {
+		addr, err = netip.ParseAddr(normalized)
+	}
+	valid := err == nil
+
+	for _, s := range b.sources {
+		if !s.serves(addr, valid) {
+			continue
+		}
+
+		v, err := get(s.Client, ctx, ip)
+		if errors.Is(err, notFound) {
+			continue
+		}
+		if err != nil {
+			var zero T
+			return zero, fmt.Errorf("%v: %w", s.Name, err)
+		}
+
+		b.lookups.WithLabelValues(name, s.Name).Inc()
+		b.logger.V(1).Info("Lookup answered", "lookup", name, "ip", ip, "source", s.Name)
+
+		return v, nil
+	}
+
+	b.lookups.WithLabelValues(name, noSource).Inc()
+
+	var zero T
+	return zero, notFound
+}
+
+// RecordAccess satisfies ec2.AccessRecorder. Access is passed to every source that records
+// access and serves the address.
+func (b *Backend) RecordAccess(ctx context.Context, access ec2.Access) {
+	for _, s := range b.sourcesServing(access.IP) {
+		if recorder, ok := s.Client.(ec2.AccessRecorder); ok {
+			recorder.RecordAccess(ctx, access)
+		}
+	}
+}
+
+// RecordServe satisfies ec2.ServeRecorder and hack.ServeRecorder. It is passed to every source
+// that records served metadata and serves the address.
+func (b *Backend) RecordServe(ctx context.Context, ip string) {
+	for _, s := range b.sourcesServing(ip) {
+		if recorder, ok := s.Client.(ec2.ServeRecorder); ok {
+			recorder.RecordServe(ctx, ip)
+		}
+	}
+}
+
+// ForwardEvent satisfies phonehome.Forwarder. The event is passed to every source that forwards
+// events and serves the address.
+func (b *Backend) ForwardEvent(ctx context.Context, e phonehome.Event) {
+	for _, s := range b.sourcesServing(e.IP) {
+		if forwarder, ok := s.Client.(phonehome.Forwarder); ok {
+			forwarder.ForwardEvent(ctx, e)
+		}
+	}
+}
+
+// sourcesServing returns the sources that serve ip.
+func (b *Backend) sourcesServing(ip string) []Source {
+	var addr netip.Addr
+	normalized, err := ipaddr.Normalize(ip)
+	if err == nil {
+		addr, err = netip.ParseAddr(normalized)
+	}
+	valid := err == nil
+
+	var sources []Source
+	for _, s := range b.sources {
+		if s.serves(addr, valid) {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// Start satisfies backend.Runner. It starts all sources that need to run and returns when all
+// sources have returned. When a source returns an error, the remaining sources are stopped and
+// the first error is returned.
+func (b *Backend) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+
+	for _, s := range b.sources {
+		runner, ok := s.Client.(interface{ Start(context.Context) error })
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s Source) {
+			defer wg.Done()
+			if err := runner.Start(ctx); err != nil {
+				once.Do(func() { first = fmt.Errorf("%v: %w", s.Name, err) })
+				cancel()
+			}
+		}(s)
+	}
+
+	wg.Wait()
+
+	return first
+}
+
+// WaitForReady satisfies backend.ReadyWaiter. It waits for all sources to be ready.
+func (b *Backend) WaitForReady(ctx context.Context) bool {
+	for _, s := range b.sources {
+		if waiter, ok := s.Client.(interface{ WaitForReady(context.Context) bool }); ok {
+			if !waiter.WaitForReady(ctx) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsHealthy satisfies healthcheck.Client. The Backend is healthy when all sources are healthy.
+func (b *Backend) IsHealthy(ctx context.Context) bool {
+	for _, s := range b.sources {
+		if !s.Client.IsHealthy(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthChecks satisfies healthcheck.CheckProvider. Each source is reported as a check named
+// after the source. Checks provided by sources are prefixed with the source name.
+func (b *Backend) HealthChecks() []healthcheck.Checker {
+	var checks []healthcheck.Checker
+	for _, s := range b.sources {
+		client := s.Client
+		checks = append(checks, healthcheck.NewChecker(s.Name, func(ctx context.Context) error {
+			if !client.IsHealthy(ctx) {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}))
+
+		if provider, ok := client.(healthcheck.CheckProvider); ok {
+			for _, c := range provider.HealthChecks() {
+				checks = append(checks, healthcheck.NewChecker(s.Name+"/"+c.Name(), c.Check))
+			}
+		}
+	}
+	return checks
+}
+
+// DataAge satisfies healthcheck.DataAgeSource. It returns the largest data age reported by the
+// sources.
+func (b *Backend) DataAge() time.Duration {
+	var age time.Duration
+	for _, s := range b.sources {
+		if source, ok := s.Client.(healthcheck.DataAgeSource); ok {
+			age = max(age, source.DataAge())
+		}
+	}
+	return age
+}
@@ -0,0 +1,301 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+// fakeClient serves instances from a map of IP to instance ID.
+type fakeClient struct {
+	instances map[string]string
+	err       error
+	unhealthy bool
+	calls     int
+}
+
+func (f *fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	f.calls++
+	if f.err != nil {
+		return ec2.Instance{}, f.err
+	}
+	id, ok := f.instances[ip]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	var i ec2.Instance
+	i.Metadata.InstanceID = id
+	return i, nil
+}
+
+func (f *fakeClient) GetHackInstance(_ context.Context, ip string) (hack.Instance, error) {
+	f.calls++
+	if f.err != nil {
+		return hack.Instance{}, f.err
+	}
+	if _, ok := f.instances[ip]; !ok {
+		return hack.Instance{}, hack.ErrInstanceNotFound
+	}
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return !f.unhealthy
+}
+
+func TestBackendGetEC2Instance(t *testing.T) {
+	errBackend := errors.New("backend failure")
+
+	cases := []struct {
+		Name         string
+		Primary      *fakeClient
+		PrimaryCIDRs []string
+		Fallback     *fakeClient
+		IP           string
+		ExpectID     string
+		ExpectSource string
+		Error        error
+	}{
+		{
+			Name:         "Primary",
+			Primary:      &fakeClient{instances: map[string]string{"10.0.0.1": "primary"}},
+			Fallback:     &fakeClient{instances: map[string]string{"10.0.0.1": "fallback"}},
+			IP:           "10.0.0.1",
+			ExpectID:     "primary",
+			ExpectSource: "primary",
+		},
+		{
+			Name:         "Fallback",
+			Primary:      &fakeClient{},
+			Fallback:     &fakeClient{instances: map[string]string{"10.0.0.1": "fallback"}},
+			IP:           "10.0.0.1",
+			ExpectID:     "fallback",
+			ExpectSource: "fallback",
+		},
+		{
+			Name:         "NotFound",
+			Primary:      &fakeClient{},
+			Fallback:     &fakeClient{},
+			IP:           "10.0.0.1",
+			ExpectSource: noSource,
+			Error:        ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:     "PrimaryError",
+			Primary:  &fakeClient{err: errBackend},
+			Fallback: &fakeClient{instances: map[string]string{"10.0.0.1": "fallback"}},
+			IP:       "10.0.0.1",
+			Error:    errBackend,
+		},
+		{
+			Name:         "RoutedToPrimary",
+			Primary:      &fakeClient{instances: map[string]string{"10.0.0.1": "primary"}},
+			PrimaryCIDRs: []string{"10.0.0.0/24"},
+			Fallback:     &fakeClient{instances: map[string]string{"10.0.0.1": "fallback"}},
+			IP:           "10.0.0.1",
+			ExpectID:     "primary",
+			ExpectSource: "primary",
+		},
+		{
+			Name:         "RoutedAroundPrimary",
+			Primary:      &fakeClient{instances: map[string]string{"10.0.1.1": "primary"}},
+			PrimaryCIDRs: []string{"10.0.0.0/24"},
+			Fallback:     &fakeClient{instances: map[string]string{"10.0.1.1": "fallback"}},
+			IP:           "10.0.1.1",
+			ExpectID:     "fallback",
+			ExpectSource: "fallback",
+		},
+		{
+			Name:         "RoutedIPv4MappedIPv6",
+			Primary:      &fakeClient{instances: map[string]string{"::ffff:10.0.0.1": "primary"}},
+			PrimaryCIDRs: []string{"10.0.0.0/24"},
+			Fallback:     &fakeClient{},
+			IP:           "::ffff:10.0.0.1",
+			ExpectID:     "primary",
+			ExpectSource: "primary",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var cidrs []netip.Prefix
+			for _, c := range tc.PrimaryCIDRs {
+				cidrs = append(cidrs, netip.MustParsePrefix(c))
+			}
+
+			registry := prometheus.NewRegistry()
+			backend, err := New([]Source{
+				{Name: "primary", Client: tc.Primary, CIDRs: cidrs},
+				{Name: "fallback", Client: tc.Fallback},
+			}, Options{Registerer: registry})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			instance, err := backend.GetEC2Instance(context.Background(), tc.IP)
+			if !errors.Is(err, tc.Error) {
+				t.Fatalf("Expected error %v; Received %v", tc.Error, err)
+			}
+
+			if instance.Metadata.InstanceID != tc.ExpectID {
+				t.Fatalf("Expected instance %q; Received %q", tc.ExpectID, instance.Metadata.InstanceID)
+			}
+
+			if tc.ExpectSource != "" {
+				count := testutil.ToFloat64(backend.lookups.WithLabelValues("ec2", tc.ExpectSource))
+				if count != 1 {
+					t.Fatalf("Expected 1 lookup answered by %v; Received %v", tc.ExpectSource, count)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendGetHackInstance(t *testing.T) {
+	primary := &fakeClient{}
+	fallback := &fakeClient{instances: map[string]string{"10.0.0.1": "fallback"}}
+
+	backend, err := New([]Source{
+		{Name: "primary", Client: primary},
+		{Name: "fallback", Client: fallback},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.0.2"); !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected hack.ErrInstanceNotFound; Received %v", err)
+	}
+
+	if primary.calls != 2 || fallback.calls != 2 {
+		t.Fatalf("Expected 2 calls to each source; Received %v and %v", primary.calls, fallback.calls)
+	}
+}
+
+func TestBackendInvalidIPSkipsRoutedSources(t *testing.T) {
+	routed := &fakeClient{}
+	backend, err := New([]Source{
+		{Name: "routed", Client: routed, CIDRs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "invalid"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+
+	if routed.calls != 0 {
+		t.Fatalf("Expected routed source not to be called; Received %v calls", routed.calls)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Sources []Source
+	}{
+		{Name: "NoSources"},
+		{Name: "MissingName", Sources: []Source{{Client: &fakeClient{}}}},
+		{Name: "MissingClient", Sources: []Source{{Name: "source"}}},
+		{
+			Name:    "DuplicateName",
+			Sources: []Source{{Name: "source", Client: &fakeClient{}}, {Name: "source", Client: &fakeClient{}}},
+		},
+		{
+			Name:    "InvalidCIDR",
+			Sources: []Source{{Name: "source", Client: &fakeClient{}, CIDRs: []netip.Prefix{{}}}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := New(tc.Sources, Options{}); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
+
+type runnerClient struct {
+	fakeClient
+	err   error
+	ready bool
+	age   time.Duration
+	check healthcheck.Checker
+}
+
+func (r *runnerClient) Start(ctx context.Context) error {
+	if r.err != nil {
+		return r.err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (r *runnerClient) WaitForReady(context.Context) bool { return r.ready }
+
+func (r *runnerClient) DataAge() time.Duration { return r.age }
+
+func (r *runnerClient) HealthChecks() []healthcheck.Checker { return []healthcheck.Checker{r.check} }
+
+func TestBackendLifecycle(t *testing.T) {
+	errStart := errors.New("start failure")
+	failing := &runnerClient{err: errStart, ready: true, age: time.Minute}
+	running := &runnerClient{ready: false, age: time.Second}
+	running.unhealthy = true
+	running.check = healthcheck.NewChecker("api", func(context.Context) error { return nil })
+	failing.check = running.check
+
+	backend, err := New([]Source{
+		{Name: "failing", Client: failing},
+		{Name: "running", Client: running},
+		{Name: "static", Client: &fakeClient{}},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing source stops the other sources.
+	if err := backend.Start(context.Background()); !errors.Is(err, errStart) {
+		t.Fatalf("Expected start error; Received %v", err)
+	}
+
+	if backend.WaitForReady(context.Background()) {
+		t.Fatal("Expected not ready")
+	}
+
+	if backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected unhealthy")
+	}
+
+	if age := backend.DataAge(); age != time.Minute {
+		t.Fatalf("Expected data age 1m; Received %v", age)
+	}
+
+	var names []string
+	for _, c := range backend.HealthChecks() {
+		names = append(names, c.Name())
+	}
+	expect := []string{"failing", "failing/api", "running", "running/api", "static"}
+	if len(names) != len(expect) {
+		t.Fatalf("Expected checks %v; Received %v", expect, names)
+	}
+	for i := range expect {
+		if names[i] != expect[i] {
+			t.Fatalf("Expected checks %v; Received %v", expect, names)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Package crd provides a backend that serves metadata from any Kubernetes resource, such as a
+Metal3 BareMetalHost or a custom resource. Resources are retrieved using the unstructured client
+and mapped to metadata using the JSONPath expressions of a Mapping. Resources are mapped as they
+change so lookups needn't evaluate expressions.
+*/
+package crd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	errNotFound         = errors.New("no resource found")
+	errMultipleResource = errors.New("multiple resources found")
+)
+
+// Config configures a Backend.
+type Config struct {
+	// Kubeconfig is a path to a valid kubeconfig file. When in-cluster defaults to the in-cluster
+	// config. Optional.
+	Kubeconfig string
+
+	// APIServerAddress is the address of the kubernetes cluster (https://hostname:port). Optional.
+	APIServerAddress string
+
+	// Mapping identifies the resource served and how it is mapped to metadata.
+	Mapping Mapping
+
+	// Logger is used to log backend events. Optional.
+	Logger logr.Logger
+
+	// ClientConfig is a Kubernetes client config. If specified, it will be used instead of
+	// constructing a client using Kubeconfig and APIServerAddress. Optional.
+	ClientConfig *rest.Config
+}
+
+// Backend serves metadata from Kubernetes resources.
+type Backend struct {
+	cache  cache.Cache
+	store  *store
+	mapper *mapper
+}
+
+// NewBackend creates a Backend. The Backend doesn't synchronize with the cluster until Start is
+// called.
+func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
+	gvk := schema.FromAPIVersionAndKind(cfg.Mapping.APIVersion, cfg.Mapping.Kind)
+	if gvk.Version == "" || gvk.Kind == "" {
+		return nil, errors.New("mapping apiVersion and kind are required")
+	}
+
+	m, err := newMapper(cfg.Mapping.Fields)
+	if err != nil {
+		return nil, fmt.Errorf("mapping: %v", err)
+	}
+
+	if cfg.Logger.GetSink() == nil {
+		cfg.Logger = logr.Discard()
+	}
+
+	if cfg.ClientConfig == nil {
+		if cfg.ClientConfig, err = loadConfig(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	cacheOpts, err := cacheOptions(cfg.Mapping, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cache.New(cfg.ClientConfig, cacheOpts)
+	if err != nil {
+		return nil, fmt.Errorf("create cache: %v", err)
+	}
+
+	informer, err := c.GetInformer(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("get %v informer: %v", gvk.Kind, err)
+	}
+
+	s := newStore(m, cfg.Logger)
+	registration, err := informer.AddEventHandler(s)
+	if err != nil {
+		return nil, fmt.Errorf("add %v event handler: %v", gvk.Kind, err)
+	}
+	s.synced = registration.HasSynced
+
+	return &Backend{cache: c, store: s, mapper: m}, nil
+}
+
+func cacheOptions(m Mapping, obj crclient.Object) (cache.Options, error) {
+	var opts cache.Options
+	var byObject cache.ByObject
+
+	for _, ns := range m.Namespaces {
+		if opts.DefaultNamespaces == nil {
+			opts.DefaultNamespaces = map[string]cache.Config{}
+		}
+		opts.DefaultNamespaces[ns] = cache.Config{}
+	}
+
+	if m.LabelSelector != "" {
+		selector, err := labels.Parse(m.LabelSelector)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("parse label selector: %v", err)
+		}
+		byObject.Label = selector
+	}
+
+	// Drop fields that are never mapped to reduce memory usage.
+	byObject.Transform = func(in interface{}) (interface{}, error) {
+		if u, ok := in.(*unstructured.Unstructured); ok {
+			u.SetManagedFields(nil)
+		}
+		return in, nil
+	}
+
+	opts.ByObject = map[crclient.Object]cache.ByObject{obj: byObject}
+
+	return opts, nil
+}
+
+func loadConfig(cfg Config) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = cfg.Kubeconfig
+
+	overrides := &clientcmd.ConfigOverrides{
+		ClusterInfo: clientcmdapi.Cluster{
+			Server: cfg.APIServerAddress,
+		},
+	}
+
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	return loader.ClientConfig()
+}
+
+// Start satisfies backend.Runner. It synchronizes the Backend with the cluster and blocks until
+// ctx is cancelled or the synchronization fails.
+func (b *Backend) Start(ctx context.Context) error {
+	return b.cache.Start(ctx)
+}
+
+// WaitForReady satisfies backend.ReadyWaiter. The Backend is ready once it has mapped the initial
+// list of resources.
+func (b *Backend) WaitForReady(ctx context.Context) bool {
+	return toolscache.WaitForCacheSync(ctx.Done(), b.store.synced)
+}
+
+// IsHealthy satisfies healthcheck.Client. The Backend is healthy once it has mapped the initial
+// list of resources.
+func (b *Backend) IsHealthy(context.Context) bool {
+	return b.store.synced()
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	i, err := b.store.lookup(ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return i.ec2, nil
+}
+
+// GetHackInstance satisfies hack.Client. It returns hack.ErrUnsupported if the mapping doesn't
+// map storage.
+func (b *Backend) GetHackInstance(_ context.Context, ip string) (hack.Instance, error) {
+	if !b.mapper.supportsHack() {
+		return hack.Instance{}, hack.ErrUnsupported
+	}
+
+	i, err := b.store.lookup(ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return hack.Instance{}, hack.ErrInstanceNotFound
+		}
+
+		return hack.Instance{}, err
+	}
+
+	return i.hack, nil
+}
+
+// instance holds the frontend models for a resource.
+type instance struct {
+	ips  []string
+	ec2  ec2.Instance
+	hack hack.Instance
+}
+
+// store maintains the frontend models for every resource in the cache. It satisfies
+// k8s.io/client-go/tools/cache.ResourceEventHandler and is updated as resources change.
+type store struct {
+	mapper *mapper
+	logger logr.Logger
+
+	mtx   sync.RWMutex
+	byKey map[types.NamespacedName]*instance
+	byIP  map[string][]*instance
+
+	// synced reports whether the store has observed the initial list of resources.
+	synced func() bool
+}
+
+func newStore(m *mapper, logger logr.Logger) *store {
+	return &store{
+		mapper: m,
+		logger: logger,
+		byKey:  map[types.NamespacedName]*instance{},
+		byIP:   map[string][]*instance{},
+		synced: func() bool { return false },
+	}
+}
+
+// OnAdd satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *store) OnAdd(obj interface{}, _ bool) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		s.set(u)
+	}
+}
+
+// OnUpdate satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *store) OnUpdate(_, obj interface{}) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		s.set(u)
+	}
+}
+
+// OnDelete satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *store) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.removeLocked(types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()})
+	}
+}
+
+func (s *store) set(u *unstructured.Unstructured) {
+	key := types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}
+
+	i, err := s.mapper.toInstance(u.Object)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.removeLocked(key)
+
+	// A resource that can't be mapped isn't served so stale metadata isn't served in its place.
+	if err != nil {
+		s.logger.Error(err, "Mapping resource", "namespace", key.Namespace, "name", key.Name)
+		return
+	}
+
+	s.byKey[key] = i
+	for _, ip := range i.ips {
+		s.byIP[ip] = append(s.byIP[ip], i)
+	}
+}
+
+// removeLocked removes the instance for key. s.mtx must be held.
+func (s *store) removeLocked(key types.NamespacedName) {
+	existing, ok := s.byKey[key]
+	if !ok {
+		return
+	}
+
+	delete(s.byKey, key)
+	for _, ip := range existing.ips {
+		entries := s.byIP[ip]
+		for idx, e := range entries {
+			if e == existing {
+				entries = append(entries[:idx:idx], entries[idx+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(s.byIP, ip)
+		} else {
+			s.byIP[ip] = entries
+		}
+	}
+}
+
+func (s *store) lookup(ip string) (*instance, error) {
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return nil, errNotFound
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	entries := s.byIP[normalized]
+	switch len(entries) {
+	case 0:
+		return nil, errNotFound
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("%w: ip %v", errMultipleResource, normalized)
+	}
+}
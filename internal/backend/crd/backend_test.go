@@ -0,0 +1,151 @@
+package crd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+func newTestBackend(t *testing.T, path string) *Backend {
+	t.Helper()
+
+	_, m := loadMapper(t, path)
+	s := newStore(m, logr.Discard())
+	s.synced = func() bool { return true }
+
+	return &Backend{store: s, mapper: m}
+}
+
+func TestBackendLookups(t *testing.T) {
+	backend := newTestBackend(t, "testdata/hardware.yaml")
+
+	hw := decode(t, testHardware)
+	backend.store.OnAdd(hw, true)
+
+	for _, addr := range []string{"10.0.0.1", "::ffff:10.0.0.1", "203.0.113.1", "2001:db8::1"} {
+		instance, err := backend.GetEC2Instance(context.Background(), addr)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", addr, err)
+		}
+		if instance.Metadata.InstanceID != "instance-1" {
+			t.Fatalf("%v: Expected instance-1; Received %q", addr, instance.Metadata.InstanceID)
+		}
+	}
+
+	hackInstance, err := backend.GetHackInstance(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(hackInstance.Metadata.Instance.Storage.Disks) != 1 {
+		t.Fatalf("Expected 1 disk; Received %v", len(hackInstance.Metadata.Instance.Storage.Disks))
+	}
+
+	// Updates replace the addresses the resource is served by.
+	updated := hw.DeepCopy()
+	err = unstructured.SetNestedSlice(updated.Object, []interface{}{}, "spec", "metadata", "instance", "ips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.store.OnUpdate(hw, updated)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "203.0.113.1"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Deletes, including those observed as tombstones, remove the resource.
+	backend.store.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/machine1", Obj: updated})
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.0.1"); !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected hack.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+func TestBackendMultipleResources(t *testing.T) {
+	backend := newTestBackend(t, "testdata/hardware.yaml")
+
+	hw := decode(t, testHardware)
+	backend.store.OnAdd(hw, true)
+
+	other := hw.DeepCopy()
+	other.SetName("machine2")
+	backend.store.OnAdd(other, true)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, errMultipleResource) {
+		t.Fatalf("Expected errMultipleResource; Received %v", err)
+	}
+
+	backend.store.OnDelete(other)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestBackendUnmappableResource(t *testing.T) {
+	backend := newTestBackend(t, "testdata/hardware.yaml")
+
+	hw := decode(t, testHardware)
+	backend.store.OnAdd(hw, true)
+
+	// A resource that can no longer be mapped stops being served.
+	broken := hw.DeepCopy()
+	err := unstructured.SetNestedField(broken.Object, map[string]interface{}{"nested": "value"}, "spec", "userData")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.store.OnUpdate(hw, broken)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+func TestBackendHackUnsupported(t *testing.T) {
+	backend := newTestBackend(t, "testdata/baremetalhost.yaml")
+	backend.store.OnAdd(decode(t, testBareMetalHost), true)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "192.168.111.20"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := backend.GetHackInstance(context.Background(), "192.168.111.20"); !errors.Is(err, hack.ErrUnsupported) {
+		t.Fatalf("Expected hack.ErrUnsupported; Received %v", err)
+	}
+}
+
+func TestNewBackendErrors(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Mapping Mapping
+	}{
+		{Name: "MissingKind", Mapping: Mapping{APIVersion: "metal3.io/v1alpha1"}},
+		{Name: "MissingAPIVersion", Mapping: Mapping{Kind: "BareMetalHost"}},
+		{
+			Name: "InvalidFields",
+			Mapping: Mapping{
+				APIVersion: "metal3.io/v1alpha1",
+				Kind:       "BareMetalHost",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := NewBackend(context.Background(), Config{Mapping: tc.Mapping}); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package crd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Mapping describes the resource served by the backend and how its fields map to metadata. See
+// docs/crd-backend.md for examples.
+type Mapping struct {
+	// APIVersion and Kind identify the resource, for example metal3.io/v1alpha1 and
+	// BareMetalHost.
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+
+	// Namespaces restricts the resources served to the namespaces. When empty, resources are
+	// served from all namespaces. Optional.
+	Namespaces []string `yaml:"namespaces"`
+
+	// LabelSelector restricts the resources served to those matching the selector. Optional.
+	LabelSelector string `yaml:"labelSelector"`
+
+	Fields Fields `yaml:"fields"`
+}
+
+// Fields contains JSONPath expressions, in the format used by kubectl, evaluated against a
+// resource to produce metadata. Empty expressions produce empty metadata.
+type Fields struct {
+	// IPs are expressions producing addresses the resource is served by.
+	IPs []string `yaml:"ips"`
+
+	InstanceID string `yaml:"instanceID"`
+	Hostname   string `yaml:"hostname"`
+
+	// LocalHostname defaults to the Hostname when empty.
+	LocalHostname string `yaml:"localHostname"`
+
+	IQN      string `yaml:"iqn"`
+	Plan     string `yaml:"plan"`
+	Facility string `yaml:"facility"`
+	Userdata string `yaml:"userdata"`
+
+	// Tags and SSHKeys may produce multiple values.
+	Tags    string `yaml:"tags"`
+	SSHKeys string `yaml:"sshKeys"`
+
+	OS struct {
+		Slug                   string `yaml:"slug"`
+		Distro                 string `yaml:"distro"`
+		Version                string `yaml:"version"`
+		ImageTag               string `yaml:"imageTag"`
+		LicenseActivationState string `yaml:"licenseActivationState"`
+	} `yaml:"os"`
+
+	Interfaces InterfaceFields `yaml:"interfaces"`
+
+	// Storage produces an object in the hack frontend storage format. When empty, the hack
+	// frontend is unsupported.
+	Storage string `yaml:"storage"`
+}
+
+// InterfaceFields maps network interfaces. Interface addresses are served by the resource in
+// addition to Fields.IPs.
+type InterfaceFields struct {
+	// Path produces the interface objects.
+	Path string `yaml:"path"`
+
+	// Address and Public are evaluated against each interface object. Public produces a boolean
+	// indicating whether the address is publicly routable.
+	Address string `yaml:"address"`
+	Public  string `yaml:"public"`
+}
+
+// LoadMapping reads a YAML encoded Mapping from path.
+func LoadMapping(path string) (Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	var m Mapping
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return Mapping{}, fmt.Errorf("decode mapping: %v", err)
+	}
+
+	return m, nil
+}
+
+// expr is a compiled JSONPath expression. A nil expr produces no values.
+type expr struct {
+	name string
+
+	// JSONPath retains state while evaluating so evaluations must be serialized.
+	mtx sync.Mutex
+	jp  *jsonpath.JSONPath
+}
+
+func compile(name, text string) (*expr, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	// Accept expressions with or without the surrounding braces.
+	if !strings.Contains(text, "{") {
+		text = "{" + text + "}"
+	}
+
+	jp := jsonpath.New(name).AllowMissingKeys(true)
+	if err := jp.Parse(text); err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+
+	return &expr{name: name, jp: jp}, nil
+}
+
+// values evaluates e against data. Lists produced by the expression are flattened.
+func (e *expr) values(data interface{}) ([]interface{}, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	e.mtx.Lock()
+	results, err := e.jp.FindResults(data)
+	e.mtx.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", e.name, err)
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, r := range result {
+			if !r.IsValid() || (r.Kind() == reflect.Interface && r.IsNil()) {
+				continue
+			}
+
+			v := r.Interface()
+			if list, ok := v.([]interface{}); ok {
+				values = append(values, list...)
+				continue
+			}
+			values = append(values, v)
+		}
+	}
+
+	return values, nil
+}
+
+func (e *expr) strings(data interface{}) ([]string, error) {
+	values, err := e.values(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			result = append(result, v)
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%v: expected scalar; received %T", e.name, v)
+		default:
+			result = append(result, fmt.Sprint(v))
+		}
+	}
+
+	return result, nil
+}
+
+func (e *expr) string(data interface{}) (string, error) {
+	values, err := e.strings(data)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	return values[0], nil
+}
+
+func (e *expr) bool(data interface{}) (bool, error) {
+	value, err := e.string(data)
+	if err != nil || value == "" {
+		return false, err
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", e.name, err)
+	}
+	return b, nil
+}
+
+// mapper maps resources to frontend models using compiled Fields.
+type mapper struct {
+	ips []*expr
+
+	instanceID, hostname, localHostname, iqn, plan, facility, userdata *expr
+	tags, sshKeys                                                      *expr
+	osSlug, osDistro, osVersion, osImageTag, osLicenseActivationState  *expr
+
+	interfaces, interfaceAddress, interfacePublic *expr
+
+	storage *expr
+}
+
+func newMapper(f Fields) (*mapper, error) {
+	if len(f.IPs) == 0 && f.Interfaces.Address == "" {
+		return nil, errors.New("mapping must specify ips or interfaces.address")
+	}
+
+	if f.Interfaces.Address != "" && f.Interfaces.Path == "" {
+		return nil, errors.New("interfaces.path is required with interfaces.address")
+	}
+
+	m := &mapper{}
+
+	for idx, text := range f.IPs {
+		e, err := compile(fmt.Sprintf("ips[%v]", idx), text)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			m.ips = append(m.ips, e)
+		}
+	}
+
+	fields := []struct {
+		name   string
+		text   string
+		target **expr
+	}{
+		{"instanceID", f.InstanceID, &m.instanceID},
+		{"hostname", f.Hostname, &m.hostname},
+		{"localHostname", f.LocalHostname, &m.localHostname},
+		{"iqn", f.IQN, &m.iqn},
+		{"plan", f.Plan, &m.plan},
+		{"facility", f.Facility, &m.facility},
+		{"userdata", f.Userdata, &m.userdata},
+		{"tags", f.Tags, &m.tags},
+		{"sshKeys", f.SSHKeys, &m.sshKeys},
+		{"os.slug", f.OS.Slug, &m.osSlug},
+		{"os.distro", f.OS.Distro, &m.osDistro},
+		{"os.version", f.OS.Version, &m.osVersion},
+		{"os.imageTag", f.OS.ImageTag, &m.osImageTag},
+		{"os.licenseActivationState", f.OS.LicenseActivationState, &m.osLicenseActivationState},
+		{"interfaces.path", f.Interfaces.Path, &m.interfaces},
+		{"interfaces.address", f.Interfaces.Address, &m.interfaceAddress},
+		{"interfaces.public", f.Interfaces.Public, &m.interfacePublic},
+		{"storage", f.Storage, &m.storage},
+	}
+
+	for _, field := range fields {
+		e, err := compile(field.name, field.text)
+		if err != nil {
+			return nil, err
+		}
+		*field.target = e
+	}
+
+	return m, nil
+}
+
+// supportsHack reports whether resources are mapped to hack instances.
+func (m *mapper) supportsHack() bool {
+	return m.storage != nil
+}
+
+// toInstance maps the unstructured content of a resource to frontend models.
+func (m *mapper) toInstance(obj map[string]interface{}) (*instance, error) {
+	i := &instance{}
+	md := &i.ec2.Metadata
+
+	scalars := []struct {
+		expr   *expr
+		target *string
+	}{
+		{m.instanceID, &md.InstanceID},
+		{m.hostname, &md.Hostname},
+		{m.localHostname, &md.LocalHostname},
+		{m.iqn, &md.IQN},
+		{m.plan, &md.Plan},
+		{m.facility, &md.Facility},
+		{m.userdata, &i.ec2.Userdata},
+		{m.osSlug, &md.OperatingSystem.Slug},
+		{m.osDistro, &md.OperatingSystem.Distro},
+		{m.osVersion, &md.OperatingSystem.Version},
+		{m.osImageTag, &md.OperatingSystem.ImageTag},
+		{m.osLicenseActivationState, &md.OperatingSystem.LicenseActivation.State},
+	}
+
+	for _, s := range scalars {
+		v, err := s.expr.string(obj)
+		if err != nil {
+			return nil, err
+		}
+		*s.target = v
+	}
+
+	if md.LocalHostname == "" {
+		md.LocalHostname = md.Hostname
+	}
+
+	var err error
+	if md.Tags, err = m.tags.strings(obj); err != nil {
+		return nil, err
+	}
+
+	if md.PublicKeys, err = m.sshKeys.strings(obj); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, e := range m.ips {
+		values, err := e.strings(obj)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, values...)
+	}
+
+	ifaceAddrs, err := m.mapInterfaces(obj, &i.ec2)
+	if err != nil {
+		return nil, err
+	}
+	i.ips = normalize(append(addrs, ifaceAddrs...))
+
+	if m.storage != nil {
+		if i.hack, err = m.mapStorage(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+// mapInterfaces returns the interface addresses in obj. The first address of each family and
+// visibility is used as the corresponding instance address.
+func (m *mapper) mapInterfaces(obj map[string]interface{}, instance *ec2.Instance) ([]string, error) {
+	ifaces, err := m.interfaces.values(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &instance.Metadata
+
+	var addrs []string
+	for _, iface := range ifaces {
+		address, err := m.interfaceAddress.string(iface)
+		if err != nil {
+			return nil, err
+		}
+
+		normalized, err := ipaddr.Normalize(address)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, normalized)
+
+		public, err := m.interfacePublic.bool(iface)
+		if err != nil {
+			return nil, err
+		}
+
+		is4 := !strings.Contains(normalized, ":")
+		switch {
+		case is4 && public && md.PublicIPv4 == "":
+			md.PublicIPv4 = normalized
+		case is4 && !public && md.LocalIPv4 == "":
+			md.LocalIPv4 = normalized
+		case !is4 && md.PublicIPv6 == "":
+			md.PublicIPv6 = normalized
+		}
+	}
+
+	return addrs, nil
+}
+
+func (m *mapper) mapStorage(obj map[string]interface{}) (hack.Instance, error) {
+	var h hack.Instance
+
+	values, err := m.storage.values(obj)
+	if err != nil || len(values) == 0 {
+		return h, err
+	}
+
+	// Round trip through JSON to decode the unstructured storage into the hack model.
+	data, err := json.Marshal(values[0])
+	if err != nil {
+		return h, fmt.Errorf("storage: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &h.Metadata.Instance.Storage); err != nil {
+		return h, fmt.Errorf("storage: %v", err)
+	}
+
+	return h, nil
+}
+
+// normalize returns the normalized, de-duplicated addresses. Invalid addresses are ignored.
+func normalize(addrs []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, addr := range addrs {
+		normalized, err := ipaddr.Normalize(addr)
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		result = append(result, normalized)
+	}
+	return result
+}
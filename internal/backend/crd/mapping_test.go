@@ -0,0 +1,273 @@
+package crd
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const testHardware = `
+apiVersion: tinkerbell.org/v1alpha1
+kind: Hardware
+metadata:
+  name: machine1
+  namespace: default
+spec:
+  userData: "#cloud-config"
+  interfaces:
+    - dhcp:
+        mac: "00:00:00:00:00:01"
+        ip:
+          address: 10.0.0.1
+  metadata:
+    facility:
+      plan_slug: c3.small
+      facility_code: dc1
+    instance:
+      id: instance-1
+      hostname: machine1
+      tags: [foo, bar]
+      ssh_keys: [ssh-ed25519 AAAA]
+      operating_system:
+        slug: ubuntu_22_04
+        distro: ubuntu
+        version: "22.04"
+        image_tag: latest
+      ips:
+        - address: 10.0.0.1
+          family: 4
+        - address: 203.0.113.1
+          family: 4
+          public: true
+        - address: 2001:DB8::1
+          family: 6
+          public: true
+      storage:
+        disks:
+          - device: /dev/sda
+            wipe_table: true
+            partitions:
+              - label: ROOT
+                number: 1
+        filesystems:
+          - mount:
+              device: /dev/sda1
+              format: ext4
+              point: /
+              create:
+                options: [-L, ROOT]
+`
+
+const testBareMetalHost = `
+apiVersion: metal3.io/v1alpha1
+kind: BareMetalHost
+metadata:
+  name: host-0
+  namespace: metal3
+  uid: 6d4c0b5e-0000-0000-0000-000000000000
+  annotations:
+    hegel.tinkerbell.org/tags: rack-1
+status:
+  hardware:
+    nics:
+      - name: eth0
+        mac: "00:00:00:00:00:01"
+        ip: 192.168.111.20
+      - name: eth1
+        mac: "00:00:00:00:00:02"
+        ip: fd2e:6f44:5dd8:c956::14
+      - name: eth2
+        mac: "00:00:00:00:00:03"
+`
+
+// decode decodes a YAML resource the way resources received from the API server are decoded.
+func decode(t *testing.T, data string) *unstructured.Unstructured {
+	t.Helper()
+
+	raw, err := yaml.ToJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func loadMapper(t *testing.T, path string) (Mapping, *mapper) {
+	t.Helper()
+
+	mapping, err := LoadMapping(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	m, err := newMapper(mapping.Fields)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return mapping, m
+}
+
+func TestMapperHardware(t *testing.T) {
+	_, m := loadMapper(t, "testdata/hardware.yaml")
+
+	i, err := m.toInstance(decode(t, testHardware).Object)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expectEC2 ec2.Instance
+	expectEC2.Userdata = "#cloud-config"
+	expectEC2.Metadata.InstanceID = "instance-1"
+	expectEC2.Metadata.Hostname = "machine1"
+	expectEC2.Metadata.LocalHostname = "machine1"
+	expectEC2.Metadata.Plan = "c3.small"
+	expectEC2.Metadata.Facility = "dc1"
+	expectEC2.Metadata.Tags = []string{"foo", "bar"}
+	expectEC2.Metadata.PublicKeys = []string{"ssh-ed25519 AAAA"}
+	expectEC2.Metadata.LocalIPv4 = "10.0.0.1"
+	expectEC2.Metadata.PublicIPv4 = "203.0.113.1"
+	expectEC2.Metadata.PublicIPv6 = "2001:db8::1"
+	expectEC2.Metadata.OperatingSystem.Slug = "ubuntu_22_04"
+	expectEC2.Metadata.OperatingSystem.Distro = "ubuntu"
+	expectEC2.Metadata.OperatingSystem.Version = "22.04"
+	expectEC2.Metadata.OperatingSystem.ImageTag = "latest"
+
+	if diff := cmp.Diff(expectEC2, i.ec2); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if diff := cmp.Diff([]string{"10.0.0.1", "203.0.113.1", "2001:db8::1"}, i.ips); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var fs hack.Filesystem
+	fs.Mount.Device = "/dev/sda1"
+	fs.Mount.Format = "ext4"
+	fs.Mount.Point = "/"
+	fs.Mount.Create.Options = []string{"-L", "ROOT"}
+	expectStorage := hack.Storage{
+		Disks: []hack.Disk{{
+			Device:     "/dev/sda",
+			WipeTable:  true,
+			Partitions: []hack.Partition{{Label: "ROOT", Number: 1}},
+		}},
+		Filesystems: []hack.Filesystem{fs},
+	}
+	if diff := cmp.Diff(expectStorage, i.hack.Metadata.Instance.Storage); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestMapperBareMetalHost(t *testing.T) {
+	mapping, m := loadMapper(t, "testdata/baremetalhost.yaml")
+
+	if mapping.APIVersion != "metal3.io/v1alpha1" || mapping.Kind != "BareMetalHost" {
+		t.Fatalf("Unexpected resource: %v %v", mapping.APIVersion, mapping.Kind)
+	}
+
+	if m.supportsHack() {
+		t.Fatal("Expected hack to be unsupported without a storage mapping")
+	}
+
+	i, err := m.toInstance(decode(t, testBareMetalHost).Object)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expectEC2 ec2.Instance
+	expectEC2.Metadata.InstanceID = "6d4c0b5e-0000-0000-0000-000000000000"
+	expectEC2.Metadata.Hostname = "host-0"
+	expectEC2.Metadata.LocalHostname = "host-0"
+	expectEC2.Metadata.Tags = []string{"rack-1"}
+	expectEC2.Metadata.LocalIPv4 = "192.168.111.20"
+	expectEC2.Metadata.PublicIPv6 = "fd2e:6f44:5dd8:c956::14"
+
+	if diff := cmp.Diff(expectEC2, i.ec2); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if diff := cmp.Diff([]string{"192.168.111.20", "fd2e:6f44:5dd8:c956::14"}, i.ips); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestMapperErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Fields Fields
+		Object string
+
+		// ExpectMapError indicates the error is expected when mapping Object rather than when
+		// compiling Fields.
+		ExpectMapError bool
+	}{
+		{
+			Name:   "NoIPs",
+			Fields: Fields{Hostname: ".metadata.name"},
+		},
+		{
+			Name:   "InterfaceAddressWithoutPath",
+			Fields: Fields{Interfaces: InterfaceFields{Address: ".ip"}},
+		},
+		{
+			Name:   "InvalidExpression",
+			Fields: Fields{IPs: []string{"{.spec.ips[}"}},
+		},
+		{
+			Name:           "NonScalarHostname",
+			Fields:         Fields{IPs: []string{".spec.ip"}, Hostname: ".spec"},
+			Object:         `{"apiVersion": "v1", "kind": "Test", "spec": {"ip": "10.0.0.1"}}`,
+			ExpectMapError: true,
+		},
+		{
+			Name: "InvalidPublic",
+			Fields: Fields{Interfaces: InterfaceFields{
+				Path:    ".spec.interfaces[*]",
+				Address: ".ip",
+				Public:  ".public",
+			}},
+			Object:         `{"apiVersion": "v1", "kind": "Test", "spec": {"interfaces": [{"ip": "10.0.0.1", "public": "maybe"}]}}`,
+			ExpectMapError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			m, err := newMapper(tc.Fields)
+			if !tc.ExpectMapError {
+				if err == nil {
+					t.Fatal("Expected error; Received nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if _, err := m.toInstance(decode(t, tc.Object).Object); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
+
+func TestLoadMappingErrors(t *testing.T) {
+	if _, err := LoadMapping("testdata/missing.yaml"); err == nil {
+		t.Fatal("Expected error for a missing file; Received nil")
+	}
+
+	// Unknown fields are rejected so typos don't silently produce empty metadata.
+	if _, err := LoadMapping("testdata/unknown-field.yaml"); err == nil {
+		t.Fatal("Expected error for an unknown field; Received nil")
+	}
+}
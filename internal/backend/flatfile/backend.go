@@ -2,24 +2,35 @@ package flatfile
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 )
 
+// ErrDuplicateIP indicates more than one instance claims the same IP address.
+var ErrDuplicateIP = errors.New("ip address claimed by multiple instances")
+
 // Backend is a file-based implementation of a backend. It's primary use-case is testing.
 type Backend struct {
-	// Map of IPv4 addresses to instances.
+	// Map of IP addresses to instances. Every address an instance owns is a key.
 	instances map[string]Instance
 }
 
-// New returns a new instance of Backend.
-func NewBackend(instances []Instance) *Backend {
-	return &Backend{instances: toIPInstanceMap(instances)}
+// NewBackend returns a new instance of Backend. It returns an error if any instance has an
+// invalid IP address or if multiple instances claim the same IP address.
+func NewBackend(instances []Instance) (*Backend, error) {
+	m, err := toIPInstanceMap(instances)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{instances: m}, nil
 }
 
-// RetrieveEC2InstanceByIP satisfies ec2.Client.
+// GetEC2Instance satisfies ec2.Client.
 func (b *Backend) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
-	hw, ok := b.instances[ip]
+	hw, ok := b.lookup(ip)
 	if !ok {
 		return ec2.Instance{}, ec2.ErrInstanceNotFound
 	}
@@ -32,6 +43,15 @@ func (b *Backend) IsHealthy(context.Context) bool {
 	return true
 }
 
+func (b *Backend) lookup(ip string) (Instance, bool) {
+	key, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return Instance{}, false
+	}
+	i, ok := b.instances[key]
+	return i, ok
+}
+
 func toEC2Instance(i Instance) ec2.Instance {
 	return ec2.Instance{
 		Userdata: i.Userdata,
@@ -61,6 +81,10 @@ func toEC2Instance(i Instance) ec2.Instance {
 
 // Instance is a representation of a machine instance.
 type Instance struct {
+	// IPs is a list of additional addresses the instance may use to reach Hegel. The addresses
+	// in Metadata are always used for lookups so they needn't be repeated here.
+	IPs []string `yaml:"ips"`
+
 	Userdata string `yaml:"userdata"`
 	Metadata struct {
 		ID            string   `yaml:"id"`
@@ -84,13 +108,87 @@ type Instance struct {
 			ImageTag               string `yaml:"imageTag"`
 			LicenseActivationState string `yaml:"licenseActivationState"`
 		} `yaml:"os"`
+		Storage Storage `yaml:"storage"`
 	} `yaml:"metadata"`
 }
 
-func toIPInstanceMap(instances []Instance) map[string]Instance {
+// Storage describes the disks and filesystems of an instance. It is served by the hack frontend.
+type Storage struct {
+	Disks []struct {
+		Device     string `yaml:"device"`
+		WipeTable  bool   `yaml:"wipeTable"`
+		Partitions []struct {
+			Label  string `yaml:"label"`
+			Number int    `yaml:"number"`
+			Size   uint64 `yaml:"size"`
+		} `yaml:"partitions"`
+	} `yaml:"disks"`
+	Filesystems []struct {
+		Mount struct {
+			Device        string   `yaml:"device"`
+			Format        string   `yaml:"format"`
+			Point         string   `yaml:"point"`
+			CreateOptions []string `yaml:"createOptions"`
+		} `yaml:"mount"`
+	} `yaml:"filesystems"`
+}
+
+// addresses returns every non-empty address i owns.
+func (i Instance) addresses() []string {
+	candidates := append([]string{
+		i.Metadata.IPv4.Public,
+		i.Metadata.IPv4.Local,
+		i.Metadata.IPv6.Public,
+	}, i.IPs...)
+
+	var addrs []string
+	for _, c := range candidates {
+		if c != "" {
+			addrs = append(addrs, c)
+		}
+	}
+	return addrs
+}
+
+// toIPInstanceMap indexes instances by every address they own. All invalid and duplicate
+// addresses are reported in the returned error.
+func toIPInstanceMap(instances []Instance) (map[string]Instance, error) {
 	m := make(map[string]Instance, len(instances))
-	for _, i := range instances {
-		m[i.Metadata.IPv4.Public] = i
+
+	// owners tracks the index of the instance that claimed an address so we can tolerate an
+	// instance listing the same address more than once.
+	owners := make(map[string]int, len(instances))
+
+	var errs []error
+	for idx, i := range instances {
+		for _, addr := range i.addresses() {
+			key, err := ipaddr.Normalize(addr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("instance %q: %w", i.Metadata.ID, err))
+				continue
+			}
+
+			if owner, ok := owners[key]; ok {
+				if owner != idx {
+					errs = append(errs, fmt.Errorf(
+						"%w: %v (instances %q and %q)",
+						ErrDuplicateIP,
+						key,
+						instances[owner].Metadata.ID,
+						i.Metadata.ID,
+					))
+				}
+				continue
+			}
+
+			owners[key] = idx
+			m[key] = i
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
-	return m
+
+	return m, nil
 }
@@ -16,6 +16,31 @@ func TestGetEC2Instance(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	expect := &ec2.Instance{
+		Userdata: "test",
+		Metadata: ec2.Metadata{
+			InstanceID:    "instanceid",
+			Hostname:      "hostname",
+			LocalHostname: "localhostname",
+			IQN:           "iqn",
+			Plan:          "plan",
+			Facility:      "facility",
+			Tags:          []string{"foo", "bar"},
+			OperatingSystem: ec2.OperatingSystem{
+				Slug:     "slug",
+				Distro:   "distro",
+				Version:  "version",
+				ImageTag: "imagetag",
+				LicenseActivation: ec2.LicenseActivation{
+					State: "licenseactivationstate",
+				},
+			},
+			PublicIPv4: "10.10.10.10",
+			PublicIPv6: "2001:db8:0:1:1:1:1:1",
+			LocalIPv4:  "10.10.10.11",
+		},
+	}
+
 	cases := []struct {
 		Name             string
 		LookupIP         string
@@ -23,32 +48,34 @@ func TestGetEC2Instance(t *testing.T) {
 		ExpectedError    error
 	}{
 		{
-			Name:     "IPFound",
-			LookupIP: "10.10.10.10",
-			ExpectedInstance: &ec2.Instance{
-				Userdata: "test",
-				Metadata: ec2.Metadata{
-					InstanceID:    "instanceid",
-					Hostname:      "hostname",
-					LocalHostname: "localhostname",
-					IQN:           "iqn",
-					Plan:          "plan",
-					Facility:      "facility",
-					Tags:          []string{"foo", "bar"},
-					OperatingSystem: ec2.OperatingSystem{
-						Slug:     "slug",
-						Distro:   "distro",
-						Version:  "version",
-						ImageTag: "imagetag",
-						LicenseActivation: ec2.LicenseActivation{
-							State: "licenseactivationstate",
-						},
-					},
-					PublicIPv4: "10.10.10.10",
-					PublicIPv6: "2001:db8:0:1:1:1:1:1",
-					LocalIPv4:  "10.10.10.11",
-				},
-			},
+			Name:             "IPFound",
+			LookupIP:         "10.10.10.10",
+			ExpectedInstance: expect,
+		},
+		{
+			Name:             "LocalIPv4Found",
+			LookupIP:         "10.10.10.11",
+			ExpectedInstance: expect,
+		},
+		{
+			Name:             "PublicIPv6Found",
+			LookupIP:         "2001:db8:0:1:1:1:1:1",
+			ExpectedInstance: expect,
+		},
+		{
+			Name:             "ExpandedIPv6Found",
+			LookupIP:         "2001:0db8:0000:0001:0001:0001:0001:0001",
+			ExpectedInstance: expect,
+		},
+		{
+			Name:             "AdditionalIPFound",
+			LookupIP:         "192.168.1.10",
+			ExpectedInstance: expect,
+		},
+		{
+			Name:             "IPv4MappedIPv6Found",
+			LookupIP:         "::ffff:192.168.1.10",
+			ExpectedInstance: expect,
 		},
 		{
 			Name:          "IPNotFound",
@@ -79,3 +106,26 @@ func TestGetEC2Instance(t *testing.T) {
 		})
 	}
 }
+
+func TestNewBackend_DuplicateIP(t *testing.T) {
+	var first, second Instance
+	first.Metadata.ID = "first"
+	first.Metadata.IPv4.Local = "10.10.10.10"
+	second.Metadata.ID = "second"
+	second.IPs = []string{"10.10.10.10"}
+
+	_, err := NewBackend([]Instance{first, second})
+	if !errors.Is(err, ErrDuplicateIP) {
+		t.Fatalf("Expected: %v;\nReceived: %v", ErrDuplicateIP, err)
+	}
+}
+
+func TestNewBackend_RepeatedIPWithinInstance(t *testing.T) {
+	var i Instance
+	i.Metadata.IPv4.Public = "10.10.10.10"
+	i.IPs = []string{"10.10.10.10"}
+
+	if _, err := NewBackend([]Instance{i}); err != nil {
+		t.Fatalf("Expected nil error; Received: %v", err)
+	}
+}
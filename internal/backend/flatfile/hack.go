@@ -2,12 +2,48 @@ package flatfile
 
 import (
 	"context"
-	"errors"
 
 	"github.com/tinkerbell/hegel/internal/frontend/hack"
 )
 
-// GetHackInstance exists to satisfy the hack.Client interface. It is not implemented.
-func (b *Backend) GetHackInstance(context.Context, string) (hack.Instance, error) {
-	return hack.Instance{}, errors.New("unsupported")
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(_ context.Context, ip string) (hack.Instance, error) {
+	i, ok := b.lookup(ip)
+	if !ok {
+		return hack.Instance{}, hack.ErrInstanceNotFound
+	}
+
+	return toHackInstance(i), nil
+}
+
+func toHackInstance(i Instance) hack.Instance {
+	var storage hack.Storage
+
+	for _, d := range i.Metadata.Storage.Disks {
+		disk := hack.Disk{
+			Device:    d.Device,
+			WipeTable: d.WipeTable,
+		}
+		for _, p := range d.Partitions {
+			disk.Partitions = append(disk.Partitions, hack.Partition{
+				Label:  p.Label,
+				Number: p.Number,
+				Size:   p.Size,
+			})
+		}
+		storage.Disks = append(storage.Disks, disk)
+	}
+
+	for _, f := range i.Metadata.Storage.Filesystems {
+		var fs hack.Filesystem
+		fs.Mount.Device = f.Mount.Device
+		fs.Mount.Format = f.Mount.Format
+		fs.Mount.Point = f.Mount.Point
+		fs.Mount.Create.Options = f.Mount.CreateOptions
+		storage.Filesystems = append(storage.Filesystems, fs)
+	}
+
+	var hi hack.Instance
+	hi.Metadata.Instance.Storage = storage
+	return hi
 }
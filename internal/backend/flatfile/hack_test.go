@@ -0,0 +1,77 @@
+package flatfile_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	. "github.com/tinkerbell/hegel/internal/backend/flatfile"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func TestGetHackInstance(t *testing.T) {
+	backend, err := FromYAMLFile("testdata/TestGetHackInstance.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expect hack.Instance
+	expect.Metadata.Instance.Storage = hack.Storage{
+		Disks: []hack.Disk{
+			{
+				Device:    "/dev/sda",
+				WipeTable: true,
+				Partitions: []hack.Partition{
+					{Label: "root", Number: 1},
+				},
+			},
+		},
+		Filesystems: []hack.Filesystem{{}},
+	}
+	expect.Metadata.Instance.Storage.Filesystems[0].Mount = hack.Mount{
+		Device: "/dev/sda1",
+		Format: "ext4",
+		Point:  "/",
+	}
+	expect.Metadata.Instance.Storage.Filesystems[0].Mount.Create.Options = []string{"-L", "ROOT"}
+
+	cases := []struct {
+		Name             string
+		LookupIP         string
+		ExpectedInstance *hack.Instance
+		ExpectedError    error
+	}{
+		{
+			Name:             "IPFound",
+			LookupIP:         "10.10.10.10",
+			ExpectedInstance: &expect,
+		},
+		{
+			Name:          "IPNotFound",
+			LookupIP:      "9.9.9.9",
+			ExpectedError: hack.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			instance, err := backend.GetHackInstance(context.Background(), tc.LookupIP)
+
+			switch {
+			case tc.ExpectedError != nil:
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v;\nReceived: %v", tc.ExpectedError, err)
+				}
+			case tc.ExpectedInstance != nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !cmp.Equal(&instance, tc.ExpectedInstance) {
+					t.Error(cmp.Diff(instance, tc.ExpectedInstance))
+				}
+			}
+		})
+	}
+}
@@ -21,6 +21,16 @@ func TestFromYAMLFile(t *testing.T) {
 			Path:        "testdata/TestFromYAMLFile_Invalid.yml",
 			ExpectError: true,
 		},
+		{
+			Name:        "DuplicateIP",
+			Path:        "testdata/TestFromYAMLFile_DuplicateIP.yml",
+			ExpectError: true,
+		},
+		{
+			Name:        "InvalidIP",
+			Path:        "testdata/TestFromYAMLFile_InvalidIP.yml",
+			ExpectError: true,
+		},
 		{
 			Name:        "MissingYAMLFile",
 			Path:        "testdata/TestFromYAMLFile_Missing.yml",
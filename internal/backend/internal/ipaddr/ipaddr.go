@@ -0,0 +1,18 @@
+// Package ipaddr provides IP address helpers shared by backend implementations.
+package ipaddr
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Normalize returns the canonical string form of ip so equivalent representations resolve to
+// the same value. IPv6 addresses are compressed, zones are dropped and IPv4-mapped IPv6
+// addresses are converted to their IPv4 form.
+func Normalize(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid ip address: %q", ip)
+	}
+	return addr.Unmap().WithZone("").String(), nil
+}
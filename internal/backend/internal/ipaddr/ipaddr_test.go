@@ -0,0 +1,45 @@
+package ipaddr_test
+
+import (
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		Name        string
+		IP          string
+		Expect      string
+		ExpectError bool
+	}{
+		{Name: "IPv4", IP: "10.10.10.10", Expect: "10.10.10.10"},
+		{Name: "IPv4MappedIPv6", IP: "::ffff:10.10.10.10", Expect: "10.10.10.10"},
+		{Name: "CompressedIPv6", IP: "2001:db8::1", Expect: "2001:db8::1"},
+		{Name: "ExpandedIPv6", IP: "2001:0db8:0000:0000:0000:0000:0000:0001", Expect: "2001:db8::1"},
+		{Name: "UppercaseIPv6", IP: "2001:DB8::1", Expect: "2001:db8::1"},
+		{Name: "ZonedIPv6", IP: "fe80::1%eth0", Expect: "fe80::1"},
+		{Name: "Invalid", IP: "foo", ExpectError: true},
+		{Name: "Empty", IP: "", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ip, err := Normalize(tc.IP)
+			if tc.ExpectError {
+				if err == nil {
+					t.Fatal("Expected error but received nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected nil error; Received: %v", err)
+			}
+
+			if ip != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, ip)
+			}
+		})
+	}
+}
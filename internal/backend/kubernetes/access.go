@@ -0,0 +1,205 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations recording userdata retrievals onto Hardware.
+const (
+	// AnnotationFirstUserdataFetch is when the Hardware's userdata was first retrieved.
+	AnnotationFirstUserdataFetch = "hegel.tinkerbell.org/first-userdata-fetch"
+
+	// AnnotationLastUserdataFetch is when the Hardware's userdata was last retrieved.
+	AnnotationLastUserdataFetch = "hegel.tinkerbell.org/last-userdata-fetch"
+
+	// AnnotationLastUserdataFetchIP is the address that last retrieved the Hardware's userdata.
+	AnnotationLastUserdataFetchIP = "hegel.tinkerbell.org/last-userdata-fetch-ip"
+
+	// AnnotationLastUserdataFetchUserAgent is the user agent that last retrieved the Hardware's
+	// userdata.
+	AnnotationLastUserdataFetchUserAgent = "hegel.tinkerbell.org/last-userdata-fetch-user-agent"
+)
+
+// accessAnnotations are the annotations written by an accessRecorder. They're retained in the
+// cache so the recorder knows whether the first retrieval has been recorded.
+var accessAnnotations = []string{
+	AnnotationFirstUserdataFetch,
+	AnnotationLastUserdataFetch,
+	AnnotationLastUserdataFetchIP,
+	AnnotationLastUserdataFetchUserAgent,
+}
+
+const (
+	// defaultAccessRecordInterval is the default minimum interval between recording access onto
+	// the same Hardware.
+	defaultAccessRecordInterval = time.Minute
+
+	// accessQueueSize is the number of accesses that may be waiting to be recorded. Accesses
+	// received when the queue is full are dropped so requests are never blocked.
+	accessQueueSize = 1024
+
+	// accessRecordRetention is how long the recorder remembers patching Hardware. It is well
+	// beyond the time taken for a patch to be observed by the cache so the first retrieval is
+	// never recorded twice.
+	accessRecordRetention = 10 * time.Minute
+
+	// accessPatchTimeout bounds each patch so an unresponsive API server can't stall the queue
+	// indefinitely.
+	accessPatchTimeout = 10 * time.Second
+)
+
+// hardwarePatcher patches Hardware.
+type hardwarePatcher interface {
+	Patch(ctx context.Context, obj crclient.Object, patch crclient.Patch, opts ...crclient.PatchOption) error
+}
+
+// accessRecorder records userdata retrievals onto Hardware annotations. Accesses are queued and
+// recorded by a single goroutine so recording never slows lookups. Each Hardware is patched at
+// most once per interval.
+type accessRecorder struct {
+	lookup   func(ctx context.Context, ip string) (tinkv1.Hardware, error)
+	patcher  hardwarePatcher
+	interval time.Duration
+	logger   logr.Logger
+	records  *prometheus.CounterVec
+
+	queue chan ec2.Access
+
+	// recorded is when each Hardware was last patched. It is only accessed by the run goroutine.
+	recorded  map[types.NamespacedName]time.Time
+	lastPrune time.Time
+}
+
+func newAccessRecorder(
+	lookup func(context.Context, string) (tinkv1.Hardware, error),
+	patcher hardwarePatcher,
+	interval time.Duration,
+	logger logr.Logger,
+) *accessRecorder {
+	if interval <= 0 {
+		interval = defaultAccessRecordInterval
+	}
+
+	return &accessRecorder{
+		lookup:   lookup,
+		patcher:  patcher,
+		interval: interval,
+		logger:   logger,
+		records: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kubernetes_access_records_total",
+				Help: "Count of userdata retrievals processed for recording onto Hardware by result",
+			},
+			[]string{"result"},
+		),
+		queue:    make(chan ec2.Access, accessQueueSize),
+		recorded: map[types.NamespacedName]time.Time{},
+	}
+}
+
+// enqueue queues access to be recorded. It never blocks.
+func (r *accessRecorder) enqueue(access ec2.Access) {
+	select {
+	case r.queue <- access:
+	default:
+		r.records.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run records queued accesses until ctx is cancelled.
+func (r *accessRecorder) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case access := <-r.queue:
+			r.record(ctx, access)
+		}
+	}
+}
+
+func (r *accessRecorder) record(ctx context.Context, access ec2.Access) {
+	r.prune(access.Time)
+
+	hw, err := r.lookup(ctx, access.IP)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			r.records.WithLabelValues("not_found").Inc()
+			return
+		}
+		r.logger.Error(err, "Retrieving Hardware to record userdata access", "ip", access.IP)
+		r.records.WithLabelValues("error").Inc()
+		return
+	}
+
+	key := types.NamespacedName{Namespace: hw.Namespace, Name: hw.Name}
+
+	last, recorded := r.recorded[key]
+	if recorded && access.Time.Sub(last) < r.interval {
+		r.records.WithLabelValues("rate_limited").Inc()
+		return
+	}
+
+	timestamp := access.Time.UTC().Format(time.RFC3339)
+	annotations := map[string]string{
+		AnnotationLastUserdataFetch:          timestamp,
+		AnnotationLastUserdataFetchIP:        access.IP,
+		AnnotationLastUserdataFetchUserAgent: access.UserAgent,
+	}
+
+	// The cache may not yet reflect a first retrieval we recorded so we also consult the
+	// Hardware we've patched.
+	if _, ok := hw.Annotations[AnnotationFirstUserdataFetch]; !ok && !recorded {
+		annotations[AnnotationFirstUserdataFetch] = timestamp
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		r.logger.Error(err, "Encoding userdata access patch")
+		r.records.WithLabelValues("error").Inc()
+		return
+	}
+
+	obj := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+
+	patchCtx, cancel := context.WithTimeout(ctx, accessPatchTimeout)
+	defer cancel()
+
+	if err := r.patcher.Patch(patchCtx, obj, crclient.RawPatch(types.MergePatchType, patch)); err != nil {
+		r.logger.Error(err, "Recording userdata access", "namespace", key.Namespace, "name", key.Name)
+		r.records.WithLabelValues("error").Inc()
+		return
+	}
+
+	r.recorded[key] = access.Time
+	r.records.WithLabelValues("recorded").Inc()
+}
+
+// prune forgets Hardware that haven't been patched recently so the recorder's memory usage is
+// proportional to the Hardware recently recorded.
+func (r *accessRecorder) prune(now time.Time) {
+	retention := max(r.interval, accessRecordRetention)
+	if now.Sub(r.lastPrune) < retention {
+		return
+	}
+	r.lastPrune = now
+
+	for key, last := range r.recorded {
+		if now.Sub(last) >= retention {
+			delete(r.recorded, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakePatcher records the annotations of each patch.
+type fakePatcher struct {
+	err     error
+	patches []map[string]string
+}
+
+func (p *fakePatcher) Patch(_ context.Context, obj crclient.Object, patch crclient.Patch, _ ...crclient.PatchOption) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if patch.Type() != types.MergePatchType {
+		return errors.New("expected a merge patch")
+	}
+
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+
+	var decoded struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	p.patches = append(p.patches, decoded.Metadata.Annotations)
+	return nil
+}
+
+func newAccessTestRecorder(hw *tinkv1.Hardware, patcher hardwarePatcher) *accessRecorder {
+	lookup := func(_ context.Context, ip string) (tinkv1.Hardware, error) {
+		if hw == nil || ip != "10.10.10.10" {
+			return tinkv1.Hardware{}, errNotFound
+		}
+		return *hw, nil
+	}
+	return newAccessRecorder(lookup, patcher, time.Minute, logr.Discard())
+}
+
+func TestAccessRecorder(t *testing.T) {
+	hw := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"}}
+	patcher := &fakePatcher{}
+	recorder := newAccessTestRecorder(hw, patcher)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	access := ec2.Access{IP: "10.10.10.10", UserAgent: "cloud-init/23.4", Time: start}
+
+	recorder.record(context.Background(), access)
+
+	// Repeat requests within the interval aren't recorded.
+	access.Time = start.Add(30 * time.Second)
+	recorder.record(context.Background(), access)
+
+	// Once the interval has elapsed, only the last retrieval is recorded.
+	access.Time = start.Add(2 * time.Minute)
+	access.UserAgent = "curl/8.0"
+	recorder.record(context.Background(), access)
+
+	expect := []map[string]string{
+		{
+			AnnotationFirstUserdataFetch:         "2024-01-01T00:00:00Z",
+			AnnotationLastUserdataFetch:          "2024-01-01T00:00:00Z",
+			AnnotationLastUserdataFetchIP:        "10.10.10.10",
+			AnnotationLastUserdataFetchUserAgent: "cloud-init/23.4",
+		},
+		{
+			AnnotationLastUserdataFetch:          "2024-01-01T00:02:00Z",
+			AnnotationLastUserdataFetchIP:        "10.10.10.10",
+			AnnotationLastUserdataFetchUserAgent: "curl/8.0",
+		},
+	}
+	if diff := cmp.Diff(expect, patcher.patches); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if count := testutil.ToFloat64(recorder.records.WithLabelValues("rate_limited")); count != 1 {
+		t.Fatalf("Expected 1 rate limited record; Received %v", count)
+	}
+}
+
+func TestAccessRecorderFirstFetchRecorded(t *testing.T) {
+	// Hardware that already records its first retrieval, for example after Hegel restarts,
+	// doesn't have it overwritten.
+	hw := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{
+		Name:        "machine",
+		Namespace:   "default",
+		Annotations: map[string]string{AnnotationFirstUserdataFetch: "2023-01-01T00:00:00Z"},
+	}}
+	patcher := &fakePatcher{}
+	recorder := newAccessTestRecorder(hw, patcher)
+
+	recorder.record(context.Background(), ec2.Access{IP: "10.10.10.10", Time: time.Now()})
+
+	if len(patcher.patches) != 1 {
+		t.Fatalf("Expected 1 patch; Received %v", len(patcher.patches))
+	}
+	if _, ok := patcher.patches[0][AnnotationFirstUserdataFetch]; ok {
+		t.Fatal("Expected the first retrieval to be left unchanged")
+	}
+}
+
+func TestAccessRecorderErrors(t *testing.T) {
+	hw := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"}}
+
+	cases := []struct {
+		Name         string
+		Hardware     *tinkv1.Hardware
+		PatchErr     error
+		ExpectResult string
+	}{
+		{Name: "NotFound", ExpectResult: "not_found"},
+		{Name: "PatchError", Hardware: hw, PatchErr: errors.New("forbidden"), ExpectResult: "error"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			recorder := newAccessTestRecorder(tc.Hardware, &fakePatcher{err: tc.PatchErr})
+
+			access := ec2.Access{IP: "10.10.10.10", Time: time.Now()}
+			recorder.record(context.Background(), access)
+
+			if count := testutil.ToFloat64(recorder.records.WithLabelValues(tc.ExpectResult)); count != 1 {
+				t.Fatalf("Expected 1 %v record; Received %v", tc.ExpectResult, count)
+			}
+
+			// A failed patch doesn't rate limit subsequent attempts.
+			if tc.PatchErr != nil {
+				recorder.record(context.Background(), access)
+				if count := testutil.ToFloat64(recorder.records.WithLabelValues(tc.ExpectResult)); count != 2 {
+					t.Fatalf("Expected 2 %v records; Received %v", tc.ExpectResult, count)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessRecorderDropsWhenFull(t *testing.T) {
+	recorder := newAccessTestRecorder(nil, &fakePatcher{})
+
+	for i := 0; i < accessQueueSize+1; i++ {
+		recorder.enqueue(ec2.Access{IP: "10.10.10.10"})
+	}
+
+	if count := testutil.ToFloat64(recorder.records.WithLabelValues("dropped")); count != 1 {
+		t.Fatalf("Expected 1 dropped record; Received %v", count)
+	}
+}
@@ -0,0 +1,139 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// defaultAggregateInterval is the default interval an aggregatingRecorder suppresses repeated
+// events for. It matches the interval client-go aggregates similar events over.
+const defaultAggregateInterval = 10 * time.Minute
+
+// aggregatingRecorder suppresses repeats of an event on the same object within an interval. Events
+// emitted while serving lookups may repeat on every request, for example when a machine retries
+// a failing lookup, so without aggregation they'd flood the API server and exhaust the
+// per-object budget of the client-go spam filter, causing other events to be dropped. The first
+// repeat after the interval is emitted with the number of repeats suppressed.
+type aggregatingRecorder struct {
+	recorder record.EventRecorder
+	interval time.Duration
+	now      func() time.Time
+
+	mtx       sync.Mutex
+	seen      map[aggregateKey]*aggregateEntry
+	lastPrune time.Time
+}
+
+type aggregateKey struct {
+	uid                        types.UID
+	namespace, name            string
+	eventType, reason, message string
+}
+
+type aggregateEntry struct {
+	emitted    time.Time
+	suppressed int
+}
+
+func newAggregatingRecorder(recorder record.EventRecorder, interval time.Duration) *aggregatingRecorder {
+	if interval <= 0 {
+		interval = defaultAggregateInterval
+	}
+
+	return &aggregatingRecorder{
+		recorder: recorder,
+		interval: interval,
+		now:      time.Now,
+		seen:     map[aggregateKey]*aggregateEntry{},
+	}
+}
+
+// Event satisfies record.EventRecorder.
+func (r *aggregatingRecorder) Event(obj runtime.Object, eventType, reason, message string) {
+	if message, ok := r.aggregate(obj, eventType, reason, message); ok {
+		r.recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// Eventf satisfies record.EventRecorder.
+func (r *aggregatingRecorder) Eventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Event(obj, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf satisfies record.EventRecorder.
+func (r *aggregatingRecorder) AnnotatedEventf(
+	obj runtime.Object,
+	annotations map[string]string,
+	eventType, reason, messageFmt string,
+	args ...interface{},
+) {
+	if message, ok := r.aggregate(obj, eventType, reason, fmt.Sprintf(messageFmt, args...)); ok {
+		r.recorder.AnnotatedEventf(obj, annotations, eventType, reason, "%s", message)
+	}
+}
+
+// aggregate determines whether an event should be emitted and returns the message to emit.
+func (r *aggregatingRecorder) aggregate(obj runtime.Object, eventType, reason, message string) (string, bool) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		// We can't identify the object so we can't aggregate. Let the recorder handle it.
+		return message, true
+	}
+
+	key := aggregateKey{
+		uid:       accessor.GetUID(),
+		namespace: accessor.GetNamespace(),
+		name:      accessor.GetName(),
+		eventType: eventType,
+		reason:    reason,
+		message:   message,
+	}
+
+	now := r.now()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.pruneLocked(now)
+
+	entry, ok := r.seen[key]
+	if !ok {
+		r.seen[key] = &aggregateEntry{emitted: now}
+		return message, true
+	}
+
+	if now.Sub(entry.emitted) < r.interval {
+		entry.suppressed++
+		return "", false
+	}
+
+	if entry.suppressed > 0 {
+		message = fmt.Sprintf("%v (repeated %v times)", message, entry.suppressed)
+	}
+	entry.emitted = now
+	entry.suppressed = 0
+
+	return message, true
+}
+
+// pruneLocked forgets events that haven't been emitted recently so memory usage is proportional
+// to the events recently emitted. Events are retained for 2 intervals so repeats suppressed
+// during an interval are reported by the next repeat. r.mtx must be held.
+func (r *aggregatingRecorder) pruneLocked(now time.Time) {
+	if now.Sub(r.lastPrune) < r.interval {
+		return
+	}
+	r.lastPrune = now
+
+	for key, entry := range r.seen {
+		if now.Sub(entry.emitted) >= 2*r.interval {
+			delete(r.seen, key)
+		}
+	}
+}
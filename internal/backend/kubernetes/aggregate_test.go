@@ -0,0 +1,60 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestAggregatingRecorder(t *testing.T) {
+	now := time.Now()
+	fake := record.NewFakeRecorder(10)
+	recorder := newAggregatingRecorder(fake, time.Minute)
+	recorder.now = func() time.Time { return now }
+
+	machine := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default", UID: "1"}}
+	other := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "2"}}
+
+	expectEvents := func(t *testing.T, expect ...string) {
+		t.Helper()
+		if len(fake.Events) != len(expect) {
+			t.Fatalf("Expected %v events; Received: %v", len(expect), len(fake.Events))
+		}
+		for _, e := range expect {
+			if event := <-fake.Events; event != e {
+				t.Fatalf("Expected %q; Received %q", e, event)
+			}
+		}
+	}
+
+	recorder.Eventf(machine, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.10")
+	expectEvents(t, "Warning Reason lookup from 10.10.10.10")
+
+	// Repeats within the interval are suppressed but distinct events and objects aren't.
+	now = now.Add(30 * time.Second)
+	recorder.Eventf(machine, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.10")
+	recorder.Eventf(machine, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.10")
+	recorder.Eventf(machine, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.11")
+	recorder.Eventf(other, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.10")
+	expectEvents(t,
+		"Warning Reason lookup from 10.10.10.11",
+		"Warning Reason lookup from 10.10.10.10",
+	)
+
+	// The first repeat after the interval reports the suppressed repeats.
+	now = now.Add(time.Minute)
+	recorder.Eventf(machine, corev1.EventTypeWarning, "Reason", "lookup from %v", "10.10.10.10")
+	expectEvents(t, "Warning Reason lookup from 10.10.10.10 (repeated 2 times)")
+
+	// Events are forgotten once they haven't been emitted for 2 intervals.
+	now = now.Add(2 * time.Minute)
+	recorder.Eventf(other, corev1.EventTypeNormal, "Other", "unrelated")
+	expectEvents(t, "Normal Other unrelated")
+	if len(recorder.seen) != 1 {
+		t.Fatalf("Expected 1 retained event; Received: %v", len(recorder.seen))
+	}
+}
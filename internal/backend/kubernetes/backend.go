@@ -4,9 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
-
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
 	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	kubescheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
@@ -27,17 +36,55 @@ func init() {
 
 // Backend is a hardware Backend backed by a Backend cluster that contains hardware resources.
 type Backend struct {
-	client listerClient
-	closer <-chan struct{}
-
-	// WaitForCacheSync waits for the initial sync to be completed. Returns false if the cache
-	// fails to sync.
-	WaitForCacheSync func(context.Context) bool
+	client         listerClient
+	closer         <-chan struct{}
+	conflictPolicy ConflictPolicy
+
+	cluster    cluster.Cluster
+	informer   cache.Informer
+	health     *healthTracker
+	prober     apiProber
+	maxListAge time.Duration
+
+	// staleTolerance is how long the backend remains healthy while serving cached data that
+	// can't be synced with the API server. 0 disables stale tolerance.
+	staleTolerance time.Duration
+
+	// snapshot serves Hardware loaded from disk until the cache has synced. It is nil when there
+	// is no snapshot or once the cache has synced.
+	snapshot atomic.Pointer[snapshotStore]
+
+	// snapshotWriter writes the cache to disk. It is nil if snapshots are disabled.
+	snapshotWriter *snapshotWriter
+
+	// precomputed serves frontend models mapped when Hardware changes. It is nil if
+	// precomputing is disabled.
+	precomputed *precomputedStore
+
+	// userdata retrieves userdata that isn't held in the cache. It is nil unless lazy userdata
+	// is enabled.
+	userdata *userdataFetcher
+
+	// access records userdata retrievals onto Hardware. It is nil unless access recording is
+	// enabled.
+	access *accessRecorder
+
+	// rescue resolves the payload served to Hardware in rescue mode. It is nil for Backends
+	// constructed for testing.
+	rescue *rescuePayload
+
+	// lookupEvents records events on Hardware for notable lookups. It is nil for Backends
+	// constructed for testing.
+	lookupEvents *lookupEvents
+
+	// events forwards boot progress reported by instances to Hardware. It is nil unless event
+	// forwarding is enabled.
+	events *eventForwarder
 }
 
-// NewBackend creates a new Backend instance. It launches a goroutine to perform synchronization
-// between the cluster and internal caches. Consumers can wait for the initial sync using WaitForCachesync().
-// See k8s.io/Backend-go/tools/Backendcmd for constructing *rest.Config objects.
+// NewBackend creates a new Backend instance. The Backend does not synchronize with the cluster
+// until Start is called. Consumers can wait for the initial sync using WaitForCacheSync().
+// See k8s.io/client-go/tools/clientcmd for constructing *rest.Config objects.
 func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
 	// If no client was specified, build one and configure the backend with it including waiting
 	// for the caches to sync.
@@ -49,11 +96,50 @@ func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
 		}
 	}
 
+	if len(cfg.IPSources) == 0 {
+		cfg.IPSources = AllIPSources()
+	}
+
+	if err := validateIPSources(cfg.IPSources); err != nil {
+		return nil, err
+	}
+
+	if cfg.ConflictPolicy == "" {
+		cfg.ConflictPolicy = ConflictPolicyError
+	}
+
+	if err := cfg.ConflictPolicy.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.HealthProbeInterval == 0 {
+		cfg.HealthProbeInterval = defaultHealthProbeInterval
+	}
+
+	if cfg.HealthMaxListAge == 0 {
+		cfg.HealthMaxListAge = defaultHealthMaxListAge
+	}
+
+	if cfg.SnapshotInterval == 0 {
+		cfg.SnapshotInterval = defaultSnapshotInterval
+	}
+
+	if cfg.Logger.GetSink() == nil {
+		cfg.Logger = logr.Discard()
+	}
+
+	cacheOpts, err := cfg.cacheOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	health := newHealthTracker()
+	cacheOpts.DefaultWatchErrorHandler = health.watchErrorHandler
+
 	conf := func(opts *cluster.Options) {
 		opts.Scheme = scheme
-		if cfg.Namespace != "" {
-			opts.Cache.DefaultNamespaces = map[string]cache.Config{cfg.Namespace: {}}
-		}
+		opts.Cache = cacheOpts
+		opts.MapperProvider = newHardwareRESTMapper
 	}
 
 	clstr, err := cluster.New(cfg.ClientConfig, conf)
@@ -61,34 +147,195 @@ func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
 		return nil, fmt.Errorf("create cluster: %v", err)
 	}
 
+	indexFunc := hardwareIPIndexFunc(cfg.IPSources)
+
 	err = clstr.GetFieldIndexer().IndexField(
 		ctx,
 		&tinkv1.Hardware{},
 		hardwareIPAddrIndex,
-		hardwareIPIndexFunc,
+		indexFunc,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("register index: %v", err)
 	}
 
-	// TODO(chrisdoherty4) Stop panicing on error. This will likely require exposing Start in
-	// some capacity and allowing the caller to handle the error.
+	// Watch for Hardware that share IPs so operators are notified of misconfiguration via events
+	// before a machine attempts to retrieve its metadata.
+	informer, err := clstr.GetCache().GetInformer(ctx, &tinkv1.Hardware{})
+	if err != nil {
+		return nil, fmt.Errorf("get hardware informer: %v", err)
+	}
+
+	// Events recorded by the conflict detector and lookups may repeat frequently so they're
+	// aggregated.
+	recorder := newAggregatingRecorder(clstr.GetEventRecorderFor("hegel"), defaultAggregateInterval)
+
+	conflicts := newConflictDetector(recorder, indexFunc)
+	if _, err := informer.AddEventHandler(conflicts); err != nil {
+		return nil, fmt.Errorf("add hardware event handler: %v", err)
+	}
+
+	lookupEvents := newLookupEvents(recorder)
+	if _, err := informer.AddEventHandler(lookupEvents); err != nil {
+		return nil, fmt.Errorf("add hardware event handler: %v", err)
+	}
+
+	b := &Backend{
+		closer:         ctx.Done(),
+		client:         clstr.GetClient(),
+		conflictPolicy: cfg.ConflictPolicy,
+		cluster:        clstr,
+		informer:       informer,
+		health:         health,
+		prober: apiProber{
+			reader:    clstr.GetAPIReader(),
+			tracker:   health,
+			interval:  cfg.HealthProbeInterval,
+			namespace: cfg.probeNamespace(),
+		},
+		maxListAge:     cfg.HealthMaxListAge,
+		staleTolerance: cfg.StaleTolerance,
+		rescue: &rescuePayload{
+			reader: clstr.GetAPIReader(),
+			fallback: ec2.Rescue{
+				Userdata:   cfg.RescueUserdata,
+				Vendordata: cfg.RescueVendordata,
+			},
+		},
+		lookupEvents: lookupEvents,
+	}
+
+	if cfg.SnapshotPath != "" {
+		// A snapshot lets us serve data before the cache has synced, including when the API
+		// server is unreachable. A bad snapshot shouldn't prevent startup so we only log errors.
+		store, err := loadSnapshot(cfg.SnapshotPath, indexFunc)
+		switch {
+		case err != nil:
+			cfg.Logger.Error(err, "Loading snapshot; continuing without it", "path", cfg.SnapshotPath)
+		case store != nil:
+			cfg.Logger.Info("Loaded snapshot", "path", cfg.SnapshotPath, "created", store.createdAt)
+			b.snapshot.Store(store)
+
+			// Treat the snapshot as the last time we were in sync so its age is reflected in
+			// the backends data age and health.
+			health.recordListAt(store.createdAt)
+		}
+
+		b.snapshotWriter = &snapshotWriter{
+			path:     cfg.SnapshotPath,
+			interval: cfg.SnapshotInterval,
+			client:   clstr.GetCache(),
+			logger:   cfg.Logger,
+		}
+
+		if _, err := informer.AddEventHandler(b.snapshotWriter); err != nil {
+			return nil, fmt.Errorf("add hardware event handler: %v", err)
+		}
+	}
+
+	if cfg.LazyUserdata {
+		b.userdata = newUserdataFetcher(clstr.GetAPIReader(), cfg.UserdataCacheSize)
+	}
+
+	if cfg.RecordAccess {
+		b.access = newAccessRecorder(b.retrieveByIP, clstr.GetClient(), cfg.AccessRecordInterval, cfg.Logger)
+	}
+
+	if cfg.ForwardEvents {
+		b.events = &eventForwarder{
+			lookup:   b.retrieveByIP,
+			recorder: clstr.GetEventRecorderFor("hegel"),
+			logger:   cfg.Logger,
+		}
+	}
+
+	if cfg.Precompute {
+		b.precomputed = newPrecomputedStore(indexFunc)
+
+		registration, err := informer.AddEventHandler(b.precomputed)
+		if err != nil {
+			return nil, fmt.Errorf("add hardware event handler: %v", err)
+		}
+		b.precomputed.synced = registration.HasSynced
+	}
+
+	if cfg.Registerer != nil {
+		collectors := []prometheus.Collector{
+			conflicts.conflicts,
+			newDataAgeGauge(b),
+		}
+		if b.access != nil {
+			collectors = append(collectors, b.access.records)
+		}
+		for _, c := range collectors {
+			if err := cfg.Registerer.Register(c); err != nil {
+				return nil, fmt.Errorf("register metrics: %v", err)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// Start synchronizes the Backend with the cluster. It blocks until ctx is cancelled or the
+// synchronization fails.
+func (b *Backend) Start(ctx context.Context) error {
+	go b.prober.run(ctx)
+
+	if b.access != nil {
+		go b.access.run(ctx)
+	}
+
+	// Once the cache has synced, stop serving from the snapshot and begin writing the cache to
+	// disk.
 	go func() {
-		if err := clstr.Start(ctx); err != nil {
-			panic(err)
+		if !b.WaitForCacheSync(ctx) {
+			return
+		}
+
+		b.snapshot.Store(nil)
+
+		if b.snapshotWriter != nil {
+			b.snapshotWriter.run(ctx)
 		}
 	}()
 
-	return &Backend{
-		closer:           ctx.Done(),
-		client:           clstr.GetClient(),
-		WaitForCacheSync: clstr.GetCache().WaitForCacheSync,
-	}, nil
+	return b.cluster.Start(ctx)
+}
+
+// WaitForCacheSync waits for the initial sync to be completed. Returns false if the cache
+// fails to sync.
+func (b *Backend) WaitForCacheSync(ctx context.Context) bool {
+	return b.cluster.GetCache().WaitForCacheSync(ctx)
+}
+
+// WaitForReady waits until the Backend can serve data. The Backend can serve data once a snapshot
+// has been loaded or the initial cache sync has completed. Returns false if ctx is cancelled
+// before the Backend is ready.
+func (b *Backend) WaitForReady(ctx context.Context) bool {
+	if b.snapshot.Load() != nil {
+		return true
+	}
+	return b.WaitForCacheSync(ctx)
+}
+
+// synced returns true once the Backend can serve data: a snapshot has been loaded or the cache
+// has synced.
+func (b *Backend) synced() bool {
+	if b.snapshot.Load() != nil {
+		return true
+	}
+	// Backends constructed for testing have no informer.
+	return b.informer == nil || b.informer.HasSynced()
 }
 
 func loadConfig(cfg Config) (Config, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadingRules.ExplicitPath = cfg.Kubeconfig
+	if paths := filepath.SplitList(cfg.Kubeconfig); len(paths) > 1 {
+		loadingRules.Precedence = paths
+	} else {
+		loadingRules.ExplicitPath = cfg.Kubeconfig
+	}
 
 	overrides := &clientcmd.ConfigOverrides{
 		ClusterInfo: clientcmdapi.Cluster{
@@ -97,6 +344,7 @@ func loadConfig(cfg Config) (Config, error) {
 		Context: clientcmdapi.Context{
 			Namespace: cfg.Namespace,
 		},
+		CurrentContext: cfg.Context,
 	}
 
 	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
@@ -106,22 +354,125 @@ func loadConfig(cfg Config) (Config, error) {
 	}
 	cfg.ClientConfig = config
 
+	// Scope the backend to the namespace of an explicitly selected context so each cluster of a
+	// multi-cluster backend can be scoped independently.
+	if cfg.Context != "" && cfg.Namespace == "" && len(cfg.Namespaces) == 0 {
+		raw, err := loader.RawConfig()
+		if err != nil {
+			return Config{}, err
+		}
+		if kubeCtx, ok := raw.Contexts[cfg.Context]; ok {
+			cfg.Namespace = kubeCtx.Namespace
+		}
+	}
+
 	return cfg, nil
 }
 
-// IsHealthy returns true until the context used to create the Backend is cancelled.
+// IsHealthy returns true when the Backend has synced its cache and has successfully listed
+// Hardware from the API server within the configured maximum list age, without observing a list
+// or watch error since, or while it is serving a snapshot no older than the stale tolerance. It
+// returns false once the context used to create the Backend is cancelled.
 func (b *Backend) IsHealthy(context.Context) bool {
 	select {
 	case <-b.closer:
 		return false
 	default:
-		return true
 	}
+
+	// The snapshot is served deliberately while the cache is unsynced, typically because the API
+	// server is unreachable, so the Backend is healthy while the snapshot is as fresh as cached
+	// data would need to be. The kubernetes-api health check continues to report the API
+	// server's health.
+	if store := b.snapshot.Load(); store != nil {
+		return b.staleTolerance > 0 && time.Since(store.createdAt) <= b.staleTolerance
+	}
+
+	// Backends constructed for testing have no informer or tracker.
+	if b.informer != nil && !b.informer.HasSynced() {
+		return false
+	}
+
+	if b.health != nil && !b.health.healthy(b.maxListAge, b.staleTolerance) {
+		return false
+	}
+
+	return true
+}
+
+// RecordAccess satisfies ec2.AccessRecorder. Access is recorded onto the Hardware asynchronously
+// if access recording is enabled.
+func (b *Backend) RecordAccess(_ context.Context, access ec2.Access) {
+	if b.access != nil {
+		b.access.enqueue(access)
+	}
+}
+
+// ForwardEvent satisfies phonehome.Forwarder. The event is recorded on the Hardware if event
+// forwarding is enabled.
+func (b *Backend) ForwardEvent(ctx context.Context, e phonehome.Event) {
+	if b.events != nil {
+		b.events.forward(ctx, e)
+	}
+}
+
+// GetEC2Rescue satisfies ec2.RescueClient.
+func (b *Backend) GetEC2Rescue(ctx context.Context, ip string) (ec2.Rescue, bool, error) {
+	_, hw, err := b.getEC2Instance(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Rescue{}, false, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Rescue{}, false, err
+	}
+
+	// The Hardware may have left rescue mode since the instance was retrieved.
+	if hw.Spec.Metadata == nil || hw.Spec.Metadata.Instance == nil || !hw.Spec.Metadata.Instance.Rescue {
+		return ec2.Rescue{}, false, nil
+	}
+
+	rescue, ok, err := b.rescue.get(ctx, hw)
+	if err != nil {
+		return ec2.Rescue{}, false, fmt.Errorf("get rescue payload: %w", err)
+	}
+	return rescue, ok, nil
+}
+
+// RecordServe satisfies ec2.ServeRecorder and hack.ServeRecorder. It records a MetadataServed
+// event the first time metadata is served for each generation of the Hardware.
+func (b *Backend) RecordServe(ctx context.Context, ip string) {
+	if b.lookupEvents == nil {
+		return
+	}
+
+	_, hw, err := b.getEC2Instance(ctx, ip)
+	if err != nil {
+		return
+	}
+
+	b.lookupEvents.metadataServed(hw, ip)
+}
+
+// InstanceID satisfies phonehome.InstanceIdentifier. Unlike GetEC2Instance, it doesn't retrieve
+// userdata.
+func (b *Backend) InstanceID(ctx context.Context, ip string) (string, error) {
+	instance, _, err := b.getEC2Instance(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return "", ec2.ErrInstanceNotFound
+		}
+
+		return "", err
+	}
+
+	return instance.Metadata.InstanceID, nil
 }
 
-// GetEC2InstanceByIP satisfies ec2.Client.
+// GetEC2InstanceByIP satisfies ec2.Client. In lazy userdata mode the instance has no userdata;
+// it's retrieved with GetEC2Userdata.
 func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
-	hw, err := b.retrieveByIP(ctx, ip)
+	instance, _, err := b.getEC2Instance(ctx, ip)
 	if err != nil {
 		if errors.Is(err, errNotFound) {
 			return ec2.Instance{}, ec2.ErrInstanceNotFound
@@ -130,13 +481,96 @@ func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance,
 		return ec2.Instance{}, err
 	}
 
-	return toEC2Instance(hw), nil
+	return instance, nil
+}
+
+// GetEC2Userdata satisfies ec2.UserdataClient. In lazy userdata mode the userdata is retrieved
+// from the API server so, unlike other lookups, it fails while the API server is unreachable.
+func (b *Backend) GetEC2Userdata(ctx context.Context, ip string) (string, error) {
+	instance, hw, err := b.getEC2Instance(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return "", ec2.ErrInstanceNotFound
+		}
+
+		return "", err
+	}
+
+	if b.userdata == nil {
+		return instance.Userdata, nil
+	}
+
+	userdata, err := b.userdata.fetch(ctx, hw)
+	if err != nil {
+		// The Hardware may have been deleted since we retrieved it from the cache.
+		if apierrors.IsNotFound(err) {
+			return "", ec2.ErrInstanceNotFound
+		}
+
+		return "", fmt.Errorf("fetch userdata: %w", err)
+	}
+
+	if userdata == nil {
+		return "", nil
+	}
+	return *userdata, nil
+}
+
+func (b *Backend) getEC2Instance(ctx context.Context, ip string) (ec2.Instance, *tinkv1.Hardware, error) {
+	if p, ok, err := b.retrievePrecomputedByIP(ip); ok {
+		if err != nil {
+			return ec2.Instance{}, nil, err
+		}
+
+		return p.ec2, p.hw, nil
+	}
+
+	hw, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		return ec2.Instance{}, nil, err
+	}
+
+	return toEC2Instance(hw), &hw, nil
+}
+
+// retrievePrecomputedByIP retrieves the precomputed models for ip. ok is false if precomputed
+// models can't be used to serve the lookup in which case callers should use retrieveByIP.
+func (b *Backend) retrievePrecomputedByIP(ip string) (p *precomputed, ok bool, err error) {
+	// Precomputed models are built from the cache so they're incomplete until the handler has
+	// observed the initial list. While we're serving from a snapshot we defer to the snapshot.
+	if b.precomputed == nil || b.snapshot.Load() != nil || !b.precomputed.synced() {
+		return nil, false, nil
+	}
+
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return nil, true, errNotFound
+	}
+
+	p, err = b.precomputed.lookup(normalized, b.conflictPolicy)
+	if errors.Is(err, errMultipleHardware) {
+		b.lookupEvents.conflict(normalized, b.precomputed.hardware(normalized))
+	}
+	return p, true, err
 }
 
 func (b *Backend) retrieveByIP(ctx context.Context, ip string) (tinkv1.Hardware, error) {
+	// Indexed addresses are normalized so we must normalize the IP to find a match. An IP that
+	// can't be normalized can't match any Hardware.
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return tinkv1.Hardware{}, errNotFound
+	}
+
+	// Serve from the snapshot, if we have one, until the cache has synced.
+	var client listerClient = b.client
+	if store := b.snapshot.Load(); store != nil {
+		client = store
+	}
+
 	var hw tinkv1.HardwareList
-	err := b.client.List(ctx, &hw, crclient.MatchingFields{
-		hardwareIPAddrIndex: ip,
+	err = client.List(ctx, &hw, crclient.MatchingFields{
+		hardwareIPAddrIndex: normalized,
 	})
 	if err != nil {
 		return tinkv1.Hardware{}, err
@@ -147,7 +581,11 @@ func (b *Backend) retrieveByIP(ctx context.Context, ip string) (tinkv1.Hardware,
 	}
 
 	if len(hw.Items) > 1 {
-		return tinkv1.Hardware{}, fmt.Errorf("multiple hardware found")
+		chosen, err := b.conflictPolicy.resolve(normalized, hw.Items)
+		if errors.Is(err, errMultipleHardware) {
+			b.lookupEvents.conflict(normalized, hw.Items)
+		}
+		return chosen, err
 	}
 
 	return hw.Items[0], nil
@@ -162,11 +600,24 @@ type listerClient interface {
 func toEC2Instance(hw tinkv1.Hardware) ec2.Instance {
 	var i ec2.Instance
 
+	i.Namespace = hw.Namespace
+
+	// The generation changes only when the spec changes. It is 0 for Hardware that didn't come
+	// from the API server.
+	if hw.Generation != 0 {
+		i.Revision = strconv.FormatInt(hw.Generation, 10)
+	}
+	i.Changed = specChanged(hw.ManagedFields)
+	if hw.Spec.Metadata != nil {
+		i.State = hw.Spec.Metadata.State
+	}
+
 	if hw.Spec.Metadata != nil && hw.Spec.Metadata.Instance != nil {
 		i.Metadata.InstanceID = hw.Spec.Metadata.Instance.ID
 		i.Metadata.Hostname = hw.Spec.Metadata.Instance.Hostname
 		i.Metadata.LocalHostname = hw.Spec.Metadata.Instance.Hostname
 		i.Metadata.Tags = hw.Spec.Metadata.Instance.Tags
+		i.Metadata.Rescue = hw.Spec.Metadata.Instance.Rescue
 
 		if hw.Spec.Metadata.Instance.OperatingSystem != nil {
 			i.Metadata.OperatingSystem.Slug = hw.Spec.Metadata.Instance.OperatingSystem.Slug
@@ -204,6 +655,10 @@ func toEC2Instance(hw tinkv1.Hardware) ec2.Instance {
 		i.Userdata = *hw.Spec.UserData
 	}
 
+	if hw.Spec.VendorData != nil {
+		i.Vendordata = *hw.Spec.VendorData
+	}
+
 	// TODO(chrisdoherty4) Support public keys. The frontend doesn't handle public keys correctly
 	// as it expects a single string and just outputs that key. Until we can support multiple keys
 	// its not worth adding it to the metadata.
@@ -1,5 +1,12 @@
 package kubernetes
 
+import (
+	"time"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // NewTestBackend isn't representative of how Backends are constructed but is useful
 // when wanting to validate the business logic around data retrieval and conversion.
 func NewTestBackend(c listerClient, closer <-chan struct{}) *Backend {
@@ -8,3 +15,46 @@ func NewTestBackend(c listerClient, closer <-chan struct{}) *Backend {
 		closer: closer,
 	}
 }
+
+// testHardwareOption configures Hardware created by newTestHardware.
+type testHardwareOption func(*tinkv1.Hardware)
+
+// withCreated sets the creation timestamp of the Hardware.
+func withCreated(t time.Time) testHardwareOption {
+	return func(hw *tinkv1.Hardware) { hw.CreationTimestamp = metav1.NewTime(t) }
+}
+
+// withState sets the metadata state of the Hardware.
+func withState(state string) testHardwareOption {
+	return func(hw *tinkv1.Hardware) { hw.Spec.Metadata.State = state }
+}
+
+// withHostname sets the instance hostname of the Hardware.
+func withHostname(hostname string) testHardwareOption {
+	return func(hw *tinkv1.Hardware) { hw.Spec.Metadata.Instance.Hostname = hostname }
+}
+
+// withUserdata sets the userdata of the Hardware.
+func withUserdata(userdata string) testHardwareOption {
+	return func(hw *tinkv1.Hardware) { hw.Spec.UserData = &userdata }
+}
+
+// newTestHardware creates Hardware named name in the default namespace with a single interface
+// addressed ip. The instance hostname is name.
+func newTestHardware(name, ip string, opts ...testHardwareOption) *tinkv1.Hardware {
+	hw := &tinkv1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: tinkv1.HardwareSpec{
+			Interfaces: []tinkv1.Interface{
+				{DHCP: &tinkv1.DHCP{IP: &tinkv1.IP{Address: ip}}},
+			},
+			Metadata: &tinkv1.HardwareMetadata{
+				Instance: &tinkv1.MetadataInstance{Hostname: name},
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(hw)
+	}
+	return hw
+}
@@ -116,7 +116,16 @@ func TestBackend(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	backend.WaitForCacheSync(ctx)
+
+	go func() {
+		if err := backend.Start(ctx); err != nil {
+			t.Errorf("Starting backend: %v", err)
+		}
+	}()
+
+	if !backend.WaitForCacheSync(ctx) {
+		t.Fatal("Failed to sync cache")
+	}
 
 	ec2instance, err := backend.GetEC2Instance(ctx, ip)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"github.com/pkg/errors"
 	. "github.com/tinkerbell/hegel/internal/backend/kubernetes"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
 	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -437,3 +438,18 @@ func TestGetEC2InstanceWithNoResults(t *testing.T) {
 		t.Fatalf("Expected: ec2.ErrInstanceNotFound; Received: %v", err)
 	}
 }
+
+func TestGetHackInstanceWithNoResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	client := NewTestBackend(lister, nil)
+
+	_, err := client.GetHackInstance(context.Background(), "10.10.10.10")
+	if !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected: hack.ErrInstanceNotFound; Received: %v", err)
+	}
+}
@@ -1,15 +1,30 @@
 package kubernetes
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Config used by the NewBackend function family.
 type Config struct {
-	// Kubeconfig is a path to a valid kubeconfig file. When in-cluster defaults to the in-cluster
-	// config. Optional.
+	// Kubeconfig is a path to a valid kubeconfig file. Multiple files may be separated by the OS
+	// path list separator in which case they're merged like the KUBECONFIG environment variable.
+	// When in-cluster defaults to the in-cluster config. Optional.
 	Kubeconfig string
 
+	// Context is the kubeconfig context used to connect to the cluster. If the context specifies
+	// a namespace and neither Namespace nor Namespaces are set, the backend is restricted to the
+	// context namespace. Defaults to the current context. Optional.
+	Context string
+
 	// APIServerAddress is the address of the kubernetes cluster (https://hostname:port). Optional.
 	APIServerAddress string
 
@@ -17,7 +32,140 @@ type Config struct {
 	// this namespace only. Optional.
 	Namespace string
 
+	// Namespaces restricts the scope of the backend to a set of namespaces. It is combined with
+	// Namespace. When both are empty, Hardware objects are retrieved from all namespaces.
+	// Optional.
+	Namespaces []string
+
+	// LabelSelector restricts the scope of the backend to Hardware objects matching the selector,
+	// for example "hegel.tinkerbell.org/site=dc2". Optional.
+	LabelSelector string
+
+	// IPSources defines where IP addresses used to look up Hardware are sourced from. Defaults
+	// to AllIPSources(). Optional.
+	IPSources []IPSource
+
+	// ConflictPolicy determines how lookups behave when multiple Hardware claim the same IP.
+	// Defaults to ConflictPolicyError. Optional.
+	ConflictPolicy ConflictPolicy
+
+	// HealthProbeInterval is the interval at which the API server is probed to determine the
+	// health of the backend. Defaults to 30s. Optional.
+	HealthProbeInterval time.Duration
+
+	// HealthMaxListAge is the maximum time since the last successful probe before the backend
+	// is considered unhealthy. Defaults to 2m. Optional.
+	HealthMaxListAge time.Duration
+
+	// StaleTolerance enables stale-tolerant mode when greater than 0. In stale-tolerant mode the
+	// backend remains healthy while it serves cached data that can't be synced with the API
+	// server, for example during control plane maintenance, for up to StaleTolerance. Lookups
+	// are served from the cache regardless of the tolerance. Optional.
+	StaleTolerance time.Duration
+
+	// SnapshotPath is a path to a file the backend persists Hardware to. If the file exists at
+	// startup, the backend serves from it until the cache has synced. If empty, snapshots are
+	// disabled. Optional.
+	SnapshotPath string
+
+	// SnapshotInterval is the interval at which changes are written to the snapshot. Defaults to
+	// 1m. Optional.
+	SnapshotInterval time.Duration
+
+	// Precompute maps Hardware to frontend models as Hardware changes instead of on every
+	// lookup. This reduces lookup latency and allocations at the cost of holding the mapped
+	// models in memory. Optional.
+	Precompute bool
+
+	// LazyUserdata keeps userdata out of the cache. Userdata is retrieved from the API server
+	// only when the userdata endpoint is requested and the most recently requested userdata is
+	// retained according to UserdataCacheSize. Other metadata is served from the cache, or
+	// snapshot, as usual. Optional.
+	LazyUserdata bool
+
+	// UserdataCacheSize is the number of Hardware userdata retained when LazyUserdata is
+	// enabled. Defaults to 1000. Optional.
+	UserdataCacheSize int
+
+	// RecordAccess records userdata retrievals onto Hardware as annotations. Access is recorded
+	// asynchronously so lookups aren't slowed. Requires permission to patch Hardware. Optional.
+	RecordAccess bool
+
+	// AccessRecordInterval is the minimum interval between recording access onto the same
+	// Hardware when RecordAccess is enabled. Defaults to 1m. Optional.
+	AccessRecordInterval time.Duration
+
+	// ForwardEvents records boot progress reported by instances as Kubernetes Events on their
+	// Hardware. Optional.
+	ForwardEvents bool
+
+	// RescueUserdata and RescueVendordata are served to Hardware in rescue mode, instead of their
+	// own userdata and vendor-data, unless they reference a ConfigMap using
+	// AnnotationRescueUserdataConfigMap. If RescueUserdata is empty, Hardware in rescue mode that
+	// don't reference a ConfigMap are served their own userdata and vendor-data. Optional.
+	RescueUserdata   string
+	RescueVendordata string
+
+	// Logger is used to log backend events. Optional.
+	Logger logr.Logger
+
+	// Registerer is used to register backend metrics. If nil, no metrics are registered.
+	// Optional.
+	Registerer prometheus.Registerer
+
 	// ClientConfig is a Kubernetes client config. If specified, it will be used instead of
 	// constructing a client using the other configuration in this object. Optional.
 	ClientConfig *rest.Config
 }
+
+// cacheOptions builds the controller-runtime cache options that scope and trim the Hardware
+// objects held in memory. Scoping is applied to the cache so both memory usage and the RBAC
+// required are limited to the objects Hegel serves.
+func (c Config) cacheOptions() (cache.Options, error) {
+	var opts cache.Options
+
+	hardware := cache.ByObject{Transform: transformHardware(c.LazyUserdata)}
+
+	namespaces := c.Namespaces
+	if c.Namespace != "" {
+		namespaces = append([]string{c.Namespace}, namespaces...)
+	}
+
+	for _, ns := range namespaces {
+		if ns == "" {
+			continue
+		}
+		if opts.DefaultNamespaces == nil {
+			opts.DefaultNamespaces = map[string]cache.Config{}
+		}
+		opts.DefaultNamespaces[ns] = cache.Config{}
+	}
+
+	if c.LabelSelector != "" {
+		selector, err := labels.Parse(c.LabelSelector)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("parse label selector: %v", err)
+		}
+		hardware.Label = selector
+	}
+
+	opts.ByObject = map[crclient.Object]cache.ByObject{
+		&tinkv1.Hardware{}: hardware,
+	}
+
+	return opts, nil
+}
+
+// probeNamespace returns a namespace the backend is permitted to list Hardware from. An empty
+// string means all namespaces.
+func (c Config) probeNamespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	for _, ns := range c.Namespaces {
+		if ns != "" {
+			return ns
+		}
+	}
+	return ""
+}
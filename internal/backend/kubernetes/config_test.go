@@ -0,0 +1,77 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+)
+
+func TestConfigCacheOptions(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Config           Config
+		ExpectNamespaces []string
+		ExpectSelector   string
+		ExpectError      bool
+	}{
+		{
+			Name: "AllNamespaces",
+		},
+		{
+			Name:             "SingleNamespace",
+			Config:           Config{Namespace: "foo"},
+			ExpectNamespaces: []string{"foo"},
+		},
+		{
+			Name:             "MultipleNamespaces",
+			Config:           Config{Namespace: "foo", Namespaces: []string{"bar", "foo", ""}},
+			ExpectNamespaces: []string{"bar", "foo"},
+		},
+		{
+			Name:           "LabelSelector",
+			Config:         Config{LabelSelector: "hegel.tinkerbell.org/site=dc2"},
+			ExpectSelector: "hegel.tinkerbell.org/site=dc2",
+		},
+		{
+			Name:        "InvalidLabelSelector",
+			Config:      Config{LabelSelector: "=foo"},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			opts, err := tc.Config.cacheOptions()
+			if tc.ExpectError {
+				if err == nil {
+					t.Fatal("Expected error but received nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var namespaces []string
+			for ns := range opts.DefaultNamespaces {
+				namespaces = append(namespaces, ns)
+			}
+			sortStrings := cmpopts.SortSlices(func(a, b string) bool { return a < b })
+			if !cmp.Equal(namespaces, tc.ExpectNamespaces, sortStrings) {
+				t.Fatal(cmp.Diff(namespaces, tc.ExpectNamespaces, sortStrings))
+			}
+
+			var selector string
+			for obj, byObject := range opts.ByObject {
+				if _, ok := obj.(*tinkv1.Hardware); ok && byObject.Label != nil {
+					selector = byObject.Label.String()
+				}
+			}
+			if selector != tc.ExpectSelector {
+				t.Fatalf("Expected selector: %q; Received: %q", tc.ExpectSelector, selector)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errMultipleHardware indicates more than one Hardware claims an IP and the ConflictPolicy
+// couldn't choose between them.
+var errMultipleHardware = errors.New("multiple hardware found")
+
+// ConflictPolicy determines which Hardware is used when more than one Hardware claims the same
+// IP address.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyError fails lookups for IPs claimed by more than one Hardware.
+	ConflictPolicyError ConflictPolicy = "error"
+
+	// ConflictPolicyOldest uses the Hardware with the oldest creation timestamp. Ties are broken
+	// by namespace and name.
+	ConflictPolicyOldest ConflictPolicy = "oldest"
+
+	// ConflictPolicyProvisioning uses the Hardware whose Spec.Metadata.State is provisioning. If
+	// there isn't exactly one such Hardware the lookup fails.
+	ConflictPolicyProvisioning ConflictPolicy = "provisioning"
+)
+
+// hardwareStateProvisioning is the Spec.Metadata.State value of Hardware being provisioned.
+const hardwareStateProvisioning = "provisioning"
+
+// ipConflictReason is the reason used for events recorded against conflicting Hardware.
+const ipConflictReason = "DuplicateIP"
+
+func (p ConflictPolicy) validate() error {
+	switch p {
+	case ConflictPolicyError, ConflictPolicyOldest, ConflictPolicyProvisioning:
+		return nil
+	default:
+		return fmt.Errorf("unknown conflict policy: %q", p)
+	}
+}
+
+// resolve chooses a single Hardware from hw according to p. hw must contain at least 2 items.
+func (p ConflictPolicy) resolve(ip string, hw []tinkv1.Hardware) (tinkv1.Hardware, error) {
+	switch p {
+	case ConflictPolicyOldest:
+		sorted := make([]tinkv1.Hardware, len(hw))
+		copy(sorted, hw)
+		sort.Slice(sorted, func(i, j int) bool {
+			a, b := sorted[i], sorted[j]
+			if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+				return a.CreationTimestamp.Before(&b.CreationTimestamp)
+			}
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			return a.Name < b.Name
+		})
+		return sorted[0], nil
+
+	case ConflictPolicyProvisioning:
+		var candidates []tinkv1.Hardware
+		for _, h := range hw {
+			if h.Spec.Metadata != nil && strings.EqualFold(h.Spec.Metadata.State, hardwareStateProvisioning) {
+				candidates = append(candidates, h)
+			}
+		}
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+	}
+
+	return tinkv1.Hardware{}, fmt.Errorf("%w for %v: %v", errMultipleHardware, ip, hardwareNames(hw))
+}
+
+// conflictDetector records events against Hardware that share IP addresses with other Hardware
+// and maintains a gauge of the number of conflicting IPs. It tracks the IPs claimed by each
+// Hardware as they change so events are only recorded when the Hardware claiming an IP change,
+// not on every update or resync.
+type conflictDetector struct {
+	recorder  record.EventRecorder
+	indexFunc func(crclient.Object) []string
+
+	// conflicts is the number of IPs claimed by more than one Hardware.
+	conflicts prometheus.Gauge
+
+	mtx sync.Mutex
+
+	// claims maps each IP to the Hardware claiming it.
+	claims map[string]map[types.NamespacedName]*tinkv1.Hardware
+
+	// ips maps each Hardware to the IPs it claims.
+	ips map[types.NamespacedName][]string
+
+	// conflicting is the number of IPs in claims claimed by more than one Hardware. It's adjusted
+	// as IPs change so updating the gauge doesn't walk claims.
+	conflicting int
+}
+
+func newConflictDetector(recorder record.EventRecorder, indexFunc func(crclient.Object) []string) *conflictDetector {
+	return &conflictDetector{
+		recorder:  recorder,
+		indexFunc: indexFunc,
+		conflicts: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "hardware_conflicting_ips",
+			Help: "Number of IP addresses claimed by more than one Hardware resource",
+		}),
+		claims: map[string]map[types.NamespacedName]*tinkv1.Hardware{},
+		ips:    map[types.NamespacedName][]string{},
+	}
+}
+
+// OnAdd satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (d *conflictDetector) OnAdd(obj interface{}, _ bool) {
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		d.set(hw, d.indexFunc(hw))
+	}
+}
+
+// OnUpdate satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (d *conflictDetector) OnUpdate(_, obj interface{}) {
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		d.set(hw, d.indexFunc(hw))
+	}
+}
+
+// OnDelete satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (d *conflictDetector) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		d.set(hw, nil)
+	}
+}
+
+// set records hw as claiming ips, replacing the IPs it previously claimed, and records events
+// for IPs whose conflicting Hardware changed.
+func (d *conflictDetector) set(hw *tinkv1.Hardware, ips []string) {
+	key := types.NamespacedName{Namespace: hw.Namespace, Name: hw.Name}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	// IPs hw no longer claims change the Hardware claiming them.
+	changed := map[string]bool{}
+	for _, ip := range d.ips[key] {
+		changed[ip] = true
+	}
+	for _, ip := range ips {
+		delete(changed, ip)
+	}
+	for ip := range changed {
+		before := len(d.claims[ip])
+		delete(d.claims[ip], key)
+		if len(d.claims[ip]) == 0 {
+			delete(d.claims, ip)
+		}
+		d.adjustLocked(before, len(d.claims[ip]))
+	}
+
+	// Newly claimed IPs change the Hardware claiming them. IPs hw already claimed are updated
+	// so events reference the latest Hardware but haven't changed.
+	for _, ip := range ips {
+		claimants, ok := d.claims[ip]
+		if !ok {
+			claimants = map[types.NamespacedName]*tinkv1.Hardware{}
+			d.claims[ip] = claimants
+		}
+		if _, ok := claimants[key]; !ok {
+			changed[ip] = true
+		}
+		before := len(claimants)
+		claimants[key] = hw
+		d.adjustLocked(before, len(claimants))
+	}
+
+	if len(ips) == 0 {
+		delete(d.ips, key)
+	} else {
+		d.ips[key] = ips
+	}
+
+	for ip := range changed {
+		if len(d.claims[ip]) > 1 {
+			d.recordLocked(ip)
+		}
+	}
+
+	d.conflicts.Set(float64(d.conflicting))
+}
+
+// adjustLocked adjusts the number of conflicting IPs for an IP whose claimant count changed from
+// before to after. d.mtx must be held.
+func (d *conflictDetector) adjustLocked(before, after int) {
+	switch {
+	case before <= 1 && after > 1:
+		d.conflicting++
+	case before > 1 && after <= 1:
+		d.conflicting--
+	}
+}
+
+// recordLocked records an event against every Hardware claiming ip. d.mtx must be held.
+func (d *conflictDetector) recordLocked(ip string) {
+	hw := make([]tinkv1.Hardware, 0, len(d.claims[ip]))
+	for _, h := range d.claims[ip] {
+		hw = append(hw, *h)
+	}
+
+	names := hardwareNames(hw)
+	for i := range hw {
+		d.recorder.Eventf(
+			&hw[i],
+			corev1.EventTypeWarning,
+			ipConflictReason,
+			"IP %v is claimed by multiple Hardware: %v",
+			ip,
+			names,
+		)
+	}
+}
+
+func hardwareNames(hw []tinkv1.Hardware) string {
+	names := make([]string, 0, len(hw))
+	for _, h := range hw {
+		names = append(names, h.Namespace+"/"+h.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,155 @@
+package kubernetes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestConflictPolicyResolve(t *testing.T) {
+	now := time.Now()
+	older := *newTestHardware("older", "10.10.10.10", withCreated(now.Add(-time.Hour)))
+	newer := *newTestHardware("newer", "10.10.10.10", withCreated(now), withState("provisioning"))
+	sameAge := *newTestHardware("a-same-age", "10.10.10.10", withCreated(now))
+
+	cases := []struct {
+		Name     string
+		Policy   ConflictPolicy
+		Hardware []tinkv1.Hardware
+		Expect   string
+		Error    bool
+	}{
+		{
+			Name:     "Error",
+			Policy:   ConflictPolicyError,
+			Hardware: []tinkv1.Hardware{older, newer},
+			Error:    true,
+		},
+		{
+			Name:     "Oldest",
+			Policy:   ConflictPolicyOldest,
+			Hardware: []tinkv1.Hardware{newer, older},
+			Expect:   "older",
+		},
+		{
+			Name:     "OldestTieBrokenByName",
+			Policy:   ConflictPolicyOldest,
+			Hardware: []tinkv1.Hardware{newer, sameAge},
+			Expect:   "a-same-age",
+		},
+		{
+			Name:     "Provisioning",
+			Policy:   ConflictPolicyProvisioning,
+			Hardware: []tinkv1.Hardware{older, newer},
+			Expect:   "newer",
+		},
+		{
+			Name:     "ProvisioningNoCandidates",
+			Policy:   ConflictPolicyProvisioning,
+			Hardware: []tinkv1.Hardware{older, sameAge},
+			Error:    true,
+		},
+		{
+			Name:     "ProvisioningMultipleCandidates",
+			Policy:   ConflictPolicyProvisioning,
+			Hardware: []tinkv1.Hardware{newer, newer},
+			Error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			hw, err := tc.Policy.resolve("10.10.10.10", tc.Hardware)
+			if tc.Error {
+				if !errors.Is(err, errMultipleHardware) {
+					t.Fatalf("Expected: %v; Received: %v", errMultipleHardware, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hw.Name != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, hw.Name)
+			}
+		})
+	}
+}
+
+func TestConflictDetector(t *testing.T) {
+	first := *newTestHardware("first", "10.10.10.10")
+	second := *newTestHardware("second", "10.10.10.10")
+	unique := *newTestHardware("unique", "10.10.10.20")
+
+	recorder := record.NewFakeRecorder(10)
+	detector := newConflictDetector(recorder, hardwareIPIndexFunc(AllIPSources()))
+
+	detector.OnAdd(&unique, false)
+	detector.OnAdd(&first, false)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Expected no events; Received: %v", <-recorder.Events)
+	}
+
+	detector.OnAdd(&second, false)
+	if len(recorder.Events) != 2 {
+		t.Fatalf("Expected 2 events; Received: %v", len(recorder.Events))
+	}
+
+	event := <-recorder.Events
+	<-recorder.Events
+	if !strings.Contains(event, ipConflictReason) || !strings.Contains(event, "default/first, default/second") {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+
+	if count := testutil.ToFloat64(detector.conflicts); count != 1 {
+		t.Fatalf("Expected 1 conflicting IP; Received: %v", count)
+	}
+
+	// Updates and resyncs that don't change the conflicting Hardware don't record events.
+	detector.OnUpdate(&first, &first)
+	detector.OnUpdate(&second, &second)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Expected no events; Received: %v", <-recorder.Events)
+	}
+
+	// Resolving the conflict updates the count.
+	detector.OnDelete(&second)
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Expected no events; Received: %v", <-recorder.Events)
+	}
+	if count := testutil.ToFloat64(detector.conflicts); count != 0 {
+		t.Fatalf("Expected 0 conflicting IPs; Received: %v", count)
+	}
+
+	// Moving a Hardware onto a claimed IP is a new conflict.
+	moved := *newTestHardware("unique", "10.10.10.10")
+	detector.OnUpdate(&unique, &moved)
+	if len(recorder.Events) != 2 {
+		t.Fatalf("Expected 2 events; Received: %v", len(recorder.Events))
+	}
+	if count := testutil.ToFloat64(detector.conflicts); count != 1 {
+		t.Fatalf("Expected 1 conflicting IP; Received: %v", count)
+	}
+
+	// Further claimants of a conflicting IP don't change the count until the IP has a single
+	// claimant.
+	detector.OnAdd(&second, false)
+	if count := testutil.ToFloat64(detector.conflicts); count != 1 {
+		t.Fatalf("Expected 1 conflicting IP; Received: %v", count)
+	}
+	detector.OnDelete(&second)
+	if count := testutil.ToFloat64(detector.conflicts); count != 1 {
+		t.Fatalf("Expected 1 conflicting IP; Received: %v", count)
+	}
+	detector.OnDelete(&moved)
+	if count := testutil.ToFloat64(detector.conflicts); count != 0 {
+		t.Fatalf("Expected 0 conflicting IPs; Received: %v", count)
+	}
+}
@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of events forwarded from instances.
+const (
+	phoneHomeReason       = "PhoneHome"
+	cloudInitStartReason  = "CloudInitStart"
+	cloudInitFinishReason = "CloudInitFinish"
+	cloudInitReason       = "CloudInit"
+)
+
+// eventForwarder records events reported by instances as Kubernetes Events on their Hardware.
+type eventForwarder struct {
+	lookup   func(ctx context.Context, ip string) (tinkv1.Hardware, error)
+	recorder record.EventRecorder
+	logger   logr.Logger
+}
+
+// forward records e on the Hardware of the instance that reported it. The recorder queues
+// events so forwarding doesn't wait on the API server.
+func (f eventForwarder) forward(ctx context.Context, e phonehome.Event) {
+	hw, err := f.lookup(ctx, e.IP)
+	if err != nil {
+		if !errors.Is(err, errNotFound) {
+			f.logger.Error(err, "Retrieving Hardware to forward event", "ip", e.IP)
+		}
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if e.Failed() {
+		eventType = corev1.EventTypeWarning
+	}
+
+	switch e.Kind {
+	case phonehome.KindPhoneHome:
+		f.recorder.Eventf(&hw, eventType, phoneHomeReason, "Instance phoned home from %v", e.IP)
+	default:
+		message := e.Name
+		if e.Description != "" {
+			message += ": " + e.Description
+		}
+		if e.Result != "" {
+			message += " (" + e.Result + ")"
+		}
+		f.recorder.Event(&hw, eventType, cloudInitReasonFor(e.Type), message)
+	}
+}
+
+func cloudInitReasonFor(eventType string) string {
+	switch eventType {
+	case "start":
+		return cloudInitStartReason
+	case "finish":
+		return cloudInitFinishReason
+	default:
+		return cloudInitReason
+	}
+}
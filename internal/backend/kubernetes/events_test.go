@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventForwarder(t *testing.T) {
+	hw := tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"}}
+	lookup := func(_ context.Context, ip string) (tinkv1.Hardware, error) {
+		if ip != "10.10.10.10" {
+			return tinkv1.Hardware{}, errNotFound
+		}
+		return hw, nil
+	}
+
+	cases := []struct {
+		Name   string
+		Event  phonehome.Event
+		Expect string
+	}{
+		{
+			Name:   "PhoneHome",
+			Event:  phonehome.Event{IP: "10.10.10.10", Kind: phonehome.KindPhoneHome},
+			Expect: "Normal PhoneHome Instance phoned home from 10.10.10.10",
+		},
+		{
+			Name: "ReportStart",
+			Event: phonehome.Event{
+				IP:          "10.10.10.10",
+				Kind:        phonehome.KindReport,
+				Name:        "init-network",
+				Type:        "start",
+				Description: "searching for network datasources",
+			},
+			Expect: "Normal CloudInitStart init-network: searching for network datasources",
+		},
+		{
+			Name: "ReportFailure",
+			Event: phonehome.Event{
+				IP:     "10.10.10.10",
+				Kind:   phonehome.KindReport,
+				Name:   "modules-final",
+				Type:   "finish",
+				Result: "FAIL",
+			},
+			Expect: "Warning CloudInitFinish modules-final (FAIL)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			forwarder := eventForwarder{lookup: lookup, recorder: recorder, logger: logr.Discard()}
+
+			forwarder.forward(context.Background(), tc.Event)
+
+			if len(recorder.Events) != 1 {
+				t.Fatalf("Expected 1 event; Received: %v", len(recorder.Events))
+			}
+			if event := <-recorder.Events; event != tc.Expect {
+				t.Fatalf("Expected %q; Received %q", tc.Expect, event)
+			}
+		})
+	}
+}
+
+func TestEventForwarderUnknownInstance(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	forwarder := eventForwarder{
+		lookup: func(context.Context, string) (tinkv1.Hardware, error) {
+			return tinkv1.Hardware{}, errNotFound
+		},
+		recorder: recorder,
+		logger:   logr.Discard(),
+	}
+
+	forwarder.forward(context.Background(), phonehome.Event{IP: "10.10.10.11", Kind: phonehome.KindPhoneHome})
+
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Expected no events; Received: %v", <-recorder.Events)
+	}
+}
@@ -3,18 +3,53 @@ package kubernetes
 import (
 	"context"
 	"encoding/json"
+	"errors"
 
 	"github.com/tinkerbell/hegel/internal/frontend/hack"
 	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
 )
 
+// GetHackInstance satisfies hack.Client.
 func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	if p, ok, err := b.retrievePrecomputedByIP(ip); ok {
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				return hack.Instance{}, hack.ErrInstanceNotFound
+			}
+
+			return hack.Instance{}, err
+		}
+
+		if p.hackErr == nil {
+			b.recordHackLookup(p.hw, ip)
+		}
+
+		return p.hack, p.hackErr
+	}
+
 	hw, err := b.retrieveByIP(ctx, ip)
 	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return hack.Instance{}, hack.ErrInstanceNotFound
+		}
+
 		return hack.Instance{}, err
 	}
 
-	return toHackInstance(hw)
+	instance, err := toHackInstance(hw)
+	if err != nil {
+		return hack.Instance{}, err
+	}
+
+	b.recordHackLookup(&hw, ip)
+
+	return instance, nil
+}
+
+// recordHackLookup records events for a successful hack lookup. The hack frontend serves the
+// provisioning environment so the lookup indicates hw is provisioning.
+func (b *Backend) recordHackLookup(hw *tinkv1.Hardware, ip string) {
+	b.lookupEvents.provisioningLookup(hw, ip)
 }
 
 // toHackInstance converts a Tinkerbell Hardware resource to a hack.Instance by marshalling and
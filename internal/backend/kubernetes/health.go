@@ -0,0 +1,221 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	toolscache "k8s.io/client-go/tools/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// defaultHealthProbeInterval is the default interval between API server probes.
+	defaultHealthProbeInterval = 30 * time.Second
+
+	// defaultHealthMaxListAge is the default maximum time since the last successful API server
+	// list before the backend is considered unhealthy.
+	defaultHealthMaxListAge = 2 * time.Minute
+)
+
+// healthTracker records the state of the connection to the API server. It is updated by
+// periodic probes that list Hardware directly from the API server and by the watch error
+// handler of the informer cache.
+type healthTracker struct {
+	mtx sync.RWMutex
+
+	// lastList is the time of the last successful list from the API server.
+	lastList time.Time
+
+	// lastErr and lastErrTime describe the most recent list or watch error.
+	lastErr     error
+	lastErrTime time.Time
+
+	// now is used to retrieve the current time. It exists for testing.
+	now func() time.Time
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{now: time.Now}
+}
+
+func (h *healthTracker) recordList() {
+	h.recordListAt(h.now())
+}
+
+// recordListAt records a successful list at t if t is after the last recorded list.
+func (h *healthTracker) recordListAt(t time.Time) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if t.After(h.lastList) {
+		h.lastList = t
+	}
+}
+
+func (h *healthTracker) recordError(err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.lastErr = err
+	h.lastErrTime = h.now()
+}
+
+// watchErrorHandler satisfies k8s.io/client-go/tools/cache.WatchErrorHandler. It records err
+// and delegates to the client-go default handler so errors continue to be logged.
+func (h *healthTracker) watchErrorHandler(r *toolscache.Reflector, err error) {
+	h.recordError(err)
+	toolscache.DefaultWatchErrorHandler(r, err)
+}
+
+// healthy returns true if check returns nil.
+func (h *healthTracker) healthy(maxListAge, staleTolerance time.Duration) bool {
+	return h.check(maxListAge, staleTolerance) == nil
+}
+
+// check returns an error describing why the tracker is unhealthy, or nil if it is healthy. The
+// tracker is healthy if the last successful list occurred within maxListAge and no error has been
+// observed since. If staleTolerance is greater than 0, the tracker remains healthy while the data
+// age is within staleTolerance.
+func (h *healthTracker) check(maxListAge, staleTolerance time.Duration) error {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	if h.lastList.IsZero() {
+		if h.lastErr != nil {
+			return fmt.Errorf("no successful list from api server: %w", h.lastErr)
+		}
+		return errors.New("no successful list from api server")
+	}
+
+	if staleTolerance > 0 {
+		if age := h.dataAgeLocked(maxListAge); age > staleTolerance {
+			return fmt.Errorf(
+				"data is stale: last synced with api server %v ago exceeding tolerance of %v",
+				age.Round(time.Second),
+				staleTolerance,
+			)
+		}
+		return nil
+	}
+
+	if age := h.now().Sub(h.lastList); age > maxListAge {
+		return fmt.Errorf("last successful list from api server was %v ago", age.Round(time.Second))
+	}
+
+	if !h.lastErrTime.Before(h.lastList) {
+		return h.lastErr
+	}
+
+	return nil
+}
+
+// dataAge returns how long it has been since the cached data was known to be in sync with the
+// API server. It returns 0 while the API server is reachable or before the first successful
+// list.
+func (h *healthTracker) dataAge(maxListAge time.Duration) time.Duration {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	return h.dataAgeLocked(maxListAge)
+}
+
+func (h *healthTracker) dataAgeLocked(maxListAge time.Duration) time.Duration {
+	if h.lastList.IsZero() {
+		return 0
+	}
+
+	sinceList := h.now().Sub(h.lastList)
+	if h.lastErrTime.Before(h.lastList) && sinceList <= maxListAge {
+		return 0
+	}
+
+	return sinceList
+}
+
+// apiProber periodically lists Hardware from the API server to verify connectivity.
+type apiProber struct {
+	reader    listerClient
+	tracker   *healthTracker
+	interval  time.Duration
+	namespace string
+}
+
+// run probes the API server every p.interval until ctx is cancelled. The first probe is
+// performed immediately.
+func (p apiProber) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p apiProber) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	opts := []crclient.ListOption{crclient.Limit(1)}
+	if p.namespace != "" {
+		opts = append(opts, crclient.InNamespace(p.namespace))
+	}
+
+	var hw tinkv1.HardwareList
+	if err := p.reader.List(ctx, &hw, opts...); err != nil {
+		if ctx.Err() == nil {
+			p.tracker.recordError(err)
+		}
+		return
+	}
+
+	p.tracker.recordList()
+}
+
+// HealthChecks satisfies healthcheck.CheckProvider.
+func (b *Backend) HealthChecks() []healthcheck.Checker {
+	return []healthcheck.Checker{
+		healthcheck.NewChecker("kubernetes-api", func(context.Context) error {
+			if b.health == nil {
+				return nil
+			}
+			return b.health.check(b.maxListAge, b.staleTolerance)
+		}),
+		healthcheck.NewChecker("kubernetes-cache-sync", func(context.Context) error {
+			if b.informer != nil && !b.informer.HasSynced() && b.snapshot.Load() == nil {
+				return errors.New("hardware cache has not synced")
+			}
+			return nil
+		}),
+	}
+}
+
+// DataAge satisfies healthcheck.DataAgeSource. It returns how long it has been since the backend
+// was known to be in sync with the API server.
+func (b *Backend) DataAge() time.Duration {
+	if b.health == nil {
+		return 0
+	}
+	return b.health.dataAge(b.maxListAge)
+}
+
+// newDataAgeGauge creates a gauge that reports b.DataAge on each collection.
+func newDataAgeGauge(b *Backend) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "backend_data_age_seconds",
+			Help: "Seconds since the backend data was last known to be in sync with its source",
+		},
+		func() float64 {
+			return b.DataAge().Seconds()
+		},
+	)
+}
@@ -0,0 +1,182 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHealthTracker(t *testing.T) {
+	now := time.Now()
+	maxListAge := time.Minute
+
+	cases := []struct {
+		Name           string
+		StaleTolerance time.Duration
+		Record         func(*healthTracker, *time.Time)
+		Healthy        bool
+		DataAge        time.Duration
+	}{
+		{
+			Name:    "NoList",
+			Record:  func(*healthTracker, *time.Time) {},
+			Healthy: false,
+		},
+		{
+			Name: "RecentList",
+			Record: func(h *healthTracker, _ *time.Time) {
+				h.recordList()
+			},
+			Healthy: true,
+		},
+		{
+			Name: "StaleList",
+			Record: func(h *healthTracker, clock *time.Time) {
+				h.recordList()
+				*clock = clock.Add(2 * maxListAge)
+			},
+			Healthy: false,
+			DataAge: 2 * maxListAge,
+		},
+		{
+			Name: "ErrorAfterList",
+			Record: func(h *healthTracker, clock *time.Time) {
+				h.recordList()
+				*clock = clock.Add(time.Second)
+				h.recordError(errors.New("watch failed"))
+			},
+			Healthy: false,
+			DataAge: time.Second,
+		},
+		{
+			Name:           "StaleWithinTolerance",
+			StaleTolerance: 10 * time.Minute,
+			Record: func(h *healthTracker, clock *time.Time) {
+				h.recordList()
+				*clock = clock.Add(time.Second)
+				h.recordError(errors.New("watch failed"))
+				*clock = clock.Add(4 * time.Minute)
+			},
+			Healthy: true,
+			DataAge: 4*time.Minute + time.Second,
+		},
+		{
+			Name:           "StaleBeyondTolerance",
+			StaleTolerance: 10 * time.Minute,
+			Record: func(h *healthTracker, clock *time.Time) {
+				h.recordList()
+				*clock = clock.Add(time.Second)
+				h.recordError(errors.New("watch failed"))
+				*clock = clock.Add(10 * time.Minute)
+			},
+			Healthy: false,
+			DataAge: 10*time.Minute + time.Second,
+		},
+		{
+			Name:           "StaleToleranceWithoutList",
+			StaleTolerance: 10 * time.Minute,
+			Record: func(h *healthTracker, _ *time.Time) {
+				h.recordError(errors.New("connection refused"))
+			},
+			Healthy: false,
+		},
+		{
+			Name: "ListAfterError",
+			Record: func(h *healthTracker, clock *time.Time) {
+				h.recordError(errors.New("watch failed"))
+				*clock = clock.Add(time.Second)
+				h.recordList()
+			},
+			Healthy: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			clock := now
+			h := newHealthTracker()
+			h.now = func() time.Time { return clock }
+
+			tc.Record(h, &clock)
+
+			if healthy := h.healthy(maxListAge, tc.StaleTolerance); healthy != tc.Healthy {
+				t.Fatalf("Expected healthy: %v; Received: %v", tc.Healthy, healthy)
+			}
+
+			if age := h.dataAge(maxListAge); age != tc.DataAge {
+				t.Fatalf("Expected data age: %v; Received: %v", tc.DataAge, age)
+			}
+		})
+	}
+}
+
+func TestAPIProber(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tracker := newHealthTracker()
+		prober := apiProber{
+			reader:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+			tracker:  tracker,
+			interval: time.Second,
+		}
+
+		prober.probe(context.Background())
+
+		if !tracker.healthy(time.Minute, 0) {
+			t.Fatal("Expected healthy tracker after successful probe")
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		reader := NewMocklisterClient(ctrl)
+		reader.EXPECT().
+			List(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(errors.New("connection refused"))
+
+		tracker := newHealthTracker()
+		tracker.recordList()
+		tracker.now = func() time.Time { return time.Now().Add(time.Second) }
+
+		prober := apiProber{
+			reader:   reader,
+			tracker:  tracker,
+			interval: time.Second,
+		}
+
+		prober.probe(context.Background())
+
+		if tracker.healthy(time.Minute, 0) {
+			t.Fatal("Expected unhealthy tracker after failed probe")
+		}
+	})
+}
+
+func TestHealthChecks(t *testing.T) {
+	tracker := newHealthTracker()
+	b := &Backend{health: tracker, maxListAge: time.Minute}
+
+	results := map[string]error{}
+	for _, c := range b.HealthChecks() {
+		results[c.Name()] = c.Check(context.Background())
+	}
+
+	if results["kubernetes-api"] == nil {
+		t.Fatal("Expected kubernetes-api check to fail before a successful list")
+	}
+
+	if err := results["kubernetes-cache-sync"]; err != nil {
+		t.Fatalf("Expected nil error; Received: %v", err)
+	}
+
+	tracker.recordList()
+
+	for _, c := range b.HealthChecks() {
+		if err := c.Check(context.Background()); err != nil {
+			t.Fatalf("Check %v: expected nil error; Received: %v", c.Name(), err)
+		}
+	}
+}
@@ -1,25 +1,90 @@
 package kubernetes
 
 import (
+	"fmt"
+
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // hardwareIPAddrIndex is the index used to retrieve hardware by IP address. It is used with
-// the controller-runtimes MatchingFields selector.
-const hardwareIPAddrIndex = ".Spec.Interfaces.DHCP.IP"
-
-// hardwareIPIndexFunc satisfies the controller runtimes index.
-func hardwareIPIndexFunc(obj client.Object) []string {
-	hw, ok := obj.(*v1alpha1.Hardware)
-	if !ok {
-		return nil
+// the controller-runtimes MatchingFields selector. Indexed addresses are normalized so lookups
+// must normalize the IP before using the index.
+const hardwareIPAddrIndex = ".Spec.IPs"
+
+// IPSource identifies a location on a Hardware resource that IP addresses are indexed from.
+type IPSource string
+
+const (
+	// IPSourceDHCP indexes addresses from Spec.Interfaces[].DHCP.IP.Address.
+	IPSourceDHCP IPSource = "dhcp"
+
+	// IPSourceInstance indexes addresses from Spec.Metadata.Instance.Ips[].Address.
+	IPSourceInstance IPSource = "instance"
+)
+
+// AllIPSources returns every supported IPSource.
+func AllIPSources() []IPSource {
+	return []IPSource{IPSourceDHCP, IPSourceInstance}
+}
+
+func validateIPSources(sources []IPSource) error {
+	for _, s := range sources {
+		switch s {
+		case IPSourceDHCP, IPSourceInstance:
+		default:
+			return fmt.Errorf("unknown ip source: %q", s)
+		}
 	}
-	resp := []string{}
-	for _, iface := range hw.Spec.Interfaces {
-		if iface.DHCP != nil && iface.DHCP.IP != nil && iface.DHCP.IP.Address != "" {
-			resp = append(resp, iface.DHCP.IP.Address)
+	return nil
+}
+
+// hardwareIPIndexFunc returns a controller-runtime index function that indexes Hardware by the
+// normalized addresses found in sources. Addresses that can't be parsed are ignored.
+func hardwareIPIndexFunc(sources []IPSource) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		hw, ok := obj.(*v1alpha1.Hardware)
+		if !ok {
+			return nil
+		}
+
+		var addrs []string
+		for _, s := range sources {
+			switch s {
+			case IPSourceDHCP:
+				for _, iface := range hw.Spec.Interfaces {
+					if iface.DHCP != nil && iface.DHCP.IP != nil {
+						addrs = append(addrs, iface.DHCP.IP.Address)
+					}
+				}
+			case IPSourceInstance:
+				if hw.Spec.Metadata != nil && hw.Spec.Metadata.Instance != nil {
+					for _, ip := range hw.Spec.Metadata.Instance.Ips {
+						if ip != nil {
+							addrs = append(addrs, ip.Address)
+						}
+					}
+				}
+			}
+		}
+
+		// Normalize and de-duplicate so a Hardware listing the same address in multiple places
+		// is only indexed once for that address.
+		seen := make(map[string]struct{}, len(addrs))
+		resp := []string{}
+		for _, addr := range addrs {
+			normalized, err := ipaddr.Normalize(addr)
+			if err != nil {
+				continue
+			}
+			if _, ok := seen[normalized]; ok {
+				continue
+			}
+			seen[normalized] = struct{}{}
+			resp = append(resp, normalized)
 		}
+
+		return resp
 	}
-	return resp
 }
@@ -0,0 +1,62 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+)
+
+func TestHardwareIPIndexFunc(t *testing.T) {
+	hw := &tinkv1.Hardware{
+		Spec: tinkv1.HardwareSpec{
+			Interfaces: []tinkv1.Interface{
+				{DHCP: &tinkv1.DHCP{IP: &tinkv1.IP{Address: "10.10.10.10"}}},
+				{DHCP: &tinkv1.DHCP{IP: &tinkv1.IP{Address: "2001:0db8:0000:0000:0000:0000:0000:0001"}}},
+				{DHCP: &tinkv1.DHCP{}},
+				{},
+			},
+			Metadata: &tinkv1.HardwareMetadata{
+				Instance: &tinkv1.MetadataInstance{
+					Ips: []*tinkv1.MetadataInstanceIP{
+						{Address: "::ffff:10.10.10.10"},
+						{Address: "192.168.1.10"},
+						{Address: "invalid"},
+						nil,
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Name    string
+		Sources []IPSource
+		Expect  []string
+	}{
+		{
+			Name:    "DHCP",
+			Sources: []IPSource{IPSourceDHCP},
+			Expect:  []string{"10.10.10.10", "2001:db8::1"},
+		},
+		{
+			Name:    "Instance",
+			Sources: []IPSource{IPSourceInstance},
+			Expect:  []string{"10.10.10.10", "192.168.1.10"},
+		},
+		{
+			Name:    "All",
+			Sources: AllIPSources(),
+			Expect:  []string{"10.10.10.10", "2001:db8::1", "192.168.1.10"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := hardwareIPIndexFunc(tc.Sources)(hw)
+			if !cmp.Equal(got, tc.Expect) {
+				t.Fatal(cmp.Diff(got, tc.Expect))
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"strings"
+	"sync"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of events recorded while serving lookups.
+const (
+	metadataServedReason = "MetadataServed"
+	lookupConflictReason = "LookupConflict"
+	bootNotAllowedReason = "BootNotAllowed"
+)
+
+// lookupEvents records events on Hardware for notable lookups so they're visible using
+// kubectl describe. A nil *lookupEvents records nothing.
+type lookupEvents struct {
+	recorder record.EventRecorder
+
+	mtx sync.Mutex
+
+	// served is the generation of each Hardware when its metadata was last served.
+	served map[types.UID]int64
+}
+
+func newLookupEvents(recorder record.EventRecorder) *lookupEvents {
+	return &lookupEvents{
+		recorder: recorder,
+		served:   map[types.UID]int64{},
+	}
+}
+
+// metadataServed records the first time metadata is served for each generation of hw.
+func (e *lookupEvents) metadataServed(hw *tinkv1.Hardware, ip string) {
+	if e == nil || hw == nil {
+		return
+	}
+
+	e.mtx.Lock()
+	generation, ok := e.served[hw.UID]
+	e.served[hw.UID] = hw.Generation
+	e.mtx.Unlock()
+
+	if ok && generation == hw.Generation {
+		return
+	}
+
+	e.recorder.Eventf(
+		hw,
+		corev1.EventTypeNormal,
+		metadataServedReason,
+		"Metadata served to %v for generation %v",
+		ip,
+		hw.Generation,
+	)
+}
+
+// conflict records a lookup from ip that failed because hw claim ip.
+func (e *lookupEvents) conflict(ip string, hw []tinkv1.Hardware) {
+	if e == nil {
+		return
+	}
+
+	names := hardwareNames(hw)
+	for i := range hw {
+		e.recorder.Eventf(
+			&hw[i],
+			corev1.EventTypeWarning,
+			lookupConflictReason,
+			"Lookup from %v failed; the IP is claimed by multiple Hardware: %v",
+			ip,
+			names,
+		)
+	}
+}
+
+// provisioningLookup records a lookup by the provisioning environment for hw if hw doesn't allow
+// PXE booting or workflows, as the machine shouldn't be provisioning.
+func (e *lookupEvents) provisioningLookup(hw *tinkv1.Hardware, ip string) {
+	if e == nil || hw == nil {
+		return
+	}
+
+	var netboot, allowPXE, allowWorkflow bool
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.Netboot == nil {
+			continue
+		}
+		netboot = true
+		allowPXE = allowPXE || (iface.Netboot.AllowPXE != nil && *iface.Netboot.AllowPXE)
+		allowWorkflow = allowWorkflow || (iface.Netboot.AllowWorkflow != nil && *iface.Netboot.AllowWorkflow)
+	}
+
+	// Hardware without netboot configuration isn't managed by Tinkerbell's provisioning
+	// services so we can't tell whether it should be provisioning.
+	if !netboot || (allowPXE && allowWorkflow) {
+		return
+	}
+
+	var disallowed []string
+	if !allowPXE {
+		disallowed = append(disallowed, "PXE")
+	}
+	if !allowWorkflow {
+		disallowed = append(disallowed, "workflows")
+	}
+
+	e.recorder.Eventf(
+		hw,
+		corev1.EventTypeWarning,
+		bootNotAllowedReason,
+		"Provisioning metadata requested by %v but the Hardware doesn't allow %v",
+		ip,
+		strings.Join(disallowed, " or "),
+	)
+}
+
+// OnAdd satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (e *lookupEvents) OnAdd(interface{}, bool) {}
+
+// OnUpdate satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (e *lookupEvents) OnUpdate(interface{}, interface{}) {}
+
+// OnDelete satisfies k8s.io/client-go/tools/cache.ResourceEventHandler. It forgets deleted
+// Hardware.
+func (e *lookupEvents) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		e.mtx.Lock()
+		defer e.mtx.Unlock()
+		delete(e.served, hw.UID)
+	}
+}
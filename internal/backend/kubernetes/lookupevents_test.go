@@ -0,0 +1,170 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLookupEventsMetadataServed(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events := newLookupEvents(recorder)
+
+	hw := &tinkv1.Hardware{ObjectMeta: metav1.ObjectMeta{
+		Name:       "machine",
+		Namespace:  "default",
+		UID:        "1",
+		Generation: 1,
+	}}
+
+	events.metadataServed(hw, "10.10.10.10")
+	events.metadataServed(hw, "10.10.10.10")
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected 1 event; Received: %v", len(recorder.Events))
+	}
+	expect := "Normal MetadataServed Metadata served to 10.10.10.10 for generation 1"
+	if event := <-recorder.Events; event != expect {
+		t.Fatalf("Expected %q; Received %q", expect, event)
+	}
+
+	hw.Generation = 2
+	events.metadataServed(hw, "10.10.10.10")
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected 1 event after spec change; Received: %v", len(recorder.Events))
+	}
+	<-recorder.Events
+
+	events.OnDelete(toolscache.DeletedFinalStateUnknown{Obj: hw})
+	events.metadataServed(hw, "10.10.10.10")
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected 1 event after delete; Received: %v", len(recorder.Events))
+	}
+}
+
+func TestBackendRecordServe(t *testing.T) {
+	hw := &tinkv1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default", UID: "1", Generation: 1},
+		Spec: tinkv1.HardwareSpec{Interfaces: []tinkv1.Interface{
+			{DHCP: &tinkv1.DHCP{IP: &tinkv1.IP{Address: "10.10.10.10"}}},
+		}},
+	}
+
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+	store.OnAdd(hw, true)
+	store.synced = func() bool { return true }
+
+	recorder := record.NewFakeRecorder(10)
+	backend := &Backend{
+		conflictPolicy: ConflictPolicyError,
+		precomputed:    store,
+		lookupEvents:   newLookupEvents(recorder),
+	}
+
+	// Lookups alone don't record served metadata as the response may not be served.
+	if _, err := backend.GetEC2Instance(context.Background(), "10.10.10.10"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := backend.GetHackInstance(context.Background(), "10.10.10.10"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Expected no events; Received: %v", len(recorder.Events))
+	}
+
+	backend.RecordServe(context.Background(), "10.10.10.10")
+	backend.RecordServe(context.Background(), "10.10.10.11")
+	if len(recorder.Events) != 1 {
+		t.Fatalf("Expected 1 event; Received: %v", len(recorder.Events))
+	}
+	expect := "Normal MetadataServed Metadata served to 10.10.10.10 for generation 1"
+	if event := <-recorder.Events; event != expect {
+		t.Fatalf("Expected %q; Received %q", expect, event)
+	}
+}
+
+func TestLookupEventsConflict(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events := newLookupEvents(recorder)
+
+	hw := []tinkv1.Hardware{
+		{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "default"}},
+	}
+
+	events.conflict("10.10.10.10", hw)
+	if len(recorder.Events) != 2 {
+		t.Fatalf("Expected 2 events; Received: %v", len(recorder.Events))
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, "Warning "+lookupConflictReason) || !strings.Contains(event, "default/second") {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+}
+
+func TestLookupEventsProvisioningLookup(t *testing.T) {
+	netboot := func(allowPXE, allowWorkflow bool) *tinkv1.Netboot {
+		return &tinkv1.Netboot{AllowPXE: &allowPXE, AllowWorkflow: &allowWorkflow}
+	}
+
+	cases := []struct {
+		Name       string
+		Interfaces []tinkv1.Interface
+		Expect     string
+	}{
+		{
+			Name:       "NoNetboot",
+			Interfaces: []tinkv1.Interface{{}},
+		},
+		{
+			Name:       "Allowed",
+			Interfaces: []tinkv1.Interface{{Netboot: netboot(true, true)}},
+		},
+		{
+			Name:       "AllowedOnAnyInterface",
+			Interfaces: []tinkv1.Interface{{Netboot: netboot(false, false)}, {Netboot: netboot(true, true)}},
+		},
+		{
+			Name:       "PXENotAllowed",
+			Interfaces: []tinkv1.Interface{{Netboot: netboot(false, true)}},
+			Expect:     "doesn't allow PXE",
+		},
+		{
+			Name:       "NothingAllowed",
+			Interfaces: []tinkv1.Interface{{Netboot: &tinkv1.Netboot{}}},
+			Expect:     "doesn't allow PXE or workflows",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			events := newLookupEvents(recorder)
+
+			hw := &tinkv1.Hardware{
+				ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"},
+				Spec:       tinkv1.HardwareSpec{Interfaces: tc.Interfaces},
+			}
+			events.provisioningLookup(hw, "10.10.10.10")
+
+			if tc.Expect == "" {
+				if len(recorder.Events) != 0 {
+					t.Fatalf("Expected no events; Received: %v", <-recorder.Events)
+				}
+				return
+			}
+
+			if len(recorder.Events) != 1 {
+				t.Fatalf("Expected 1 event; Received: %v", len(recorder.Events))
+			}
+			event := <-recorder.Events
+			if !strings.HasPrefix(event, "Warning "+bootNotAllowedReason) || !strings.HasSuffix(event, tc.Expect) {
+				t.Fatalf("Expected event ending %q; Received %q", tc.Expect, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+var errMultipleClusters = errors.New("hardware found in multiple clusters")
+
+// ClusterConfig configures a cluster served by a MultiClusterBackend.
+type ClusterConfig struct {
+	// Name identifies the cluster in logs, metrics and health checks.
+	Name string
+
+	Config
+}
+
+// MultiClusterConfig configures a MultiClusterBackend.
+type MultiClusterConfig struct {
+	Clusters []ClusterConfig
+
+	// Logger is used to log backend events. Optional.
+	Logger logr.Logger
+
+	// Registerer is used to register backend metrics. Metrics of each cluster are labeled with
+	// the cluster name. If nil, no metrics are registered. Optional.
+	Registerer prometheus.Registerer
+}
+
+type namedBackend struct {
+	name    string
+	backend *Backend
+}
+
+// MultiClusterBackend serves Hardware from multiple clusters. Each cluster has its own cache.
+// Lookups query all clusters concurrently.
+type MultiClusterBackend struct {
+	clusters []namedBackend
+	logger   logr.Logger
+	lookups  *prometheus.CounterVec
+}
+
+// NewMultiClusterBackend creates a MultiClusterBackend. The clusters are not synchronized until
+// Start is called.
+func NewMultiClusterBackend(ctx context.Context, cfg MultiClusterConfig) (*MultiClusterBackend, error) {
+	if len(cfg.Clusters) == 0 {
+		return nil, errors.New("at least one cluster is required")
+	}
+
+	seen := map[string]bool{}
+	for _, c := range cfg.Clusters {
+		if c.Name == "" {
+			return nil, errors.New("clusters require a name")
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate cluster: %v", c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	if cfg.Logger.GetSink() == nil {
+		cfg.Logger = logr.Discard()
+	}
+
+	b := &MultiClusterBackend{
+		logger: cfg.Logger,
+		lookups: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kubernetes_cluster_lookups_total",
+				Help: "Count of multi-cluster Kubernetes backend lookups by lookup type and the cluster that answered",
+			},
+			[]string{"lookup", "cluster"},
+		),
+	}
+
+	if cfg.Registerer != nil {
+		if err := cfg.Registerer.Register(b.lookups); err != nil {
+			return nil, fmt.Errorf("register metrics: %v", err)
+		}
+	}
+
+	for _, c := range cfg.Clusters {
+		clusterCfg := c.Config
+		clusterCfg.Logger = cfg.Logger.WithValues("cluster", c.Name)
+		if cfg.Registerer != nil {
+			clusterCfg.Registerer = prometheus.WrapRegistererWith(prometheus.Labels{"cluster": c.Name}, cfg.Registerer)
+		}
+
+		backend, err := NewBackend(ctx, clusterCfg)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %v: %w", c.Name, err)
+		}
+
+		b.clusters = append(b.clusters, namedBackend{name: c.Name, backend: backend})
+	}
+
+	return b, nil
+}
+
+// noCluster is the cluster label value used when no cluster answered a lookup.
+const noCluster = "none"
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *MultiClusterBackend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return fanOut(ctx, b, "ec2", ip, ec2.ErrInstanceNotFound, (*Backend).GetEC2Instance)
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *MultiClusterBackend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return fanOut(ctx, b, "hack", ip, hack.ErrInstanceNotFound, (*Backend).GetHackInstance)
+}
+
+// GetEC2Userdata satisfies ec2.UserdataClient.
+func (b *MultiClusterBackend) GetEC2Userdata(ctx context.Context, ip string) (string, error) {
+	return fanOut(ctx, b, "userdata", ip, ec2.ErrInstanceNotFound, (*Backend).GetEC2Userdata)
+}
+
+// GetEC2Rescue satisfies ec2.RescueClient.
+func (b *MultiClusterBackend) GetEC2Rescue(ctx context.Context, ip string) (ec2.Rescue, bool, error) {
+	type result struct {
+		rescue ec2.Rescue
+		ok     bool
+	}
+
+	r, err := fanOut(ctx, b, "rescue", ip, ec2.ErrInstanceNotFound,
+		func(c *Backend, ctx context.Context, ip string) (result, error) {
+			rescue, ok, err := c.GetEC2Rescue(ctx, ip)
+			return result{rescue: rescue, ok: ok}, err
+		})
+	return r.rescue, r.ok, err
+}
+
+// InstanceID satisfies phonehome.InstanceIdentifier.
+func (b *MultiClusterBackend) InstanceID(ctx context.Context, ip string) (string, error) {
+	return fanOut(ctx, b, "instance-id", ip, ec2.ErrInstanceNotFound, (*Backend).InstanceID)
+}
+
+// fanOut queries all clusters concurrently. If exactly one cluster has Hardware for ip its
+// result is returned. Hardware found in multiple clusters is an error. Errors from clusters are
+// only returned when no cluster has Hardware for ip so an unreachable cluster doesn't prevent
+// serving Hardware from other clusters.
+func fanOut[T any](
+	ctx context.Context,
+	b *MultiClusterBackend,
+	name, ip string,
+	notFound error,
+	get func(*Backend, context.Context, string) (T, error),
+) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+
+	results := make([]result, len(b.clusters))
+
+	var wg sync.WaitGroup
+	for idx, c := range b.clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := get(c.backend, ctx, ip)
+			results[idx] = result{value: v, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var (
+		found    []string
+		value    T
+		firstErr error
+	)
+	for idx, r := range results {
+		cluster := b.clusters[idx].name
+		switch {
+		case r.err == nil:
+			found = append(found, cluster)
+			value = r.value
+		case errors.Is(r.err, notFound):
+		default:
+			b.logger.Info("Cluster lookup failed", "lookup", name, "ip", ip, "cluster", cluster, "error", r.err.Error())
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%v: %w", cluster, r.err)
+			}
+		}
+	}
+
+	var zero T
+	switch len(found) {
+	case 0:
+		b.lookups.WithLabelValues(name, noCluster).Inc()
+		if firstErr != nil {
+			return zero, firstErr
+		}
+		return zero, notFound
+	case 1:
+		b.lookups.WithLabelValues(name, found[0]).Inc()
+		b.logger.V(1).Info("Lookup answered", "lookup", name, "ip", ip, "cluster", found[0])
+		return value, nil
+	default:
+		sort.Strings(found)
+		b.lookups.WithLabelValues(name, noCluster).Inc()
+		return zero, fmt.Errorf("%w: ip %v: %v", errMultipleClusters, ip, found)
+	}
+}
+
+// RecordAccess satisfies ec2.AccessRecorder. Access is passed to every cluster; only the cluster
+// with Hardware for the address records it.
+func (b *MultiClusterBackend) RecordAccess(ctx context.Context, access ec2.Access) {
+	for _, c := range b.clusters {
+		c.backend.RecordAccess(ctx, access)
+	}
+}
+
+// RecordServe satisfies ec2.ServeRecorder and hack.ServeRecorder. It is passed to every cluster;
+// only the cluster with Hardware for the address records it.
+func (b *MultiClusterBackend) RecordServe(ctx context.Context, ip string) {
+	for _, c := range b.clusters {
+		c.backend.RecordServe(ctx, ip)
+	}
+}
+
+// ForwardEvent satisfies phonehome.Forwarder. The event is passed to every cluster; only the
+// cluster with Hardware for the address records it.
+func (b *MultiClusterBackend) ForwardEvent(ctx context.Context, e phonehome.Event) {
+	for _, c := range b.clusters {
+		c.backend.ForwardEvent(ctx, e)
+	}
+}
+
+// Start satisfies backend.Runner. It synchronizes all clusters and returns when all clusters
+// have returned. When a cluster returns an error, the remaining clusters are stopped and the
+// first error is returned.
+func (b *MultiClusterBackend) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+	)
+
+	for _, c := range b.clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.backend.Start(ctx); err != nil {
+				once.Do(func() { first = fmt.Errorf("%v: %w", c.name, err) })
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return first
+}
+
+// WaitForReady satisfies backend.ReadyWaiter. It waits for any cluster to be ready so an
+// unreachable cluster doesn't prevent serving the others. Clusters that aren't ready are reported
+// by their health checks.
+func (b *MultiClusterBackend) WaitForReady(ctx context.Context) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ready := make(chan bool, len(b.clusters))
+	for _, c := range b.clusters {
+		go func() { ready <- c.backend.WaitForReady(ctx) }()
+	}
+
+	for range b.clusters {
+		if <-ready {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHealthy satisfies healthcheck.Client. The MultiClusterBackend is healthy when any cluster is
+// healthy. Unhealthy clusters are reported by their health checks.
+func (b *MultiClusterBackend) IsHealthy(ctx context.Context) bool {
+	for _, c := range b.clusters {
+		if c.backend.IsHealthy(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthChecks satisfies healthcheck.CheckProvider. Each cluster is reported as a check named
+// after the cluster that fails while the cluster is unsynced or unhealthy. Checks of each cluster
+// are prefixed with the cluster name.
+func (b *MultiClusterBackend) HealthChecks() []healthcheck.Checker {
+	var checks []healthcheck.Checker
+	for _, c := range b.clusters {
+		backend := c.backend
+		checks = append(checks, healthcheck.NewChecker(c.name, func(ctx context.Context) error {
+			if !backend.synced() {
+				return errors.New("not synced")
+			}
+			if !backend.IsHealthy(ctx) {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}))
+
+		for _, check := range backend.HealthChecks() {
+			checks = append(checks, healthcheck.NewChecker(c.name+"/"+check.Name(), check.Check))
+		}
+	}
+	return checks
+}
+
+// DataAge satisfies healthcheck.DataAgeSource. It returns the largest data age of the clusters.
+func (b *MultiClusterBackend) DataAge() time.Duration {
+	var age time.Duration
+	for _, c := range b.clusters {
+		age = max(age, c.backend.DataAge())
+	}
+	return age
+}
@@ -0,0 +1,248 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+)
+
+// errLister fails every list.
+type errLister struct{ err error }
+
+func (c errLister) List(context.Context, crclient.ObjectList, ...crclient.ListOption) error {
+	return c.err
+}
+
+func newMultiClusterTestBackend(t *testing.T, clusters map[string]*Backend) *MultiClusterBackend {
+	t.Helper()
+
+	b := &MultiClusterBackend{
+		logger: logr.Discard(),
+		lookups: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "kubernetes_cluster_lookups_total"},
+			[]string{"lookup", "cluster"},
+		),
+	}
+	for _, name := range []string{"eu", "us"} {
+		if backend, ok := clusters[name]; ok {
+			b.clusters = append(b.clusters, namedBackend{name: name, backend: backend})
+		}
+	}
+	return b
+}
+
+func TestMultiClusterBackendLookups(t *testing.T) {
+	clientErr := errors.New("connection refused")
+
+	euHardware := []tinkv1.Hardware{*newTestHardware("eu-machine", "10.0.0.1")}
+	usHardware := []tinkv1.Hardware{*newTestHardware("us-machine", "10.0.1.1")}
+	bothHardware := []tinkv1.Hardware{
+		*newTestHardware("eu-machine", "10.0.0.1"),
+		*newTestHardware("us-machine", "10.0.0.1"),
+	}
+
+	cases := []struct {
+		Name          string
+		Clusters      func(t *testing.T) map[string]*Backend
+		IP            string
+		ExpectCluster string
+		ExpectErr     error
+	}{
+		{
+			Name: "FoundInOneCluster",
+			Clusters: func(t *testing.T) map[string]*Backend {
+				return map[string]*Backend{
+					"eu": newBenchmarkBackend(t, euHardware, true),
+					"us": newBenchmarkBackend(t, usHardware, true),
+				}
+			},
+			IP:            "10.0.1.1",
+			ExpectCluster: "us",
+		},
+		{
+			Name: "FoundInMultipleClusters",
+			Clusters: func(t *testing.T) map[string]*Backend {
+				return map[string]*Backend{
+					"eu": newBenchmarkBackend(t, bothHardware[:1], true),
+					"us": newBenchmarkBackend(t, bothHardware[1:], true),
+				}
+			},
+			IP:            "10.0.0.1",
+			ExpectCluster: noCluster,
+			ExpectErr:     errMultipleClusters,
+		},
+		{
+			Name: "NotFound",
+			Clusters: func(t *testing.T) map[string]*Backend {
+				return map[string]*Backend{
+					"eu": newBenchmarkBackend(t, euHardware, true),
+					"us": newBenchmarkBackend(t, usHardware, true),
+				}
+			},
+			IP:            "10.0.2.1",
+			ExpectCluster: noCluster,
+			ExpectErr:     ec2.ErrInstanceNotFound,
+		},
+		{
+			Name: "FoundDespiteClusterError",
+			Clusters: func(t *testing.T) map[string]*Backend {
+				return map[string]*Backend{
+					"eu": {conflictPolicy: ConflictPolicyError, client: errLister{clientErr}},
+					"us": newBenchmarkBackend(t, usHardware, true),
+				}
+			},
+			IP:            "10.0.1.1",
+			ExpectCluster: "us",
+		},
+		{
+			Name: "NotFoundWithClusterError",
+			Clusters: func(t *testing.T) map[string]*Backend {
+				return map[string]*Backend{
+					"eu": {conflictPolicy: ConflictPolicyError, client: errLister{clientErr}},
+					"us": newBenchmarkBackend(t, usHardware, true),
+				}
+			},
+			IP:            "10.0.2.1",
+			ExpectCluster: noCluster,
+			ExpectErr:     clientErr,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			backend := newMultiClusterTestBackend(t, tc.Clusters(t))
+
+			instance, err := backend.GetEC2Instance(context.Background(), tc.IP)
+			if tc.ExpectErr != nil {
+				if !errors.Is(err, tc.ExpectErr) {
+					t.Fatalf("Expected %v; Received %v", tc.ExpectErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if expect := tc.ExpectCluster + "-machine"; instance.Metadata.Hostname != expect {
+					t.Fatalf("Expected hostname %v; Received %v", expect, instance.Metadata.Hostname)
+				}
+			}
+
+			count := testutil.ToFloat64(backend.lookups.WithLabelValues("ec2", tc.ExpectCluster))
+			if count != 1 {
+				t.Fatalf("Expected 1 lookup answered by %v; Received %v", tc.ExpectCluster, count)
+			}
+		})
+	}
+}
+
+func TestMultiClusterBackendHackLookup(t *testing.T) {
+	backend := newMultiClusterTestBackend(t, map[string]*Backend{
+		"eu": newBenchmarkBackend(t, []tinkv1.Hardware{*newTestHardware("eu-machine", "10.0.0.1")}, true),
+		"us": newBenchmarkBackend(t, nil, true),
+	})
+
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.2.1"); !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected hack.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+func TestNewMultiClusterBackendErrors(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Clusters []ClusterConfig
+	}{
+		{Name: "NoClusters"},
+		{Name: "MissingName", Clusters: []ClusterConfig{{}}},
+		{Name: "DuplicateName", Clusters: []ClusterConfig{{Name: "eu"}, {Name: "eu"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := NewMultiClusterBackend(context.Background(), MultiClusterConfig{Clusters: tc.Clusters})
+			if err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
+
+// unsyncedInformer never completes its initial sync.
+type unsyncedInformer struct{ cache.Informer }
+
+func (unsyncedInformer) HasSynced() bool { return false }
+
+// unsyncedCache blocks cache sync until the context is cancelled.
+type unsyncedCache struct{ cache.Cache }
+
+func (unsyncedCache) WaitForCacheSync(ctx context.Context) bool {
+	<-ctx.Done()
+	return false
+}
+
+// unsyncedCluster is a cluster whose cache never syncs, such as an unreachable cluster.
+type unsyncedCluster struct{ cluster.Cluster }
+
+func (unsyncedCluster) GetCache() cache.Cache { return unsyncedCache{} }
+
+func TestMultiClusterBackendReadiness(t *testing.T) {
+	synced := &Backend{staleTolerance: time.Hour}
+	synced.snapshot.Store(newSnapshotStore(Snapshot{CreatedAt: time.Now()}, hardwareIPIndexFunc(AllIPSources())))
+	unsynced := &Backend{cluster: unsyncedCluster{}, informer: unsyncedInformer{}}
+
+	backend := newMultiClusterTestBackend(t, map[string]*Backend{"eu": synced, "us": unsynced})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !backend.WaitForReady(ctx) {
+		t.Fatal("Expected ready once any cluster is ready")
+	}
+
+	if !backend.IsHealthy(ctx) {
+		t.Fatal("Expected healthy while any cluster is healthy")
+	}
+
+	results := map[string]error{}
+	for _, c := range backend.HealthChecks() {
+		results[c.Name()] = c.Check(ctx)
+	}
+
+	if err := results["eu"]; err != nil {
+		t.Fatalf("Expected nil error for eu; Received: %v", err)
+	}
+	if err := results["us"]; err == nil {
+		t.Fatal("Expected unsynced us cluster to fail its check")
+	}
+}
+
+func TestMultiClusterBackendNotReady(t *testing.T) {
+	backend := newMultiClusterTestBackend(t, map[string]*Backend{
+		"eu": {cluster: unsyncedCluster{}, informer: unsyncedInformer{}},
+		"us": {cluster: unsyncedCluster{}, informer: unsyncedInformer{}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if backend.WaitForReady(ctx) {
+		t.Fatal("Expected not ready when no cluster is ready")
+	}
+
+	if backend.IsHealthy(ctx) {
+		t.Fatal("Expected unhealthy when no cluster is healthy")
+	}
+}
@@ -0,0 +1,158 @@
+package kubernetes
+
+import (
+	"sync"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// precomputed holds the frontend models for a Hardware so lookups needn't map the Hardware on
+// every request.
+type precomputed struct {
+	hw      *tinkv1.Hardware
+	ec2     ec2.Instance
+	hack    hack.Instance
+	hackErr error
+	ips     []string
+}
+
+// precomputedStore maintains precomputed frontend models for every Hardware in the cache. It
+// satisfies k8s.io/client-go/tools/cache.ResourceEventHandler and is updated as Hardware changes.
+type precomputedStore struct {
+	indexFunc func(crclient.Object) []string
+
+	mtx   sync.RWMutex
+	byKey map[types.NamespacedName]*precomputed
+	byIP  map[string][]*precomputed
+
+	// synced reports whether the store has observed the initial list of Hardware.
+	synced func() bool
+}
+
+func newPrecomputedStore(indexFunc func(crclient.Object) []string) *precomputedStore {
+	return &precomputedStore{
+		indexFunc: indexFunc,
+		byKey:     map[types.NamespacedName]*precomputed{},
+		byIP:      map[string][]*precomputed{},
+		synced:    func() bool { return false },
+	}
+}
+
+// OnAdd satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *precomputedStore) OnAdd(obj interface{}, _ bool) {
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		s.set(hw)
+	}
+}
+
+// OnUpdate satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *precomputedStore) OnUpdate(_, obj interface{}) {
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		s.set(hw)
+	}
+}
+
+// OnDelete satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (s *precomputedStore) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		s.removeLocked(types.NamespacedName{Namespace: hw.Namespace, Name: hw.Name})
+	}
+}
+
+func (s *precomputedStore) set(hw *tinkv1.Hardware) {
+	p := &precomputed{
+		hw:  hw,
+		ec2: toEC2Instance(*hw),
+		ips: s.indexFunc(hw),
+	}
+	p.hack, p.hackErr = toHackInstance(*hw)
+
+	key := types.NamespacedName{Namespace: hw.Namespace, Name: hw.Name}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.removeLocked(key)
+	s.byKey[key] = p
+	for _, ip := range p.ips {
+		s.byIP[ip] = append(s.byIP[ip], p)
+	}
+}
+
+// removeLocked removes the entry for key. s.mtx must be held.
+func (s *precomputedStore) removeLocked(key types.NamespacedName) {
+	existing, ok := s.byKey[key]
+	if !ok {
+		return
+	}
+
+	delete(s.byKey, key)
+	for _, ip := range existing.ips {
+		entries := s.byIP[ip]
+		for i, e := range entries {
+			if e == existing {
+				entries = append(entries[:i:i], entries[i+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(s.byIP, ip)
+		} else {
+			s.byIP[ip] = entries
+		}
+	}
+}
+
+// hardware returns the Hardware claiming the normalized ip.
+func (s *precomputedStore) hardware(ip string) []tinkv1.Hardware {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	hw := make([]tinkv1.Hardware, 0, len(s.byIP[ip]))
+	for _, e := range s.byIP[ip] {
+		hw = append(hw, *e.hw)
+	}
+	return hw
+}
+
+// lookup retrieves the entry for the normalized ip resolving conflicts with policy.
+func (s *precomputedStore) lookup(ip string, policy ConflictPolicy) (*precomputed, error) {
+	s.mtx.RLock()
+	entries := s.byIP[ip]
+	s.mtx.RUnlock()
+
+	switch len(entries) {
+	case 0:
+		return nil, errNotFound
+	case 1:
+		return entries[0], nil
+	}
+
+	hw := make([]tinkv1.Hardware, 0, len(entries))
+	for _, e := range entries {
+		hw = append(hw, *e.hw)
+	}
+
+	chosen, err := policy.resolve(ip, hw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.hw.Namespace == chosen.Namespace && e.hw.Name == chosen.Name {
+			return e, nil
+		}
+	}
+
+	return nil, errNotFound
+}
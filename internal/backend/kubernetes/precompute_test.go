@@ -0,0 +1,247 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPrecomputedStore(t *testing.T) {
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+
+	hw := newTestHardware("machine", "10.10.10.10", withHostname("original"))
+	store.OnAdd(hw, true)
+
+	p, err := store.lookup("10.10.10.10", ConflictPolicyError)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.ec2.Metadata.Hostname != "original" {
+		t.Fatalf("Expected hostname 'original'; Received '%v'", p.ec2.Metadata.Hostname)
+	}
+	if p.hackErr != nil {
+		t.Fatalf("Unexpected hack mapping error: %v", p.hackErr)
+	}
+
+	// Updating the Hardware should replace its models and drop addresses it no longer has.
+	updated := newTestHardware("machine", "10.10.10.11", withHostname("updated"))
+	store.OnUpdate(hw, updated)
+
+	if _, err := store.lookup("10.10.10.10", ConflictPolicyError); !errors.Is(err, errNotFound) {
+		t.Fatalf("Expected errNotFound for replaced IP; Received %v", err)
+	}
+
+	p, err = store.lookup("10.10.10.11", ConflictPolicyError)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.ec2.Metadata.Hostname != "updated" {
+		t.Fatalf("Expected hostname 'updated'; Received '%v'", p.ec2.Metadata.Hostname)
+	}
+
+	store.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/machine", Obj: updated})
+
+	if _, err := store.lookup("10.10.10.11", ConflictPolicyError); !errors.Is(err, errNotFound) {
+		t.Fatalf("Expected errNotFound after delete; Received %v", err)
+	}
+	if len(store.byKey) != 0 || len(store.byIP) != 0 {
+		t.Fatalf("Expected empty store; Received %v keys and %v IPs", len(store.byKey), len(store.byIP))
+	}
+}
+
+func TestPrecomputedStoreConflicts(t *testing.T) {
+	now := time.Now()
+	older := *newTestHardware("older", "10.10.10.10", withCreated(now.Add(-time.Hour)))
+	newer := *newTestHardware("newer", "10.10.10.10", withCreated(now))
+
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+	store.OnAdd(&newer, true)
+	store.OnAdd(&older, true)
+
+	if _, err := store.lookup("10.10.10.10", ConflictPolicyError); !errors.Is(err, errMultipleHardware) {
+		t.Fatalf("Expected errMultipleHardware; Received %v", err)
+	}
+
+	p, err := store.lookup("10.10.10.10", ConflictPolicyOldest)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.hw.Name != "older" {
+		t.Fatalf("Expected 'older'; Received '%v'", p.hw.Name)
+	}
+
+	store.OnDelete(&older)
+
+	p, err = store.lookup("10.10.10.10", ConflictPolicyError)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if p.hw.Name != "newer" {
+		t.Fatalf("Expected 'newer'; Received '%v'", p.hw.Name)
+	}
+}
+
+func TestBackendPrecomputedMatchesMapped(t *testing.T) {
+	hw := newTestHardware("machine", "10.10.10.10", withHostname("hostname"))
+	userdata := "#cloud-config"
+	hw.Spec.UserData = &userdata
+
+	mapped := newBenchmarkBackend(t, []tinkv1.Hardware{*hw}, false)
+	precomputed := newBenchmarkBackend(t, []tinkv1.Hardware{*hw}, true)
+
+	for _, ip := range []string{"10.10.10.10", "::ffff:10.10.10.10", "10.10.10.11"} {
+		t.Run(ip, func(t *testing.T) {
+			expectEC2, expectEC2Err := mapped.GetEC2Instance(context.Background(), ip)
+			ec2Instance, ec2Err := precomputed.GetEC2Instance(context.Background(), ip)
+			if !errors.Is(ec2Err, expectEC2Err) {
+				t.Fatalf("Expected error %v; Received %v", expectEC2Err, ec2Err)
+			}
+			if diff := cmp.Diff(expectEC2, ec2Instance); diff != "" {
+				t.Fatal(diff)
+			}
+
+			expectHack, expectHackErr := mapped.GetHackInstance(context.Background(), ip)
+			hackInstance, hackErr := precomputed.GetHackInstance(context.Background(), ip)
+			if !errors.Is(hackErr, expectHackErr) {
+				t.Fatalf("Expected error %v; Received %v", expectHackErr, hackErr)
+			}
+			if diff := cmp.Diff(expectHack, hackInstance); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+
+	if _, err := precomputed.GetEC2Instance(context.Background(), "10.10.10.11"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+	if _, err := precomputed.GetHackInstance(context.Background(), "10.10.10.11"); !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected hack.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+// benchmarkHardwareCount simulates a large site.
+const benchmarkHardwareCount = 10000
+
+// newBenchmarkBackend creates a Backend serving hw. When precompute is false, lookups list
+// Hardware from an indexer and map the result as the informer cache backed client does.
+func newBenchmarkBackend(tb testing.TB, hw []tinkv1.Hardware, precompute bool) *Backend {
+	tb.Helper()
+
+	indexFunc := hardwareIPIndexFunc(AllIPSources())
+
+	if precompute {
+		store := newPrecomputedStore(indexFunc)
+		for i := range hw {
+			store.OnAdd(&hw[i], true)
+		}
+		store.synced = func() bool { return true }
+
+		return &Backend{conflictPolicy: ConflictPolicyError, precomputed: store}
+	}
+
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		hardwareIPAddrIndex: func(obj interface{}) ([]string, error) {
+			return indexFunc(obj.(crclient.Object)), nil
+		},
+	})
+	for i := range hw {
+		if err := indexer.Add(&hw[i]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return &Backend{conflictPolicy: ConflictPolicyError, client: indexerClient{indexer}}
+}
+
+// indexerClient lists Hardware from an indexer deep copying results in the same way the
+// controller-runtime informer cache does.
+type indexerClient struct {
+	indexer toolscache.Indexer
+}
+
+func (c indexerClient) List(_ context.Context, list crclient.ObjectList, opts ...crclient.ListOption) error {
+	var o crclient.ListOptions
+	o.ApplyOptions(opts)
+
+	requirements := o.FieldSelector.Requirements()
+	if len(requirements) != 1 {
+		return fmt.Errorf("expected 1 field selector requirement; received %v", len(requirements))
+	}
+
+	objs, err := c.indexer.ByIndex(requirements[0].Field, requirements[0].Value)
+	if err != nil {
+		return err
+	}
+
+	hwList := list.(*tinkv1.HardwareList)
+	for _, obj := range objs {
+		hwList.Items = append(hwList.Items, *obj.(*tinkv1.Hardware).DeepCopy())
+	}
+
+	return nil
+}
+
+func newBenchmarkHardware() []tinkv1.Hardware {
+	hw := make([]tinkv1.Hardware, 0, benchmarkHardwareCount)
+	for i := 0; i < benchmarkHardwareCount; i++ {
+		ip := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		userdata := "#cloud-config\nhostname: " + ip
+		h := newTestHardware(fmt.Sprintf("machine-%d", i), ip, withHostname(ip))
+		h.ResourceVersion = ""
+		h.CreationTimestamp = metav1.Time{}
+		h.Spec.UserData = &userdata
+		hw = append(hw, *h)
+	}
+	return hw
+}
+
+func benchmarkLookup(b *testing.B, precompute bool, lookup func(*Backend, string) error) {
+	hw := newBenchmarkHardware()
+	backend := newBenchmarkBackend(b, hw, precompute)
+
+	ips := make([]string, len(hw))
+	for i := range hw {
+		ips[i] = hw[i].Spec.Interfaces[0].DHCP.IP.Address
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := lookup(backend, ips[i%len(ips)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "req/s")
+}
+
+func BenchmarkGetEC2Instance(b *testing.B) {
+	lookup := func(backend *Backend, ip string) error {
+		_, err := backend.GetEC2Instance(context.Background(), ip)
+		return err
+	}
+
+	b.Run("Mapped", func(b *testing.B) { benchmarkLookup(b, false, lookup) })
+	b.Run("Precomputed", func(b *testing.B) { benchmarkLookup(b, true, lookup) })
+}
+
+func BenchmarkGetHackInstance(b *testing.B) {
+	lookup := func(backend *Backend, ip string) error {
+		_, err := backend.GetHackInstance(context.Background(), ip)
+		return err
+	}
+
+	b.Run("Mapped", func(b *testing.B) { benchmarkLookup(b, false, lookup) })
+	b.Run("Precomputed", func(b *testing.B) { benchmarkLookup(b, true, lookup) })
+}
@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationRescueUserdataConfigMap names a ConfigMap, in the Hardware's namespace, containing
+// the payload served while the Hardware is in rescue mode. The userdata is read from the
+// ConfigMap's user-data key and the vendor-data from its optional vendor-data key.
+const AnnotationRescueUserdataConfigMap = "hegel.tinkerbell.org/rescue-userdata-configmap"
+
+// ConfigMap keys containing the rescue payload.
+const (
+	rescueUserdataKey   = "user-data"
+	rescueVendordataKey = "vendor-data"
+)
+
+// rescuePayload resolves the payload served to Hardware in rescue mode. A nil *rescuePayload
+// resolves nothing.
+type rescuePayload struct {
+	// reader retrieves ConfigMaps. Hardware are rarely in rescue mode, and the payload is only
+	// resolved when it's served, so ConfigMaps are retrieved from the API server rather than
+	// cached, which would require watching every ConfigMap.
+	reader crclient.Reader
+
+	// fallback is served to Hardware that don't reference a ConfigMap. If its userdata is
+	// empty, those Hardware are served their own payload.
+	fallback ec2.Rescue
+}
+
+// get retrieves the rescue payload for hw. ok is false if there is no rescue payload for hw.
+func (r *rescuePayload) get(ctx context.Context, hw *tinkv1.Hardware) (rescue ec2.Rescue, ok bool, err error) {
+	if r == nil {
+		return ec2.Rescue{}, false, nil
+	}
+
+	name := hw.Annotations[AnnotationRescueUserdataConfigMap]
+	if name == "" {
+		return r.fallback, r.fallback.Userdata != "", nil
+	}
+
+	// A reference to a missing ConfigMap, or one without userdata, means there's no rescue
+	// payload rather than a reason to serve the fallback; the operator asked for a specific
+	// payload so we don't guess at another.
+	key := types.NamespacedName{Namespace: hw.Namespace, Name: name}
+
+	var cm corev1.ConfigMap
+	if err := r.reader.Get(ctx, key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ec2.Rescue{}, false, nil
+		}
+		return ec2.Rescue{}, false, fmt.Errorf("get configmap %v: %w", key, err)
+	}
+
+	userdata, ok := cm.Data[rescueUserdataKey]
+	if !ok {
+		return ec2.Rescue{}, false, nil
+	}
+
+	return ec2.Rescue{Userdata: userdata, Vendordata: cm.Data[rescueVendordataKey]}, true, nil
+}
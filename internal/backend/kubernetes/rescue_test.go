@@ -0,0 +1,156 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestRescuePayload(t *testing.T) {
+	objs := []client.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "rescue", Namespace: "default"},
+			Data:       map[string]string{rescueUserdataKey: "configmap", rescueVendordataKey: "configmap-vendordata"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "no-userdata", Namespace: "default"},
+			Data:       map[string]string{rescueVendordataKey: "configmap-vendordata"},
+		},
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	failing := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(context.Context, client.WithWatch, client.ObjectKey, client.Object, ...client.GetOption) error {
+			return errors.New("unavailable")
+		},
+	}).Build()
+
+	withAnnotation := func(name string) *tinkv1.Hardware {
+		hw := newTestHardware("machine", "10.10.10.10", withUserdata("original"))
+		if name != "" {
+			hw.Annotations = map[string]string{AnnotationRescueUserdataConfigMap: name}
+		}
+		return hw
+	}
+
+	fallback := ec2.Rescue{Userdata: "fallback", Vendordata: "fallback-vendordata"}
+
+	cases := []struct {
+		Name     string
+		Rescue   *rescuePayload
+		Hardware *tinkv1.Hardware
+		Expect   ec2.Rescue
+		OK       bool
+		Error    bool
+	}{
+		{
+			Name:     "Nil",
+			Hardware: withAnnotation("rescue"),
+		},
+		{
+			Name:     "NoFallback",
+			Rescue:   &rescuePayload{reader: reader},
+			Hardware: withAnnotation(""),
+		},
+		{
+			Name:     "Fallback",
+			Rescue:   &rescuePayload{reader: reader, fallback: fallback},
+			Hardware: withAnnotation(""),
+			Expect:   fallback,
+			OK:       true,
+		},
+		{
+			Name:     "ConfigMap",
+			Rescue:   &rescuePayload{reader: reader, fallback: fallback},
+			Hardware: withAnnotation("rescue"),
+			Expect:   ec2.Rescue{Userdata: "configmap", Vendordata: "configmap-vendordata"},
+			OK:       true,
+		},
+		{
+			Name:     "MissingConfigMap",
+			Rescue:   &rescuePayload{reader: reader, fallback: fallback},
+			Hardware: withAnnotation("missing"),
+		},
+		{
+			Name:     "MissingUserdataKey",
+			Rescue:   &rescuePayload{reader: reader, fallback: fallback},
+			Hardware: withAnnotation("no-userdata"),
+		},
+		{
+			Name:     "Error",
+			Rescue:   &rescuePayload{reader: failing, fallback: fallback},
+			Hardware: withAnnotation("rescue"),
+			Error:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rescue, ok, err := tc.Rescue.get(context.Background(), tc.Hardware)
+			if (err != nil) != tc.Error {
+				t.Fatalf("Expected error: %v; Received %v", tc.Error, err)
+			}
+			if ok != tc.OK {
+				t.Fatalf("Expected ok: %v; Received %v", tc.OK, ok)
+			}
+			if diff := cmp.Diff(tc.Expect, rescue); diff != "" {
+				t.Fatal(diff)
+			}
+		})
+	}
+}
+
+func TestBackendGetEC2Rescue(t *testing.T) {
+	var gets int
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rescue", Namespace: "default"},
+		Data:       map[string]string{rescueUserdataKey: "rescue"},
+	}
+	reader := newCountingClient(&gets, cm)
+
+	hw := newTestHardware("machine", "10.10.10.10", withUserdata("original"))
+	hw.Annotations = map[string]string{AnnotationRescueUserdataConfigMap: "rescue"}
+	hw.Spec.Metadata.Instance.Rescue = true
+
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+	store.OnAdd(hw, true)
+	store.synced = func() bool { return true }
+
+	backend := &Backend{
+		conflictPolicy: ConflictPolicyError,
+		precomputed:    store,
+		rescue:         &rescuePayload{reader: reader},
+	}
+
+	// Instances are retrieved without resolving their rescue payload.
+	instance, err := backend.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !instance.Metadata.Rescue || instance.Userdata != "original" {
+		t.Fatalf("Unexpected instance: %+v", instance)
+	}
+	if gets != 0 {
+		t.Fatalf("Expected no API requests; Received %v", gets)
+	}
+
+	rescue, ok, err := backend.GetEC2Rescue(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ok || rescue.Userdata != "rescue" {
+		t.Fatalf("Unexpected rescue payload: %+v, %v", rescue, ok)
+	}
+
+	if _, _, err := backend.GetEC2Rescue(context.Background(), "10.10.10.11"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package kubernetes
+
+import (
+	"net/http"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// hardwareMapping is the REST mapping of Hardware.
+var hardwareMapping = meta.RESTMapping{
+	Resource:         tinkv1.GroupVersion.WithResource("hardware"),
+	GroupVersionKind: tinkv1.GroupVersion.WithKind("Hardware"),
+	Scope:            meta.RESTScopeNamespace,
+}
+
+// hardwareRESTMapper maps Hardware without consulting the API server and delegates all other
+// kinds to the embedded RESTMapper. Informers and indexes can't be registered for kinds the
+// mapper can't map so, with a mapper that relies on discovery, the Backend couldn't be
+// constructed, and couldn't serve a snapshot, while the API server is unreachable.
+type hardwareRESTMapper struct {
+	meta.RESTMapper
+}
+
+// newHardwareRESTMapper satisfies sigs.k8s.io/controller-runtime/pkg/cluster.Options.MapperProvider.
+func newHardwareRESTMapper(c *rest.Config, httpClient *http.Client) (meta.RESTMapper, error) {
+	mapper, err := apiutil.NewDynamicRESTMapper(c, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return hardwareRESTMapper{RESTMapper: mapper}, nil
+}
+
+// RESTMapping satisfies meta.RESTMapper.
+func (m hardwareRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	if isHardware(gk, versions) {
+		mapping := hardwareMapping
+		return &mapping, nil
+	}
+	return m.RESTMapper.RESTMapping(gk, versions...)
+}
+
+// RESTMappings satisfies meta.RESTMapper.
+func (m hardwareRESTMapper) RESTMappings(gk schema.GroupKind, versions ...string) ([]*meta.RESTMapping, error) {
+	if isHardware(gk, versions) {
+		mapping := hardwareMapping
+		return []*meta.RESTMapping{&mapping}, nil
+	}
+	return m.RESTMapper.RESTMappings(gk, versions...)
+}
+
+// isHardware reports whether gk, in one of versions, is the Hardware kind Hegel uses. An empty
+// versions matches any version.
+func isHardware(gk schema.GroupKind, versions []string) bool {
+	if gk != hardwareMapping.GroupVersionKind.GroupKind() {
+		return false
+	}
+	if len(versions) == 0 {
+		return true
+	}
+	for _, v := range versions {
+		if v == hardwareMapping.GroupVersionKind.Version {
+			return true
+		}
+	}
+	return false
+}
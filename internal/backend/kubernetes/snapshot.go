@@ -0,0 +1,210 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SnapshotVersion is the version of the snapshot format written by the backend. The format is
+// documented in docs/snapshot.md. Readers must reject snapshots with an unknown version.
+const SnapshotVersion = 1
+
+// defaultSnapshotInterval is the default interval at which changes are written to the snapshot.
+const defaultSnapshotInterval = time.Minute
+
+// Snapshot is the on-disk representation of the Hardware served by the backend.
+type Snapshot struct {
+	// Version is the snapshot format version.
+	Version int `json:"version"`
+
+	// CreatedAt is the time the snapshot was taken.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Hardware is the list of Hardware resources known to the backend when the snapshot was taken.
+	Hardware []tinkv1.Hardware `json:"hardware"`
+}
+
+// ReadSnapshot reads a Snapshot from path.
+func ReadSnapshot(path string) (Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Snapshot{}, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	if s.Version != SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version: %v", s.Version)
+	}
+
+	return s, nil
+}
+
+// WriteSnapshot atomically writes s to path by writing to a temporary file in the same
+// directory and renaming it.
+func WriteSnapshot(path string, s Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshotStore serves Hardware from a Snapshot. It satisfies listerClient and supports
+// matching on the hardwareIPAddrIndex field.
+type snapshotStore struct {
+	createdAt time.Time
+	all       []tinkv1.Hardware
+	byIP      map[string][]tinkv1.Hardware
+}
+
+func newSnapshotStore(s Snapshot, indexFunc func(crclient.Object) []string) *snapshotStore {
+	store := &snapshotStore{
+		createdAt: s.CreatedAt,
+		all:       s.Hardware,
+		byIP:      map[string][]tinkv1.Hardware{},
+	}
+
+	for i := range s.Hardware {
+		for _, ip := range indexFunc(&s.Hardware[i]) {
+			store.byIP[ip] = append(store.byIP[ip], s.Hardware[i])
+		}
+	}
+
+	return store
+}
+
+// List satisfies listerClient.
+func (s *snapshotStore) List(_ context.Context, list crclient.ObjectList, opts ...crclient.ListOption) error {
+	hwList, ok := list.(*tinkv1.HardwareList)
+	if !ok {
+		return fmt.Errorf("snapshot: unsupported list type %T", list)
+	}
+
+	var o crclient.ListOptions
+	o.ApplyOptions(opts)
+
+	if o.FieldSelector == nil || o.FieldSelector.Empty() {
+		hwList.Items = append([]tinkv1.Hardware(nil), s.all...)
+		return nil
+	}
+
+	ip, ok := o.FieldSelector.RequiresExactMatch(hardwareIPAddrIndex)
+	if !ok {
+		return fmt.Errorf("snapshot: unsupported field selector: %v", o.FieldSelector)
+	}
+
+	hwList.Items = append([]tinkv1.Hardware(nil), s.byIP[ip]...)
+	return nil
+}
+
+// snapshotWriter writes the Hardware held in the backend cache to a snapshot file when it
+// changes.
+type snapshotWriter struct {
+	path     string
+	interval time.Duration
+	client   listerClient
+	logger   logr.Logger
+
+	// dirty is set when the cache changes and cleared when a snapshot is written.
+	dirty atomic.Bool
+}
+
+// OnAdd satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (w *snapshotWriter) OnAdd(interface{}, bool) { w.dirty.Store(true) }
+
+// OnUpdate satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (w *snapshotWriter) OnUpdate(_, _ interface{}) { w.dirty.Store(true) }
+
+// OnDelete satisfies k8s.io/client-go/tools/cache.ResourceEventHandler.
+func (w *snapshotWriter) OnDelete(interface{}) { w.dirty.Store(true) }
+
+// run writes a snapshot every w.interval if the cache has changed. It should only be called once
+// the cache has synced so a partial view of the cluster is never written.
+func (w *snapshotWriter) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if w.dirty.Swap(false) {
+			if err := w.write(ctx); err != nil {
+				// Retry on the next tick.
+				w.dirty.Store(true)
+				w.logger.Error(err, "Writing snapshot", "path", w.path)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *snapshotWriter) write(ctx context.Context) error {
+	var list tinkv1.HardwareList
+	if err := w.client.List(ctx, &list); err != nil {
+		return err
+	}
+
+	// Managed fields can be a significant portion of the object size and are only used to
+	// determine when the spec changed.
+	for i := range list.Items {
+		list.Items[i].ManagedFields = trimManagedFields(list.Items[i].ManagedFields)
+	}
+
+	return WriteSnapshot(w.path, Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Hardware:  list.Items,
+	})
+}
+
+// loadSnapshot loads the snapshot at path. A missing snapshot isn't an error and results in a
+// nil store.
+func loadSnapshot(path string, indexFunc func(crclient.Object) []string) (*snapshotStore, error) {
+	s, err := ReadSnapshot(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return newSnapshotStore(s, indexFunc), nil
+}
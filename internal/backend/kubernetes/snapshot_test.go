@@ -0,0 +1,199 @@
+package kubernetes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	expect := Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Hardware:  []tinkv1.Hardware{*newTestHardware("foo", "10.10.10.10")},
+	}
+
+	if err := WriteSnapshot(path, expect); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.CreatedAt.Equal(expect.CreatedAt) || len(s.Hardware) != 1 || s.Hardware[0].Name != "foo" {
+		t.Fatalf("Unexpected snapshot: %+v", s)
+	}
+}
+
+func TestReadSnapshotUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadSnapshot(path); err == nil {
+		t.Fatal("Expected error but received nil")
+	}
+}
+
+func TestLoadSnapshotMissing(t *testing.T) {
+	store, err := loadSnapshot(filepath.Join(t.TempDir(), "missing.json"), hardwareIPIndexFunc(AllIPSources()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store != nil {
+		t.Fatal("Expected nil store for missing snapshot")
+	}
+}
+
+func TestRetrieveByIPFromSnapshot(t *testing.T) {
+	store := newSnapshotStore(Snapshot{
+		Version: SnapshotVersion,
+		Hardware: []tinkv1.Hardware{
+			*newTestHardware("foo", "10.10.10.10"),
+			*newTestHardware("bar", "10.10.10.20"),
+		},
+	}, hardwareIPIndexFunc(AllIPSources()))
+
+	// The cache client should never be consulted while a snapshot is loaded.
+	b := &Backend{}
+	b.snapshot.Store(store)
+
+	instance, err := b.GetEC2Instance(context.Background(), "::ffff:10.10.10.20")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.Hostname != "bar" {
+		t.Fatalf("Expected hostname: bar; Received: %v", instance.Metadata.Hostname)
+	}
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.30"); err == nil {
+		t.Fatal("Expected error for unknown IP")
+	}
+}
+
+func TestSnapshotWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	hw := *newTestHardware("foo", "10.10.10.10")
+	hw.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+
+	writer := &snapshotWriter{
+		path:     path,
+		interval: time.Hour,
+		client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(&hw).Build(),
+		logger:   logr.Discard(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing has changed so nothing should be written.
+	writer.run(ctx)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no snapshot; Received: %v", err)
+	}
+
+	writer.OnAdd(&hw, true)
+	writer.run(ctx)
+
+	s, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Hardware) != 1 || s.Hardware[0].Name != "foo" {
+		t.Fatalf("Unexpected snapshot hardware: %+v", s.Hardware)
+	}
+
+	if s.Hardware[0].ManagedFields != nil {
+		t.Fatal("Expected managed fields to be stripped")
+	}
+}
+
+func TestNewBackendServesSnapshotWithUnreachableAPIServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	// The snapshot is older than the default stale tolerance.
+	err := WriteSnapshot(path, Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().Add(-time.Hour),
+		Hardware:  []tinkv1.Hardware{*newTestHardware("foo", "10.10.10.10")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nothing listens on port 1 so every request to the API server is refused.
+	b, err := NewBackend(ctx, Config{
+		ClientConfig:   &rest.Config{Host: "https://127.0.0.1:1", Timeout: time.Second},
+		SnapshotPath:   path,
+		StaleTolerance: 2 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- b.Start(ctx) }()
+
+	if !b.WaitForReady(ctx) {
+		t.Fatal("Expected ready while serving the snapshot")
+	}
+
+	instance, err := b.GetEC2Instance(ctx, "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if instance.Metadata.Hostname != "foo" {
+		t.Fatalf("Expected hostname: foo; Received: %v", instance.Metadata.Hostname)
+	}
+
+	// The snapshot is older than the stale tolerance.
+	if b.IsHealthy(ctx) {
+		t.Fatal("Expected unhealthy while serving a stale snapshot")
+	}
+
+	cancel()
+	<-errs
+}
+
+func TestBackendIsHealthyWithSnapshot(t *testing.T) {
+	cases := []struct {
+		Name           string
+		Age            time.Duration
+		StaleTolerance time.Duration
+		Healthy        bool
+	}{
+		{Name: "WithinTolerance", Age: time.Minute, StaleTolerance: 2 * time.Minute, Healthy: true},
+		{Name: "ExceedsTolerance", Age: time.Hour, StaleTolerance: 2 * time.Minute},
+		{Name: "NoTolerance", Age: time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			b := &Backend{staleTolerance: tc.StaleTolerance}
+			b.snapshot.Store(&snapshotStore{createdAt: time.Now().Add(-tc.Age)})
+
+			if healthy := b.IsHealthy(context.Background()); healthy != tc.Healthy {
+				t.Fatalf("Expected healthy: %v; Received: %v", tc.Healthy, healthy)
+			}
+		})
+	}
+}
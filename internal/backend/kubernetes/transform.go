@@ -0,0 +1,133 @@
+package kubernetes
+
+import (
+	"bytes"
+	"time"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// transformHardware returns a transform that strips Hardware of fields Hegel never reads before
+// they're stored in the cache. Large sites hold tens of thousands of Hardware in memory so
+// fields such as managedFields and the last-applied-configuration annotation, which duplicates
+// the entire object, are a significant portion of Hegel's memory usage.
+//
+// Managed fields are reduced to a single entry recording when the spec last changed, which
+// toEC2Instance reports as the instance's change time.
+//
+// Annotations recording userdata access are retained so the access recorder can observe them, as
+// is the annotation referencing the rescue payload.
+//
+// When stripUserdata is true the Hardware's userdata is stripped too. Userdata is typically the
+// largest field and can be retrieved from the API server when requested.
+//
+// Fields that are read must be kept in sync with toEC2Instance, toHackInstance,
+// hardwareIPIndexFunc and lookupEvents.
+func transformHardware(stripUserdata bool) toolscache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		hw, ok := obj.(*tinkv1.Hardware)
+		if !ok {
+			return obj, nil
+		}
+
+		hw.ManagedFields = trimManagedFields(hw.ManagedFields)
+		hw.Annotations = retainAnnotations(hw.Annotations)
+		hw.Status = tinkv1.HardwareStatus{}
+
+		hw.Spec.BMCRef = nil
+		hw.Spec.Disks = nil
+		hw.Spec.Resources = nil
+
+		if stripUserdata {
+			hw.Spec.UserData = nil
+		}
+
+		// Only the DHCP IP is used, to index Hardware, and the netboot permissions, to detect
+		// Hardware that shouldn't be provisioning.
+		for i, iface := range hw.Spec.Interfaces {
+			stripped := tinkv1.Interface{}
+			if iface.DHCP != nil && iface.DHCP.IP != nil {
+				stripped.DHCP = &tinkv1.DHCP{IP: iface.DHCP.IP}
+			}
+			if iface.Netboot != nil {
+				stripped.Netboot = &tinkv1.Netboot{
+					AllowPXE:      iface.Netboot.AllowPXE,
+					AllowWorkflow: iface.Netboot.AllowWorkflow,
+				}
+			}
+			hw.Spec.Interfaces[i] = stripped
+		}
+
+		if md := hw.Spec.Metadata; md != nil {
+			md.Manufacturer = nil
+			md.Custom = nil
+
+			if md.Instance != nil {
+				md.Instance.Userdata = ""
+				md.Instance.CryptedRootPassword = ""
+				md.Instance.IpxeScriptURL = ""
+			}
+		}
+
+		return hw, nil
+	}
+}
+
+// specFields is the FieldsV1 of entries returned by trimManagedFields. It's shared, and must not
+// be modified.
+var specFields = &metav1.FieldsV1{Raw: []byte(`{"f:spec":{}}`)}
+
+// trimManagedFields returns a single entry recording the time the spec last changed. It returns
+// nil if the time can't be determined.
+func trimManagedFields(fields []metav1.ManagedFieldsEntry) []metav1.ManagedFieldsEntry {
+	changed := specChanged(fields)
+	if changed.IsZero() {
+		return nil
+	}
+	return []metav1.ManagedFieldsEntry{{
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		Time:       &metav1.Time{Time: changed},
+		FieldsType: "FieldsV1",
+		FieldsV1:   specFields,
+	}}
+}
+
+// specChanged returns the latest time a manager changed the spec. The API server updates an
+// entry's time only when the manager changes the fields it owns so, while the time may be later
+// than the last spec change if the manager also owns metadata, it's never earlier. It returns the
+// zero time if no entry owns spec fields.
+func specChanged(fields []metav1.ManagedFieldsEntry) time.Time {
+	var changed time.Time
+	for _, f := range fields {
+		if f.Subresource != "" || f.Time == nil || f.FieldsV1 == nil {
+			continue
+		}
+		if !bytes.Contains(f.FieldsV1.Raw, []byte(`"f:spec"`)) {
+			continue
+		}
+		if f.Time.After(changed) {
+			changed = f.Time.Time
+		}
+	}
+	return changed
+}
+
+// retainedAnnotations are the annotations retained by transformHardware.
+var retainedAnnotations = append([]string{AnnotationRescueUserdataConfigMap}, accessAnnotations...)
+
+// retainAnnotations returns the annotations in retainedAnnotations. It returns nil if there are
+// none.
+func retainAnnotations(annotations map[string]string) map[string]string {
+	var retained map[string]string
+	for _, key := range retainedAnnotations {
+		if v, ok := annotations[key]; ok {
+			if retained == nil {
+				retained = map[string]string{}
+			}
+			retained[key] = v
+		}
+	}
+	return retained
+}
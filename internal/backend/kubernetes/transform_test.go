@@ -0,0 +1,254 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// specChangedTime is the latest time a manager changed the spec of newTransformTestHardware.
+var specChangedTime = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func newTransformTestHardware() *tinkv1.Hardware {
+	userdata := "#cloud-config"
+	vendordata := "vendordata"
+	allowPXE := true
+
+	return &tinkv1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "machine",
+			Namespace:   "default",
+			Labels:      map[string]string{"site": "dc1"},
+			Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"},
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  "kubectl",
+					Time:     &metav1.Time{Time: specChangedTime.Add(-time.Hour)},
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:userData":{}}}`)},
+				},
+				{
+					Manager:  "controller",
+					Time:     &metav1.Time{Time: specChangedTime},
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:metadata":{}}}`)},
+				},
+				{
+					Manager:     "controller",
+					Time:        &metav1.Time{Time: specChangedTime.Add(time.Hour)},
+					FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:state":{}}}`)},
+					Subresource: "status",
+				},
+				{
+					Manager:  "labeler",
+					Time:     &metav1.Time{Time: specChangedTime.Add(2 * time.Hour)},
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{}}}`)},
+				},
+				{Manager: "unknown"},
+			},
+			ResourceVersion: "1",
+		},
+		Spec: tinkv1.HardwareSpec{
+			BMCRef: &corev1.TypedLocalObjectReference{Kind: "Machine", Name: "bmc"},
+			Interfaces: []tinkv1.Interface{
+				{
+					Netboot: &tinkv1.Netboot{AllowPXE: &allowPXE, IPXE: &tinkv1.IPXE{Contents: "#!ipxe"}},
+					DHCP: &tinkv1.DHCP{
+						MAC:      "00:00:00:00:00:01",
+						Hostname: "machine",
+						IP:       &tinkv1.IP{Address: "10.10.10.10", Family: 4},
+					},
+				},
+				{Netboot: &tinkv1.Netboot{}},
+			},
+			Metadata: &tinkv1.HardwareMetadata{
+				State:        "provisioning",
+				Manufacturer: &tinkv1.MetadataManufacturer{Slug: "manufacturer"},
+				Custom:       &tinkv1.MetadataCustom{},
+				Facility:     &tinkv1.MetadataFacility{PlanSlug: "plan", FacilityCode: "facility"},
+				Instance: &tinkv1.MetadataInstance{
+					ID:                  "id",
+					Hostname:            "machine",
+					Userdata:            "userdata",
+					CryptedRootPassword: "password",
+					IpxeScriptURL:       "http://example.com",
+					Tags:                []string{"tag"},
+					Ips:                 []*tinkv1.MetadataInstanceIP{{Address: "10.10.10.10", Family: 4}},
+					Storage:             &tinkv1.MetadataInstanceStorage{},
+				},
+			},
+			Disks:      []tinkv1.Disk{{Device: "/dev/sda"}},
+			Resources:  map[string]resource.Quantity{"cpu": resource.MustParse("1")},
+			UserData:   &userdata,
+			VendorData: &vendordata,
+		},
+		Status: tinkv1.HardwareStatus{State: tinkv1.HardwareReady},
+	}
+}
+
+func TestTransformHardware(t *testing.T) {
+	userdata := "#cloud-config"
+	vendordata := "vendordata"
+	allowPXE := true
+
+	expect := &tinkv1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "machine",
+			Namespace:       "default",
+			Labels:          map[string]string{"site": "dc1"},
+			ResourceVersion: "1",
+			ManagedFields: []metav1.ManagedFieldsEntry{{
+				Operation:  metav1.ManagedFieldsOperationUpdate,
+				Time:       &metav1.Time{Time: specChangedTime},
+				FieldsType: "FieldsV1",
+				FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{}}`)},
+			}},
+		},
+		Spec: tinkv1.HardwareSpec{
+			Interfaces: []tinkv1.Interface{
+				{
+					Netboot: &tinkv1.Netboot{AllowPXE: &allowPXE},
+					DHCP:    &tinkv1.DHCP{IP: &tinkv1.IP{Address: "10.10.10.10", Family: 4}},
+				},
+				{Netboot: &tinkv1.Netboot{}},
+			},
+			Metadata: &tinkv1.HardwareMetadata{
+				State:    "provisioning",
+				Facility: &tinkv1.MetadataFacility{PlanSlug: "plan", FacilityCode: "facility"},
+				Instance: &tinkv1.MetadataInstance{
+					ID:       "id",
+					Hostname: "machine",
+					Tags:     []string{"tag"},
+					Ips:      []*tinkv1.MetadataInstanceIP{{Address: "10.10.10.10", Family: 4}},
+					Storage:  &tinkv1.MetadataInstanceStorage{},
+				},
+			},
+			UserData:   &userdata,
+			VendorData: &vendordata,
+		},
+	}
+
+	cases := []struct {
+		Name          string
+		StripUserdata bool
+		Expect        func() *tinkv1.Hardware
+	}{
+		{
+			Name:   "KeepUserdata",
+			Expect: func() *tinkv1.Hardware { return expect.DeepCopy() },
+		},
+		{
+			Name:          "StripUserdata",
+			StripUserdata: true,
+			Expect: func() *tinkv1.Hardware {
+				hw := expect.DeepCopy()
+				hw.Spec.UserData = nil
+				return hw
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			hw := newTransformTestHardware()
+
+			// Mapping the stripped Hardware must produce the same responses as the original.
+			expectEC2 := toEC2Instance(*hw)
+			if !expectEC2.Changed.Equal(specChangedTime) {
+				t.Fatalf("Expected changed time %v; Received %v", specChangedTime, expectEC2.Changed)
+			}
+			expectHack, err := toHackInstance(*hw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectIPs := hardwareIPIndexFunc(AllIPSources())(hw)
+
+			obj, err := transformHardware(tc.StripUserdata)(hw)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			transformed, ok := obj.(*tinkv1.Hardware)
+			if !ok {
+				t.Fatalf("Expected *tinkv1.Hardware; Received %T", obj)
+			}
+
+			if diff := cmp.Diff(tc.Expect(), transformed); diff != "" {
+				t.Fatal(diff)
+			}
+
+			if tc.StripUserdata {
+				expectEC2.Userdata = ""
+			}
+			if diff := cmp.Diff(expectEC2, toEC2Instance(*transformed)); diff != "" {
+				t.Fatalf("ec2 instance changed: %v", diff)
+			}
+
+			hackInstance, err := toHackInstance(*transformed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expectHack, hackInstance); diff != "" {
+				t.Fatalf("hack instance changed: %v", diff)
+			}
+
+			if diff := cmp.Diff(expectIPs, hardwareIPIndexFunc(AllIPSources())(transformed)); diff != "" {
+				t.Fatalf("indexed IPs changed: %v", diff)
+			}
+		})
+	}
+}
+
+func TestTransformHardwareWithoutSpecChanges(t *testing.T) {
+	hw := newTransformTestHardware()
+	hw.ManagedFields = hw.ManagedFields[2:]
+
+	obj, err := transformHardware(false)(hw)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	transformed := obj.(*tinkv1.Hardware)
+	if transformed.ManagedFields != nil {
+		t.Fatalf("Expected no managed fields; Received %+v", transformed.ManagedFields)
+	}
+	if changed := toEC2Instance(*transformed).Changed; !changed.IsZero() {
+		t.Fatalf("Expected zero changed time; Received %v", changed)
+	}
+}
+
+func TestTransformHardwareIgnoresOtherObjects(t *testing.T) {
+	tombstone := toolscache.DeletedFinalStateUnknown{Key: "default/machine"}
+
+	obj, err := transformHardware(true)(tombstone)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(tombstone, obj); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestTransformHardwareRetainsAccessAnnotations(t *testing.T) {
+	hw := newTransformTestHardware()
+	hw.Annotations[AnnotationFirstUserdataFetch] = "2024-01-01T00:00:00Z"
+	hw.Annotations[AnnotationLastUserdataFetchIP] = "10.10.10.10"
+
+	obj, err := transformHardware(false)(hw)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expect := map[string]string{
+		AnnotationFirstUserdataFetch:  "2024-01-01T00:00:00Z",
+		AnnotationLastUserdataFetchIP: "10.10.10.10",
+	}
+	if diff := cmp.Diff(expect, obj.(*tinkv1.Hardware).Annotations); diff != "" {
+		t.Fatal(diff)
+	}
+}
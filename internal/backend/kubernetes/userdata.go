@@ -0,0 +1,107 @@
+package kubernetes
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultUserdataCacheSize is the default number of Hardware userdata retained by a
+// userdataFetcher.
+const defaultUserdataCacheSize = 1000
+
+// userdataFetcher retrieves userdata for Hardware cached without it. Recently retrieved userdata
+// is retained in a bounded least-recently-used cache keyed by the Hardware's resource version so
+// machines requesting their metadata repeatedly don't each result in an API server request.
+type userdataFetcher struct {
+	reader crclient.Reader
+	size   int
+
+	mtx     sync.Mutex
+	lru     *list.List
+	entries map[types.NamespacedName]*list.Element
+}
+
+type userdataEntry struct {
+	key             types.NamespacedName
+	resourceVersion string
+	userdata        *string
+}
+
+func newUserdataFetcher(reader crclient.Reader, size int) *userdataFetcher {
+	if size <= 0 {
+		size = defaultUserdataCacheSize
+	}
+
+	return &userdataFetcher{
+		reader:  reader,
+		size:    size,
+		lru:     list.New(),
+		entries: map[types.NamespacedName]*list.Element{},
+	}
+}
+
+// fetch retrieves the userdata for hw. hw is used to identify the Hardware and its version; its
+// userdata is ignored.
+func (f *userdataFetcher) fetch(ctx context.Context, hw *tinkv1.Hardware) (*string, error) {
+	key := types.NamespacedName{Namespace: hw.Namespace, Name: hw.Name}
+
+	if userdata, ok := f.get(key, hw.ResourceVersion); ok {
+		return userdata, nil
+	}
+
+	var full tinkv1.Hardware
+	if err := f.reader.Get(ctx, key, &full); err != nil {
+		return nil, err
+	}
+
+	f.add(userdataEntry{
+		key:             key,
+		resourceVersion: full.ResourceVersion,
+		userdata:        full.Spec.UserData,
+	})
+
+	return full.Spec.UserData, nil
+}
+
+func (f *userdataFetcher) get(key types.NamespacedName, resourceVersion string) (*string, bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	elem, ok := f.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(userdataEntry) //nolint:forcetypeassert // Only userdataEntry are stored.
+	if entry.resourceVersion != resourceVersion {
+		return nil, false
+	}
+
+	f.lru.MoveToFront(elem)
+
+	return entry.userdata, true
+}
+
+func (f *userdataFetcher) add(entry userdataEntry) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if elem, ok := f.entries[entry.key]; ok {
+		elem.Value = entry
+		f.lru.MoveToFront(elem)
+		return
+	}
+
+	f.entries[entry.key] = f.lru.PushFront(entry)
+
+	for f.lru.Len() > f.size {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.entries, oldest.Value.(userdataEntry).key) //nolint:forcetypeassert // Only userdataEntry are stored.
+	}
+}
@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func newCountingClient(gets *int, objs ...client.Object) client.WithWatch {
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				*gets++
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+}
+
+func TestUserdataFetcher(t *testing.T) {
+	var gets int
+	hw := newTestHardware("machine", "10.10.10.10", withUserdata("original"))
+	c := newCountingClient(&gets, hw)
+
+	// Retrieve the Hardware so we have the resource version assigned by the fake client, as the
+	// cache would.
+	var cached tinkv1.Hardware
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(hw), &cached); err != nil {
+		t.Fatal(err)
+	}
+	cached.Spec.UserData = nil
+	gets = 0
+
+	fetcher := newUserdataFetcher(c, 0)
+
+	for i := 0; i < 3; i++ {
+		userdata, err := fetcher.fetch(context.Background(), &cached)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if userdata == nil || *userdata != "original" {
+			t.Fatalf("Expected 'original'; Received %v", userdata)
+		}
+	}
+
+	if gets != 1 {
+		t.Fatalf("Expected 1 API request; Received %v", gets)
+	}
+
+	// Updating the Hardware changes its resource version which should cause a new request.
+	var latest tinkv1.Hardware
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(hw), &latest); err != nil {
+		t.Fatal(err)
+	}
+	updatedUserdata := "updated"
+	latest.Spec.UserData = &updatedUserdata
+	if err := c.Update(context.Background(), &latest); err != nil {
+		t.Fatal(err)
+	}
+	gets = 0
+
+	latest.Spec.UserData = nil
+	userdata, err := fetcher.fetch(context.Background(), &latest)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if userdata == nil || *userdata != "updated" {
+		t.Fatalf("Expected 'updated'; Received %v", userdata)
+	}
+	if gets != 1 {
+		t.Fatalf("Expected 1 API request; Received %v", gets)
+	}
+}
+
+func TestUserdataFetcherEviction(t *testing.T) {
+	var gets int
+	first := newTestHardware("first", "10.10.10.10", withUserdata("first"))
+	second := newTestHardware("second", "10.10.10.20", withUserdata("second"))
+	c := newCountingClient(&gets, first, second)
+
+	fetcher := newUserdataFetcher(c, 1)
+
+	for _, hw := range []*tinkv1.Hardware{first, second, first} {
+		if _, err := fetcher.fetch(context.Background(), hw); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if fetcher.lru.Len() != 1 || len(fetcher.entries) != 1 {
+		t.Fatalf("Expected 1 entry; Received %v", fetcher.lru.Len())
+	}
+
+	if gets != 3 {
+		t.Fatalf("Expected 3 API requests; Received %v", gets)
+	}
+}
+
+func TestBackendLazyUserdata(t *testing.T) {
+	var gets int
+	hw := newTestHardware("machine", "10.10.10.10", withUserdata("#cloud-config"))
+	c := newCountingClient(&gets, hw)
+
+	var cached tinkv1.Hardware
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(hw), &cached); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformHardware(true)(&cached); err != nil {
+		t.Fatal(err)
+	}
+	gets = 0
+
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+	store.OnAdd(&cached, true)
+	store.synced = func() bool { return true }
+
+	backend := &Backend{
+		conflictPolicy: ConflictPolicyError,
+		precomputed:    store,
+		userdata:       newUserdataFetcher(c, 0),
+	}
+
+	// Metadata is served from the cache without retrieving userdata so it's available while the
+	// API server is unreachable.
+	instance, err := backend.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if instance.Userdata != "" {
+		t.Fatalf("Expected no userdata; Received '%v'", instance.Userdata)
+	}
+	if gets != 0 {
+		t.Fatalf("Expected no API requests; Received %v", gets)
+	}
+
+	userdata, err := backend.GetEC2Userdata(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if userdata != "#cloud-config" {
+		t.Fatalf("Expected '#cloud-config'; Received '%v'", userdata)
+	}
+	if gets != 1 {
+		t.Fatalf("Expected 1 API request; Received %v", gets)
+	}
+
+	if err := c.Delete(context.Background(), hw); err != nil {
+		t.Fatal(err)
+	}
+	backend.userdata = newUserdataFetcher(c, 0)
+
+	// Hardware deleted since it was cached is reported as not found.
+	_, err = backend.GetEC2Userdata(context.Background(), "10.10.10.10")
+	if !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+func TestBackendInstanceIDSkipsUserdata(t *testing.T) {
+	var gets int
+	hw := newTestHardware("machine", "10.10.10.10", withUserdata("#cloud-config"))
+	hw.Spec.Metadata.Instance.ID = "instance-1"
+	c := newCountingClient(&gets, hw)
+
+	var cached tinkv1.Hardware
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(hw), &cached); err != nil {
+		t.Fatal(err)
+	}
+	gets = 0
+
+	store := newPrecomputedStore(hardwareIPIndexFunc(AllIPSources()))
+	store.OnAdd(&cached, true)
+	store.synced = func() bool { return true }
+
+	backend := &Backend{
+		conflictPolicy: ConflictPolicyError,
+		precomputed:    store,
+		userdata:       newUserdataFetcher(c, 0),
+	}
+
+	id, err := backend.InstanceID(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id != "instance-1" {
+		t.Fatalf("Expected 'instance-1'; Received '%v'", id)
+	}
+	if gets != 0 {
+		t.Fatalf("Expected no API requests; Received %v", gets)
+	}
+
+	if _, err := backend.InstanceID(context.Background(), "10.10.10.11"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+}
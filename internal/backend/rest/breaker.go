@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker. It opens after threshold consecutive failures and rejects
+// requests until cooldown has elapsed. Once cooldown has elapsed a single trial request is
+// permitted; if it succeeds the breaker closes, otherwise it reopens.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mtx      sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+
+	// now is used to retrieve the current time. It exists for testing.
+	now func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may proceed. Callers that are allowed must report the outcome
+// using success or failure.
+func (b *breaker) allow() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if b.trial || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.trial = true
+
+	return true
+}
+
+func (b *breaker) success() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *breaker) failure() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.failures++
+	b.trial = false
+
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// abort reports a request was abandoned without an outcome.
+func (b *breaker) abort() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.trial = false
+}
+
+// open reports whether the breaker is rejecting requests because it is cooling down. Once the
+// cooldown has elapsed the breaker is half-open, waiting for a trial request, and isn't reported
+// as open; otherwise callers that stop sending requests while the breaker is open, such as load
+// balancers acting on readiness, would prevent the trial that closes it.
+func (b *breaker) open() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.failures >= b.threshold && b.now().Sub(b.openedAt) < b.cooldown
+}
@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+// Instance is the JSON document returned by the inventory service for an IP. See
+// docs/rest-backend.md for the schema.
+type Instance struct {
+	ID            string   `json:"id"`
+	Hostname      string   `json:"hostname"`
+	LocalHostname string   `json:"localHostname"`
+	IQN           string   `json:"iqn"`
+	Plan          string   `json:"plan"`
+	Facility      string   `json:"facility"`
+	Tags          []string `json:"tags"`
+	PublicKeys    []string `json:"publicKeys"`
+	Userdata      string   `json:"userdata"`
+
+	IPv4 struct {
+		Local  string `json:"local"`
+		Public string `json:"public"`
+	} `json:"ipv4"`
+	IPv6 struct {
+		Public string `json:"public"`
+	} `json:"ipv6"`
+
+	OperatingSystem struct {
+		Slug                   string `json:"slug"`
+		Distro                 string `json:"distro"`
+		Version                string `json:"version"`
+		ImageTag               string `json:"imageTag"`
+		LicenseActivationState string `json:"licenseActivationState"`
+	} `json:"operatingSystem"`
+
+	Interfaces []Interface `json:"interfaces"`
+	Storage    Storage     `json:"storage"`
+}
+
+// Interface is a network interface of an Instance.
+type Interface struct {
+	MAC     string `json:"mac"`
+	Address string `json:"address"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+	Family  int    `json:"family"`
+	Public  bool   `json:"public"`
+}
+
+// Storage describes the disks and filesystems of an Instance. It is served by the hack
+// frontend.
+type Storage struct {
+	Disks []struct {
+		Device     string `json:"device"`
+		WipeTable  bool   `json:"wipeTable"`
+		Partitions []struct {
+			Label  string `json:"label"`
+			Number int    `json:"number"`
+			Size   uint64 `json:"size"`
+		} `json:"partitions"`
+	} `json:"disks"`
+	Filesystems []struct {
+		Mount struct {
+			Device        string   `json:"device"`
+			Format        string   `json:"format"`
+			Point         string   `json:"point"`
+			CreateOptions []string `json:"createOptions"`
+		} `json:"mount"`
+	} `json:"filesystems"`
+}
+
+func toEC2Instance(i Instance) ec2.Instance {
+	var e ec2.Instance
+
+	e.Userdata = i.Userdata
+	e.Metadata.InstanceID = i.ID
+	e.Metadata.Hostname = i.Hostname
+	e.Metadata.LocalHostname = i.LocalHostname
+	e.Metadata.IQN = i.IQN
+	e.Metadata.Plan = i.Plan
+	e.Metadata.Facility = i.Facility
+	e.Metadata.Tags = i.Tags
+	e.Metadata.PublicKeys = i.PublicKeys
+	e.Metadata.PublicIPv4 = i.IPv4.Public
+	e.Metadata.LocalIPv4 = i.IPv4.Local
+	e.Metadata.PublicIPv6 = i.IPv6.Public
+	e.Metadata.OperatingSystem.Slug = i.OperatingSystem.Slug
+	e.Metadata.OperatingSystem.Distro = i.OperatingSystem.Distro
+	e.Metadata.OperatingSystem.Version = i.OperatingSystem.Version
+	e.Metadata.OperatingSystem.ImageTag = i.OperatingSystem.ImageTag
+	e.Metadata.OperatingSystem.LicenseActivation.State = i.OperatingSystem.LicenseActivationState
+
+	// Addresses that aren't explicitly specified are taken from the first interface of the
+	// matching family and visibility.
+	for _, iface := range i.Interfaces {
+		if iface.Family == 4 && iface.Public && e.Metadata.PublicIPv4 == "" {
+			e.Metadata.PublicIPv4 = iface.Address
+		}
+
+		if iface.Family == 4 && !iface.Public && e.Metadata.LocalIPv4 == "" {
+			e.Metadata.LocalIPv4 = iface.Address
+		}
+
+		if iface.Family == 6 && e.Metadata.PublicIPv6 == "" {
+			e.Metadata.PublicIPv6 = iface.Address
+		}
+	}
+
+	return e
+}
+
+func toHackInstance(i Instance) hack.Instance {
+	var h hack.Instance
+
+	storage := &h.Metadata.Instance.Storage
+	for _, d := range i.Storage.Disks {
+		disk := hack.Disk{Device: d.Device, WipeTable: d.WipeTable}
+		for _, p := range d.Partitions {
+			disk.Partitions = append(disk.Partitions, hack.Partition{
+				Label:  p.Label,
+				Number: p.Number,
+				Size:   p.Size,
+			})
+		}
+		storage.Disks = append(storage.Disks, disk)
+	}
+
+	for _, f := range i.Storage.Filesystems {
+		var fs hack.Filesystem
+		fs.Mount.Device = f.Mount.Device
+		fs.Mount.Format = f.Mount.Format
+		fs.Mount.Point = f.Mount.Point
+		fs.Mount.Create.Options = f.Mount.CreateOptions
+		storage.Filesystems = append(storage.Filesystems, fs)
+	}
+
+	return h
+}
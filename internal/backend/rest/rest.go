@@ -0,0 +1,370 @@
+/*
+Package rest provides a backend that retrieves instances from an external inventory service over
+HTTP. The service is queried with GET {base}/instances?ip={ip} for every lookup and must respond
+with an Instance document, or 404 if no instance exists for the IP.
+*/
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+// ErrCircuitOpen indicates a lookup was rejected because the inventory service has failed
+// repeatedly.
+var ErrCircuitOpen = errors.New("inventory service circuit breaker open")
+
+var errNotFound = errors.New("no instance found")
+
+// Defaults applied to Config.
+const (
+	defaultTimeout          = 5 * time.Second
+	defaultRetries          = 2
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// maxResponseSize is the maximum size of a response body read from the inventory service.
+const maxResponseSize = 4 << 20
+
+// Config configures a Backend.
+type Config struct {
+	// BaseURL is the base URL of the inventory service.
+	BaseURL string
+
+	// Headers are added to every request, for example to authenticate with the inventory
+	// service. Optional.
+	Headers http.Header
+
+	// BearerTokenFile is a path to a file containing a token sent in the Authorization header.
+	// The file is read when the Backend is created. Optional.
+	BearerTokenFile string
+
+	// CAFile is a path to a PEM encoded CA bundle used to verify the inventory service. If
+	// empty, the system roots are used. Optional.
+	CAFile string
+
+	// CertFile and KeyFile are paths to a PEM encoded client certificate and key used to
+	// authenticate with the inventory service using mutual TLS. Optional.
+	CertFile string
+	KeyFile  string
+
+	// Timeout is the maximum duration of each request attempt. Defaults to 5s. Optional.
+	Timeout time.Duration
+
+	// Retries is the number of times a failed request is retried. Requests are retried when the
+	// inventory service can't be reached or responds with 429 or a 5xx status. If nil, defaults
+	// to 2. 0 or a negative value disables retries. Optional.
+	Retries *int
+
+	// RetryBackoff is the delay before the first retry. The delay doubles for each subsequent
+	// retry. Defaults to 100ms. Optional.
+	RetryBackoff time.Duration
+
+	// BreakerThreshold is the number of consecutive failed lookups after which lookups are
+	// rejected with ErrCircuitOpen. Defaults to 5. Optional.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long lookups are rejected once the circuit breaker opens before a
+	// trial lookup is permitted. Defaults to 30s. Optional.
+	BreakerCooldown time.Duration
+
+	// Registerer is used to register backend metrics. Optional.
+	Registerer prometheus.Registerer
+
+	// HTTPClient is used to make requests. If specified, the TLS configuration is ignored.
+	// Optional.
+	HTTPClient *http.Client
+}
+
+// Backend retrieves instances from an inventory service.
+type Backend struct {
+	endpoint     *url.URL
+	headers      http.Header
+	client       *http.Client
+	timeout      time.Duration
+	retries      int
+	retryBackoff time.Duration
+	breaker      *breaker
+
+	// certificate is the client certificate used for mutual TLS. It is nil if none is used.
+	certificate *x509.Certificate
+}
+
+// NewBackend creates a Backend.
+func NewBackend(cfg Config) (*Backend, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %v", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("base url must use http or https: %v", cfg.BaseURL)
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	retries := defaultRetries
+	if cfg.Retries != nil {
+		retries = max(*cfg.Retries, 0)
+	}
+
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = defaultBreakerThreshold
+	}
+
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = defaultBreakerCooldown
+	}
+
+	headers := cfg.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+
+	if cfg.BearerTokenFile != "" {
+		token, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read bearer token: %v", err)
+		}
+		headers.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	var certificate *x509.Certificate
+	client := cfg.HTTPClient
+	if client == nil {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(tlsConfig.Certificates) > 0 {
+			certificate, err = healthcheck.Leaf(tlsConfig.Certificates[0])
+			if err != nil {
+				return nil, fmt.Errorf("parse client certificate: %v", err)
+			}
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // DefaultTransport is always a *http.Transport.
+		transport.TLSClientConfig = tlsConfig
+		client = &http.Client{Transport: transport}
+	}
+
+	b := &Backend{
+		endpoint:     base.JoinPath("instances"),
+		headers:      headers,
+		client:       client,
+		timeout:      cfg.Timeout,
+		retries:      retries,
+		retryBackoff: cfg.RetryBackoff,
+		breaker:      newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		certificate:  certificate,
+	}
+
+	if cfg.Registerer != nil {
+		open := prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "rest_backend_circuit_breaker_open",
+				Help: "1 when the REST backend circuit breaker is rejecting lookups, else 0",
+			},
+			func() float64 {
+				if b.breaker.open() {
+					return 1
+				}
+				return 0
+			},
+		)
+		if err := cfg.Registerer.Register(open); err != nil {
+			return nil, fmt.Errorf("register metrics: %v", err)
+		}
+	}
+
+	return b, nil
+}
+
+func (c Config) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file: %v", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	i, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return toEC2Instance(i), nil
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	i, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return hack.Instance{}, hack.ErrInstanceNotFound
+		}
+
+		return hack.Instance{}, err
+	}
+
+	return toHackInstance(i), nil
+}
+
+// IsHealthy satisfies healthcheck.Client. The Backend is unhealthy while its circuit breaker is
+// open and becomes healthy again once the breaker's cooldown has elapsed so it receives the
+// trial request that closes the breaker.
+func (b *Backend) IsHealthy(context.Context) bool {
+	return !b.breaker.open()
+}
+
+// HealthChecks satisfies healthcheck.CheckProvider.
+func (b *Backend) HealthChecks() []healthcheck.Checker {
+	if b.certificate == nil {
+		return nil
+	}
+	return []healthcheck.Checker{healthcheck.NewCertificateChecker("rest-client-certificate", b.certificate)}
+}
+
+func (b *Backend) retrieveByIP(ctx context.Context, ip string) (Instance, error) {
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return Instance{}, errNotFound
+	}
+
+	if !b.breaker.allow() {
+		return Instance{}, ErrCircuitOpen
+	}
+
+	i, err := b.retrieveWithRetries(ctx, normalized)
+
+	// Not found responses don't indicate the inventory service is failing. Cancelled lookups
+	// tell us nothing about the inventory service.
+	switch {
+	case err == nil, errors.Is(err, errNotFound):
+		b.breaker.success()
+	case ctx.Err() != nil:
+		b.breaker.abort()
+	default:
+		b.breaker.failure()
+	}
+
+	return i, err
+}
+
+func (b *Backend) retrieveWithRetries(ctx context.Context, ip string) (Instance, error) {
+	backoff := b.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		i, err := b.retrieve(ctx, ip)
+
+		var re retryableError
+		if err == nil || !errors.As(err, &re) || attempt >= b.retries {
+			return i, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return Instance{}, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryableError indicates a request may succeed if retried.
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+func (b *Backend) retrieve(ctx context.Context, ip string) (Instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	endpoint := *b.endpoint
+	endpoint.RawQuery = url.Values{"ip": {ip}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	for k, v := range b.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return Instance{}, retryableError{fmt.Errorf("inventory request: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotFound:
+		return Instance{}, errNotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return Instance{}, retryableError{fmt.Errorf("inventory response: %v", resp.Status)}
+	default:
+		return Instance{}, fmt.Errorf("inventory response: %v", resp.Status)
+	}
+
+	var i Instance
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&i); err != nil {
+		return Instance{}, fmt.Errorf("decode inventory response: %v", err)
+	}
+
+	return i, nil
+}
@@ -0,0 +1,551 @@
+package rest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+const instanceJSON = `{
+	"id": "machine",
+	"hostname": "machine.example.com",
+	"localHostname": "machine",
+	"plan": "c3.small",
+	"facility": "dc1",
+	"tags": ["foo"],
+	"publicKeys": ["ssh-ed25519 AAAA"],
+	"userdata": "#cloud-config",
+	"ipv4": {"local": "10.0.0.1"},
+	"operatingSystem": {"slug": "ubuntu_22_04", "distro": "ubuntu", "version": "22.04"},
+	"interfaces": [
+		{"mac": "00:00:00:00:00:01", "address": "10.0.0.1", "family": 4},
+		{"mac": "00:00:00:00:00:01", "address": "203.0.113.1", "family": 4, "public": true},
+		{"mac": "00:00:00:00:00:01", "address": "2001:db8::1", "family": 6}
+	],
+	"storage": {
+		"disks": [{"device": "/dev/sda", "wipeTable": true, "partitions": [{"label": "ROOT", "number": 1, "size": 0}]}],
+		"filesystems": [{"mount": {"device": "/dev/sda1", "format": "ext4", "point": "/", "createOptions": ["-L", "ROOT"]}}]
+	}
+}`
+
+func newInventory(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetEC2Instance(t *testing.T) {
+	var query, auth string
+	server := newInventory(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/instances" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query = r.URL.Query().Get("ip")
+		auth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(instanceJSON))
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewBackend(Config{
+		BaseURL:         server.URL + "/api",
+		BearerTokenFile: tokenFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := backend.GetEC2Instance(context.Background(), "::ffff:10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expect ec2.Instance
+	expect.Userdata = "#cloud-config"
+	expect.Metadata.InstanceID = "machine"
+	expect.Metadata.Hostname = "machine.example.com"
+	expect.Metadata.LocalHostname = "machine"
+	expect.Metadata.Plan = "c3.small"
+	expect.Metadata.Facility = "dc1"
+	expect.Metadata.Tags = []string{"foo"}
+	expect.Metadata.PublicKeys = []string{"ssh-ed25519 AAAA"}
+	expect.Metadata.LocalIPv4 = "10.0.0.1"
+	expect.Metadata.PublicIPv4 = "203.0.113.1"
+	expect.Metadata.PublicIPv6 = "2001:db8::1"
+	expect.Metadata.OperatingSystem.Slug = "ubuntu_22_04"
+	expect.Metadata.OperatingSystem.Distro = "ubuntu"
+	expect.Metadata.OperatingSystem.Version = "22.04"
+
+	if diff := cmp.Diff(expect, instance); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if query != "10.0.0.1" {
+		t.Fatalf("Expected normalized ip query '10.0.0.1'; Received %q", query)
+	}
+
+	if auth != "Bearer secret" {
+		t.Fatalf("Expected bearer token; Received %q", auth)
+	}
+}
+
+func TestGetHackInstance(t *testing.T) {
+	server := newInventory(t, func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(instanceJSON))
+	})
+
+	backend, err := NewBackend(Config{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := backend.GetHackInstance(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expect hack.Instance
+	var fs hack.Filesystem
+	fs.Mount.Device = "/dev/sda1"
+	fs.Mount.Format = "ext4"
+	fs.Mount.Point = "/"
+	fs.Mount.Create.Options = []string{"-L", "ROOT"}
+	expect.Metadata.Instance.Storage = hack.Storage{
+		Disks: []hack.Disk{{
+			Device:     "/dev/sda",
+			WipeTable:  true,
+			Partitions: []hack.Partition{{Label: "ROOT", Number: 1}},
+		}},
+		Filesystems: []hack.Filesystem{fs},
+	}
+
+	if diff := cmp.Diff(expect, instance); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func retries(n int) *int { return &n }
+
+func TestLookupErrors(t *testing.T) {
+	cases := []struct {
+		Name           string
+		Statuses       []int
+		Retries        *int
+		Error          error
+		ExpectAttempts int32
+	}{
+		{
+			Name:           "NotFound",
+			Statuses:       []int{http.StatusNotFound},
+			Error:          ec2.ErrInstanceNotFound,
+			ExpectAttempts: 1,
+		},
+		{
+			Name:           "RetriedUntilSuccess",
+			Statuses:       []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			Retries:        retries(2),
+			ExpectAttempts: 3,
+		},
+		{
+			Name:           "RetriesExhausted",
+			Statuses:       []int{http.StatusBadGateway, http.StatusBadGateway},
+			Retries:        retries(1),
+			Error:          errAny,
+			ExpectAttempts: 2,
+		},
+		{
+			Name:           "NotRetried",
+			Statuses:       []int{http.StatusUnauthorized},
+			Retries:        retries(2),
+			Error:          errAny,
+			ExpectAttempts: 1,
+		},
+		{
+			Name:           "RetriesDisabled",
+			Statuses:       []int{http.StatusServiceUnavailable},
+			Retries:        retries(0),
+			Error:          errAny,
+			ExpectAttempts: 1,
+		},
+		{
+			Name:           "DefaultRetries",
+			Statuses:       []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			Error:          errAny,
+			ExpectAttempts: 3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := newInventory(t, func(w http.ResponseWriter, _ *http.Request) {
+				status := tc.Statuses[attempts.Add(1)-1]
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte(instanceJSON))
+				}
+			})
+
+			backend, err := NewBackend(Config{
+				BaseURL:      server.URL,
+				Retries:      tc.Retries,
+				RetryBackoff: time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = backend.GetEC2Instance(context.Background(), "10.0.0.1")
+			switch {
+			case tc.Error == errAny && err == nil:
+				t.Fatal("Expected error; Received nil")
+			case tc.Error != errAny && !errors.Is(err, tc.Error):
+				t.Fatalf("Expected error %v; Received %v", tc.Error, err)
+			}
+
+			if attempts.Load() != tc.ExpectAttempts {
+				t.Fatalf("Expected %v attempts; Received %v", tc.ExpectAttempts, attempts.Load())
+			}
+		})
+	}
+}
+
+// errAny is used in test cases that expect any error.
+var errAny = errors.New("any error")
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := newInventory(t, func(http.ResponseWriter, *http.Request) {
+		<-release
+	})
+	defer close(release)
+
+	backend, err := NewBackend(Config{
+		BaseURL: server.URL,
+		Timeout: 10 * time.Millisecond,
+		Retries: retries(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded; Received %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	server := newInventory(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(instanceJSON))
+	})
+
+	backend, err := NewBackend(Config{
+		BaseURL:          server.URL,
+		Retries:          retries(0),
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	backend.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err == nil {
+			t.Fatal("Expected error; Received nil")
+		}
+	}
+
+	if backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected unhealthy once the breaker opens")
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen; Received %v", err)
+	}
+
+	if requests.Load() != 2 {
+		t.Fatalf("Expected 2 requests; Received %v", requests.Load())
+	}
+
+	// A failed trial reopens the breaker.
+	now = now.Add(time.Minute)
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected trial request error; Received %v", err)
+	}
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen; Received %v", err)
+	}
+
+	// A successful trial closes the breaker.
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected healthy once the breaker closes")
+	}
+}
+
+func TestCircuitBreakerReadinessRecovery(t *testing.T) {
+	var healthy atomic.Bool
+	server := newInventory(t, func(w http.ResponseWriter, _ *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(instanceJSON))
+	})
+
+	backend, err := NewBackend(Config{
+		BaseURL:          server.URL,
+		Retries:          retries(0),
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	backend.breaker.now = func() time.Time { return now }
+
+	var readiness healthcheck.Readiness
+	readiness.SetReady()
+	router := gin.New()
+	healthcheck.ConfigureProbes(router, &readiness, backend)
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err == nil {
+		t.Fatal("Expected error; Received nil")
+	}
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 while the breaker is open; Received %v", code)
+	}
+
+	// Once the cooldown elapses the backend is ready again, without any lookups, so traffic
+	// returns to provide the trial lookup.
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	if code := readyz(); code != http.StatusOK {
+		t.Fatalf("Expected 200 once the cooldown elapses; Received %v", code)
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if code := readyz(); code != http.StatusOK {
+		t.Fatalf("Expected 200 once the breaker closes; Received %v", code)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCA(t)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+
+	serverCert := newTestCert(t, ca, caKey, x509.ExtKeyUsageServerAuth)
+	clientCert := newTestCert(t, ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	clientKey, err := x509.MarshalECPrivateKey(clientCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientCert.Certificate[0])
+	writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", clientKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(instanceJSON))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	cases := []struct {
+		Name   string
+		Config Config
+		Error  bool
+	}{
+		{
+			Name: "ClientCertificate",
+			Config: Config{
+				CAFile:   filepath.Join(dir, "ca.pem"),
+				CertFile: filepath.Join(dir, "client.pem"),
+				KeyFile:  filepath.Join(dir, "client-key.pem"),
+			},
+		},
+		{
+			Name:   "MissingClientCertificate",
+			Config: Config{CAFile: filepath.Join(dir, "ca.pem")},
+			Error:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Config.BaseURL = server.URL
+			tc.Config.Retries = retries(0)
+
+			backend, err := NewBackend(tc.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = backend.GetEC2Instance(context.Background(), "10.0.0.1")
+			if tc.Error != (err != nil) {
+				t.Fatalf("Expected error: %v; Received %v", tc.Error, err)
+			}
+
+			// Client certificates are reported as a health check.
+			checks := backend.HealthChecks()
+			if tc.Config.CertFile == "" {
+				if len(checks) != 0 {
+					t.Fatalf("Expected no health checks; Received %v", len(checks))
+				}
+				return
+			}
+			if len(checks) != 1 || checks[0].Name() != "rest-client-certificate" {
+				t.Fatalf("Expected a client certificate health check; Received %v", checks)
+			}
+			if err := checks[0].Check(context.Background()); err != nil {
+				t.Fatalf("Unexpected health check error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewBackendErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Config Config
+	}{
+		{Name: "MissingBaseURL"},
+		{Name: "UnsupportedScheme", Config: Config{BaseURL: "ftp://example.com"}},
+		{Name: "MissingCAFile", Config: Config{BaseURL: "https://example.com", CAFile: "missing.pem"}},
+		{Name: "MissingKeyFile", Config: Config{BaseURL: "https://example.com", CertFile: "cert.pem"}},
+		{Name: "MissingTokenFile", Config: Config{BaseURL: "https://example.com", BearerTokenFile: "token"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := NewBackend(tc.Config); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func newTestCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDatabase opens an empty SQLite database file. The database is closed when the test
+// ends.
+func openTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open(DriverSQLite, "file:"+filepath.Join(t.TempDir(), "hegel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+// newTestDatabase opens a SQLite database file, applies the migrations and executes statements.
+func newTestDatabase(t *testing.T, statements ...string) *sql.DB {
+	t.Helper()
+
+	db := openTestDatabase(t)
+	if err := migrate(context.Background(), db, DriverSQLite); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	exec(t, db, statements...)
+
+	return db
+}
+
+// exec executes statements against db.
+func exec(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%v: %v", stmt, err)
+		}
+	}
+}
+
+// appliedVersions returns the versions recorded in hegel_schema_migrations.
+func appliedVersions(t *testing.T, db *sql.DB) []int64 {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT version FROM hegel_schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var versions []int64
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return versions
+}
@@ -0,0 +1,48 @@
+package sqldb
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/lib/pq"
+)
+
+// notifyChannel is the PostgreSQL notification channel the schema triggers notify on.
+const notifyChannel = "hegel_instances"
+
+// listen subscribes to changes notified on notifyChannel. The returned channel receives a value
+// when the database changes or when the listener reconnects, as notifications may have been
+// missed while disconnected. Notifications that arrive while a value is pending are coalesced.
+func listen(ctx context.Context, dsn string, logger logr.Logger) (<-chan struct{}, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Info("Database listener event", "event", event, "error", err.Error())
+		}
+	})
+
+	if err := listener.Listen(notifyChannel); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+			}
+
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changed, nil
+}
@@ -0,0 +1,217 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+// Queries used to load instances. Rows are ordered so list fields are served in a stable order.
+const (
+	queryInstances = `SELECT id, hostname, local_hostname, iqn, plan, facility, os_slug, os_distro, ` +
+		`os_version, os_image_tag, os_license_activation_state FROM hegel_instances`
+	queryTags        = `SELECT instance_id, tag FROM hegel_tags ORDER BY instance_id, position, tag`
+	queryInterfaces  = `SELECT instance_id, address, public FROM hegel_interfaces ORDER BY instance_id, position, address`
+	querySSHKeys     = `SELECT instance_id, public_key FROM hegel_ssh_keys ORDER BY instance_id, position, public_key`
+	queryUserdata    = `SELECT instance_id, userdata FROM hegel_userdata`
+	queryDisks       = `SELECT instance_id, device, wipe_table FROM hegel_disks ORDER BY instance_id, position, device`
+	queryPartitions  = `SELECT instance_id, device, number, label, size FROM hegel_partitions ORDER BY instance_id, device, number`
+	queryFilesystems = `SELECT instance_id, device, format, point, create_options FROM hegel_filesystems ` +
+		`ORDER BY instance_id, position, device`
+)
+
+// instance holds the frontend models for an instance.
+type instance struct {
+	id   string
+	ips  []string
+	ec2  ec2.Instance
+	hack hack.Instance
+}
+
+// load reads all instances from db. Instances are read in a single transaction so they're
+// consistent with each other.
+//
+//nolint:cyclop // This function is just mapping rows, it's not complex.
+func load(ctx context.Context, db *sql.DB) (map[string]*instance, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback() //nolint:errcheck // The transaction is only read from.
+
+	instances := map[string]*instance{}
+
+	err = query(ctx, tx, queryInstances, func(rows *sql.Rows) error {
+		var i instance
+		md := &i.ec2.Metadata
+		opsys := &md.OperatingSystem
+		err := rows.Scan(&i.id, &md.Hostname, &md.LocalHostname, &md.IQN, &md.Plan, &md.Facility,
+			&opsys.Slug, &opsys.Distro, &opsys.Version, &opsys.ImageTag, &opsys.LicenseActivation.State)
+		if err != nil {
+			return err
+		}
+		md.InstanceID = i.id
+		instances[i.id] = &i
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("instances: %w", err)
+	}
+
+	err = query(ctx, tx, queryTags, func(rows *sql.Rows) error {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			i.ec2.Metadata.Tags = append(i.ec2.Metadata.Tags, tag)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tags: %w", err)
+	}
+
+	err = query(ctx, tx, queryInterfaces, func(rows *sql.Rows) error {
+		var id, address string
+		var public bool
+		if err := rows.Scan(&id, &address, &public); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			i.addInterface(address, public)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("interfaces: %w", err)
+	}
+
+	err = query(ctx, tx, querySSHKeys, func(rows *sql.Rows) error {
+		var id, key string
+		if err := rows.Scan(&id, &key); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			i.ec2.Metadata.PublicKeys = append(i.ec2.Metadata.PublicKeys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssh keys: %w", err)
+	}
+
+	err = query(ctx, tx, queryUserdata, func(rows *sql.Rows) error {
+		var id, userdata string
+		if err := rows.Scan(&id, &userdata); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			i.ec2.Userdata = userdata
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("userdata: %w", err)
+	}
+
+	err = query(ctx, tx, queryDisks, func(rows *sql.Rows) error {
+		var id string
+		var d hack.Disk
+		if err := rows.Scan(&id, &d.Device, &d.WipeTable); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			storage := &i.hack.Metadata.Instance.Storage
+			storage.Disks = append(storage.Disks, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("disks: %w", err)
+	}
+
+	err = query(ctx, tx, queryPartitions, func(rows *sql.Rows) error {
+		var id, device string
+		var p hack.Partition
+		if err := rows.Scan(&id, &device, &p.Number, &p.Label, &p.Size); err != nil {
+			return err
+		}
+		if i, ok := instances[id]; ok {
+			disks := i.hack.Metadata.Instance.Storage.Disks
+			for idx := range disks {
+				if disks[idx].Device == device {
+					disks[idx].Partitions = append(disks[idx].Partitions, p)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("partitions: %w", err)
+	}
+
+	err = query(ctx, tx, queryFilesystems, func(rows *sql.Rows) error {
+		var id, options string
+		var fs hack.Filesystem
+		if err := rows.Scan(&id, &fs.Mount.Device, &fs.Mount.Format, &fs.Mount.Point, &options); err != nil {
+			return err
+		}
+		fs.Mount.Create.Options = strings.Fields(options)
+		if i, ok := instances[id]; ok {
+			storage := &i.hack.Metadata.Instance.Storage
+			storage.Filesystems = append(storage.Filesystems, fs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("filesystems: %w", err)
+	}
+
+	return instances, nil
+}
+
+// query runs q and calls scan for each row.
+func query(ctx context.Context, tx *sql.Tx, q string, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// addInterface records address as an address i can be looked up by. The first address of each
+// family and visibility is used as the corresponding instance address. Invalid addresses are
+// ignored.
+func (i *instance) addInterface(address string, public bool) {
+	normalized, err := ipaddr.Normalize(address)
+	if err != nil {
+		return
+	}
+	i.ips = append(i.ips, normalized)
+
+	md := &i.ec2.Metadata
+	addr := netip.MustParseAddr(normalized)
+	switch {
+	case addr.Is4() && public && md.PublicIPv4 == "":
+		md.PublicIPv4 = normalized
+	case addr.Is4() && !public && md.LocalIPv4 == "":
+		md.LocalIPv4 = normalized
+	case addr.Is6() && md.PublicIPv6 == "":
+		md.PublicIPv6 = normalized
+	}
+}
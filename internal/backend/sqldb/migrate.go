@@ -0,0 +1,137 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migration is a schema change. Migrations are applied in version order and each is recorded in
+// the hegel_schema_migrations table once applied.
+type migration struct {
+	version int
+	name    string
+
+	// driver restricts the migration to a driver. Migrations for other drivers are recorded
+	// without being executed so versions are consistent across drivers. Empty applies to all
+	// drivers.
+	driver string
+
+	statements string
+}
+
+// migrations parses the embedded migrations. Migration files are named
+// <version>_<name>[.<driver>].sql.
+func migrations() ([]migration, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		base := strings.TrimSuffix(e.Name(), ".sql")
+
+		version, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name: %v", e.Name())
+		}
+
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version: %v", e.Name())
+		}
+
+		if other, ok := seen[v]; ok {
+			return nil, fmt.Errorf("duplicate migration version: %v and %v", other, e.Name())
+		}
+		seen[v] = e.Name()
+
+		name, driver, _ := strings.Cut(name, ".")
+
+		statements, err := migrationFS.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, migration{
+			version:    v,
+			name:       name,
+			driver:     driver,
+			statements: string(statements),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].version < result[j].version })
+
+	return result, nil
+}
+
+// migrate applies pending migrations to db. Each migration is applied in its own transaction.
+func migrate(ctx context.Context, db *sql.DB, driver string) error {
+	all, err := migrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS hegel_schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+
+	for _, m := range all {
+		if err := apply(ctx, db, driver, m); err != nil {
+			return fmt.Errorf("migration %v_%v: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func apply(ctx context.Context, db *sql.DB, driver string, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // Rollback after Commit is a no-op.
+
+	// Serialize migrations across Hegel instances sharing a database. SQLite serializes writers
+	// already.
+	if driver == DriverPostgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(7468656765)`); err != nil {
+			return fmt.Errorf("acquire lock: %w", err)
+		}
+	}
+
+	var applied int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM hegel_schema_migrations WHERE version = $1`, m.version).
+		Scan(&applied)
+	if err != nil {
+		return err
+	}
+
+	if applied > 0 {
+		return nil
+	}
+
+	if m.driver == "" || m.driver == driver {
+		if _, err := tx.ExecContext(ctx, m.statements); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO hegel_schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrations(t *testing.T) {
+	all, err := migrations()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for idx, m := range all {
+		if m.version != idx+1 {
+			t.Fatalf("Expected contiguous versions starting at 1; Received %v at index %v", m.version, idx)
+		}
+		if m.driver != "" && m.driver != DriverPostgres && m.driver != DriverSQLite {
+			t.Fatalf("Unexpected driver for migration %v: %q", m.version, m.driver)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	all, err := migrations()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expectVersions []int64
+	for _, m := range all {
+		expectVersions = append(expectVersions, int64(m.version))
+	}
+
+	db := openTestDatabase(t)
+
+	if err := migrate(context.Background(), db, DriverSQLite); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(expectVersions, appliedVersions(t, db)); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// The schema is created and PostgreSQL only migrations are recorded without being executed.
+	var tables, triggers int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name LIKE 'hegel_%'`).Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 9 {
+		t.Fatalf("Expected 9 tables; Received %v", tables)
+	}
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger'`).Scan(&triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if triggers != 0 {
+		t.Fatalf("Expected no triggers; Received %v", triggers)
+	}
+
+	// Applied migrations aren't applied again. Reapplying the schema would fail because its
+	// tables exist.
+	if err := migrate(context.Background(), db, DriverSQLite); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(expectVersions, appliedVersions(t, db)); diff != "" {
+		t.Fatal(diff)
+	}
+}
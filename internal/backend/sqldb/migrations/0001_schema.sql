@@ -0,0 +1,74 @@
+-- Instances served by Hegel. See docs/sql-backend.md for a description of each column.
+CREATE TABLE hegel_instances (
+    id                          TEXT PRIMARY KEY,
+    hostname                    TEXT NOT NULL DEFAULT '',
+    local_hostname              TEXT NOT NULL DEFAULT '',
+    iqn                         TEXT NOT NULL DEFAULT '',
+    plan                        TEXT NOT NULL DEFAULT '',
+    facility                    TEXT NOT NULL DEFAULT '',
+    os_slug                     TEXT NOT NULL DEFAULT '',
+    os_distro                   TEXT NOT NULL DEFAULT '',
+    os_version                  TEXT NOT NULL DEFAULT '',
+    os_image_tag                TEXT NOT NULL DEFAULT '',
+    os_license_activation_state TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE hegel_tags (
+    instance_id TEXT NOT NULL REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL DEFAULT 0,
+    tag         TEXT NOT NULL,
+    PRIMARY KEY (instance_id, tag)
+);
+
+CREATE TABLE hegel_interfaces (
+    instance_id TEXT NOT NULL REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL DEFAULT 0,
+    mac         TEXT NOT NULL DEFAULT '',
+    address     TEXT NOT NULL,
+    netmask     TEXT NOT NULL DEFAULT '',
+    gateway     TEXT NOT NULL DEFAULT '',
+    public      BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (instance_id, address)
+);
+
+CREATE INDEX hegel_interfaces_address ON hegel_interfaces (address);
+
+CREATE TABLE hegel_ssh_keys (
+    instance_id TEXT NOT NULL REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL DEFAULT 0,
+    public_key  TEXT NOT NULL,
+    PRIMARY KEY (instance_id, public_key)
+);
+
+CREATE TABLE hegel_disks (
+    instance_id TEXT NOT NULL REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    position    INTEGER NOT NULL DEFAULT 0,
+    device      TEXT NOT NULL,
+    wipe_table  BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (instance_id, device)
+);
+
+CREATE TABLE hegel_partitions (
+    instance_id TEXT NOT NULL,
+    device      TEXT NOT NULL,
+    number      INTEGER NOT NULL,
+    label       TEXT NOT NULL DEFAULT '',
+    size        BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (instance_id, device, number),
+    FOREIGN KEY (instance_id, device) REFERENCES hegel_disks (instance_id, device) ON DELETE CASCADE
+);
+
+CREATE TABLE hegel_filesystems (
+    instance_id    TEXT NOT NULL REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    position       INTEGER NOT NULL DEFAULT 0,
+    device         TEXT NOT NULL,
+    format         TEXT NOT NULL DEFAULT '',
+    point          TEXT NOT NULL DEFAULT '',
+    create_options TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (instance_id, device)
+);
+
+CREATE TABLE hegel_userdata (
+    instance_id TEXT PRIMARY KEY REFERENCES hegel_instances (id) ON DELETE CASCADE,
+    userdata    TEXT NOT NULL DEFAULT ''
+);
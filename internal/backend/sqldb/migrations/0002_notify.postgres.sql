@@ -0,0 +1,25 @@
+-- Notify listening Hegel instances whenever served data changes. Hegel reloads all instances on
+-- each notification so the payload is empty.
+CREATE FUNCTION hegel_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('hegel_instances', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER hegel_instances_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_instances FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_tags_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_tags FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_interfaces_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_interfaces FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_ssh_keys_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_ssh_keys FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_disks_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_disks FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_partitions_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_partitions FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_filesystems_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_filesystems FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
+CREATE TRIGGER hegel_userdata_notify AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON hegel_userdata FOR EACH STATEMENT EXECUTE FUNCTION hegel_notify();
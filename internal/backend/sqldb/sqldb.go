@@ -0,0 +1,289 @@
+/*
+Package sqldb provides a backend that serves instances from a SQL database. The schema is
+described in docs/sql-backend.md and is created by the backend's migrations. Instances are loaded
+into memory and refreshed by polling the database or, with PostgreSQL, when the database notifies
+the backend of changes.
+*/
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+
+	// Register the PostgreSQL and SQLite drivers.
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Supported drivers. The driver must be registered with database/sql under its name.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+var (
+	errNotFound         = errors.New("no instance found")
+	errMultipleInstance = errors.New("multiple instances found")
+)
+
+const defaultPollInterval = 30 * time.Second
+
+// Config configures a Backend.
+type Config struct {
+	// Driver is the database driver. One of DriverPostgres or DriverSQLite.
+	Driver string
+
+	// DSN is the driver specific data source name, for example
+	// postgres://hegel@db/hegel?sslmode=verify-full or file:/var/lib/hegel/hegel.db.
+	DSN string
+
+	// PollInterval is the interval at which instances are reloaded from the database. Defaults to
+	// 30s. Optional.
+	PollInterval time.Duration
+
+	// Listen reloads instances when the database notifies the backend of changes using
+	// PostgreSQL LISTEN/NOTIFY. Polling continues at PollInterval in case notifications are
+	// missed. Optional.
+	Listen bool
+
+	// Migrate applies pending schema migrations when the backend starts. When disabled, the
+	// schema must be managed externally. Optional.
+	Migrate bool
+
+	// Logger is used to log backend events. Optional.
+	Logger logr.Logger
+}
+
+// Backend serves instances from a SQL database.
+type Backend struct {
+	db           *sql.DB
+	driver       string
+	migrate      bool
+	pollInterval time.Duration
+	logger       logr.Logger
+
+	// subscribe returns a channel that receives a value whenever the database changes. It is
+	// nil when notifications are disabled.
+	subscribe func(ctx context.Context) (<-chan struct{}, error)
+
+	mtx         sync.RWMutex
+	byIP        map[string][]*instance
+	synced      bool
+	lastErr     error
+	lastRefresh time.Time
+	ready       chan struct{}
+	readyOnce   sync.Once
+}
+
+// NewBackend creates a Backend. The Backend doesn't serve instances until Start is called.
+func NewBackend(cfg Config) (*Backend, error) {
+	switch cfg.Driver {
+	case DriverPostgres, DriverSQLite:
+	default:
+		return nil, fmt.Errorf("unsupported sql driver: %q", cfg.Driver)
+	}
+
+	if cfg.DSN == "" {
+		return nil, errors.New("sql dsn is required")
+	}
+
+	if cfg.Listen && cfg.Driver != DriverPostgres {
+		return nil, fmt.Errorf("listen requires the %v driver", DriverPostgres)
+	}
+
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+
+	if cfg.Logger.GetSink() == nil {
+		cfg.Logger = logr.Discard()
+	}
+
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %v", err)
+	}
+
+	b := newBackend(db, cfg)
+	if cfg.Listen {
+		b.subscribe = func(ctx context.Context) (<-chan struct{}, error) {
+			return listen(ctx, cfg.DSN, b.logger)
+		}
+	}
+
+	return b, nil
+}
+
+func newBackend(db *sql.DB, cfg Config) *Backend {
+	return &Backend{
+		db:           db,
+		driver:       cfg.Driver,
+		migrate:      cfg.Migrate,
+		pollInterval: cfg.PollInterval,
+		logger:       cfg.Logger,
+		byIP:         map[string][]*instance{},
+		ready:        make(chan struct{}),
+	}
+}
+
+// Start satisfies backend.Runner. It applies migrations if enabled, loads instances and reloads
+// them every poll interval, or when notified, until ctx is cancelled. Failures to reload are
+// logged and reported via IsHealthy.
+func (b *Backend) Start(ctx context.Context) error {
+	defer b.db.Close()
+
+	if b.migrate {
+		if err := migrate(ctx, b.db, b.driver); err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
+	}
+
+	var notify <-chan struct{}
+	if b.subscribe != nil {
+		var err error
+		if notify, err = b.subscribe(ctx); err != nil {
+			return fmt.Errorf("listen for changes: %w", err)
+		}
+	}
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.refresh(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Error(err, "Reloading instances")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-notify:
+		}
+	}
+}
+
+// WaitForReady satisfies backend.ReadyWaiter. The Backend is ready once instances have been
+// loaded.
+func (b *Backend) WaitForReady(ctx context.Context) bool {
+	select {
+	case <-b.ready:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// IsHealthy satisfies healthcheck.Client. The Backend is healthy when instances have been loaded
+// and the most recent reload succeeded.
+func (b *Backend) IsHealthy(context.Context) bool {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	return b.synced && b.lastErr == nil
+}
+
+// DataAge satisfies healthcheck.DataAgeSource. It returns how long it has been since instances
+// were last loaded.
+func (b *Backend) DataAge() time.Duration {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	if b.lastRefresh.IsZero() {
+		return 0
+	}
+	return time.Since(b.lastRefresh)
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	i, err := b.retrieveByIP(ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return i.ec2, nil
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(_ context.Context, ip string) (hack.Instance, error) {
+	i, err := b.retrieveByIP(ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return hack.Instance{}, hack.ErrInstanceNotFound
+		}
+
+		return hack.Instance{}, err
+	}
+
+	return i.hack, nil
+}
+
+func (b *Backend) retrieveByIP(ip string) (*instance, error) {
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return nil, errNotFound
+	}
+
+	b.mtx.RLock()
+	entries := b.byIP[normalized]
+	b.mtx.RUnlock()
+
+	switch len(entries) {
+	case 0:
+		return nil, errNotFound
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("%w: ip %v", errMultipleInstance, normalized)
+	}
+}
+
+// refresh replaces the served instances with those in the database.
+func (b *Backend) refresh(ctx context.Context) error {
+	instances, err := load(ctx, b.db)
+	if err != nil {
+		return b.recordRefresh(fmt.Errorf("load instances: %w", err))
+	}
+
+	byIP := map[string][]*instance{}
+	for _, i := range instances {
+		for _, ip := range i.ips {
+			byIP[ip] = append(byIP[ip], i)
+		}
+	}
+
+	b.mtx.Lock()
+	b.byIP = byIP
+	b.mtx.Unlock()
+
+	b.readyOnce.Do(func() { close(b.ready) })
+
+	return b.recordRefresh(nil)
+}
+
+func (b *Backend) recordRefresh(err error) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.lastErr = err
+	if err == nil {
+		b.synced = true
+		b.lastRefresh = time.Now()
+	}
+
+	return err
+}
@@ -0,0 +1,258 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+// testStatements populate a database with instance-1. Rows for instance-2, which doesn't exist,
+// are ignored.
+var testStatements = []string{
+	`INSERT INTO hegel_instances VALUES ('instance-1', 'machine1', 'machine1.local',
+		'iqn.2024-01.org.tinkerbell:machine1', 'c3.small', 'dc1', 'ubuntu_22_04', 'ubuntu', '22.04', 'latest',
+		'activated')`,
+	`INSERT INTO hegel_tags (instance_id, position, tag) VALUES ('instance-1', 0, 'foo'), ('instance-1', 1, 'bar')`,
+	`INSERT INTO hegel_interfaces (instance_id, position, address, public) VALUES
+		('instance-1', 0, '10.0.0.1', FALSE),
+		('instance-1', 1, '203.0.113.1', TRUE),
+		('instance-1', 2, '2001:DB8::1', TRUE),
+		('instance-1', 3, 'invalid', FALSE),
+		('instance-2', 0, '10.0.0.2', FALSE)`,
+	`INSERT INTO hegel_ssh_keys (instance_id, public_key) VALUES ('instance-1', 'ssh-ed25519 AAAA')`,
+	`INSERT INTO hegel_userdata VALUES ('instance-1', '#cloud-config')`,
+	`INSERT INTO hegel_disks (instance_id, device, wipe_table) VALUES ('instance-1', '/dev/sda', TRUE)`,
+	`INSERT INTO hegel_partitions VALUES ('instance-1', '/dev/sda', 2, 'ROOT', 0), ('instance-1', '/dev/sda', 1, 'BIOS', 4096)`,
+	`INSERT INTO hegel_filesystems (instance_id, device, format, point, create_options) VALUES
+		('instance-1', '/dev/sda2', 'ext4', '/', '-L ROOT')`,
+}
+
+func newTestBackend(t *testing.T, db *sql.DB, cfg Config) *Backend {
+	t.Helper()
+
+	if cfg.Driver == "" {
+		cfg.Driver = DriverSQLite
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Hour
+	}
+
+	return newBackend(db, cfg)
+}
+
+func startBackend(t *testing.T, backend *Backend) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = backend.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if !backend.WaitForReady(waitCtx) {
+		t.Fatal("Backend failed to become ready")
+	}
+
+	return ctx
+}
+
+func TestBackendLookups(t *testing.T) {
+	backend := newTestBackend(t, newTestDatabase(t, testStatements...), Config{})
+	if backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected unhealthy before instances are loaded")
+	}
+
+	startBackend(t, backend)
+
+	if !backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected healthy once instances are loaded")
+	}
+
+	var expectEC2 ec2.Instance
+	expectEC2.Userdata = "#cloud-config"
+	expectEC2.Metadata.InstanceID = "instance-1"
+	expectEC2.Metadata.Hostname = "machine1"
+	expectEC2.Metadata.LocalHostname = "machine1.local"
+	expectEC2.Metadata.IQN = "iqn.2024-01.org.tinkerbell:machine1"
+	expectEC2.Metadata.Plan = "c3.small"
+	expectEC2.Metadata.Facility = "dc1"
+	expectEC2.Metadata.Tags = []string{"foo", "bar"}
+	expectEC2.Metadata.PublicKeys = []string{"ssh-ed25519 AAAA"}
+	expectEC2.Metadata.LocalIPv4 = "10.0.0.1"
+	expectEC2.Metadata.PublicIPv4 = "203.0.113.1"
+	expectEC2.Metadata.PublicIPv6 = "2001:db8::1"
+	expectEC2.Metadata.OperatingSystem.Slug = "ubuntu_22_04"
+	expectEC2.Metadata.OperatingSystem.Distro = "ubuntu"
+	expectEC2.Metadata.OperatingSystem.Version = "22.04"
+	expectEC2.Metadata.OperatingSystem.ImageTag = "latest"
+	expectEC2.Metadata.OperatingSystem.LicenseActivation.State = "activated"
+
+	for _, addr := range []string{"10.0.0.1", "::ffff:10.0.0.1", "203.0.113.1", "2001:db8::1"} {
+		instance, err := backend.GetEC2Instance(context.Background(), addr)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", addr, err)
+		}
+		if diff := cmp.Diff(expectEC2, instance); diff != "" {
+			t.Fatalf("%v: %v", addr, diff)
+		}
+	}
+
+	hackInstance, err := backend.GetHackInstance(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var fs hack.Filesystem
+	fs.Mount.Device = "/dev/sda2"
+	fs.Mount.Format = "ext4"
+	fs.Mount.Point = "/"
+	fs.Mount.Create.Options = []string{"-L", "ROOT"}
+	expectStorage := hack.Storage{
+		Disks: []hack.Disk{{
+			Device:    "/dev/sda",
+			WipeTable: true,
+			Partitions: []hack.Partition{
+				{Label: "BIOS", Number: 1, Size: 4096},
+				{Label: "ROOT", Number: 2},
+			},
+		}},
+		Filesystems: []hack.Filesystem{fs},
+	}
+	if diff := cmp.Diff(expectStorage, hackInstance.Metadata.Instance.Storage); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, addr := range []string{"10.0.0.2", "10.0.0.99", "invalid"} {
+		if _, err := backend.GetEC2Instance(context.Background(), addr); !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("%v: Expected ec2.ErrInstanceNotFound; Received %v", addr, err)
+		}
+		if _, err := backend.GetHackInstance(context.Background(), addr); !errors.Is(err, hack.ErrInstanceNotFound) {
+			t.Fatalf("%v: Expected hack.ErrInstanceNotFound; Received %v", addr, err)
+		}
+	}
+}
+
+func TestBackendMultipleInstances(t *testing.T) {
+	db := newTestDatabase(t,
+		`INSERT INTO hegel_instances (id) VALUES ('instance-1'), ('instance-2')`,
+		`INSERT INTO hegel_interfaces (instance_id, address) VALUES ('instance-1', '10.0.0.1'), ('instance-2', '10.0.0.1')`,
+	)
+
+	backend := newTestBackend(t, db, Config{})
+	startBackend(t, backend)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, errMultipleInstance) {
+		t.Fatalf("Expected errMultipleInstance; Received %v", err)
+	}
+}
+
+func TestBackendRefresh(t *testing.T) {
+	db := newTestDatabase(t, testStatements...)
+	notify := make(chan struct{})
+
+	backend := newTestBackend(t, db, Config{})
+	backend.subscribe = func(context.Context) (<-chan struct{}, error) { return notify, nil }
+	ctx := startBackend(t, backend)
+
+	// A failed reload continues serving the previously loaded instances but reports unhealthy.
+	exec(t, db, `ALTER TABLE hegel_tags RENAME TO hegel_tags_renamed`)
+	if err := backend.refresh(ctx); err == nil {
+		t.Fatal("Expected error; Received nil")
+	}
+
+	if backend.IsHealthy(ctx) {
+		t.Fatal("Expected unhealthy after a failed reload")
+	}
+
+	if _, err := backend.GetEC2Instance(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Notifications reload instances.
+	exec(t, db,
+		`ALTER TABLE hegel_tags_renamed RENAME TO hegel_tags`,
+		`DELETE FROM hegel_interfaces`,
+		`INSERT INTO hegel_interfaces (instance_id, address) VALUES ('instance-1', '10.0.0.5')`,
+	)
+	notify <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := backend.GetEC2Instance(ctx, "10.0.0.5"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := backend.GetEC2Instance(ctx, "10.0.0.1"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+
+	if !backend.IsHealthy(ctx) {
+		t.Fatal("Expected healthy after a successful reload")
+	}
+}
+
+func TestBackendMigrateOnStart(t *testing.T) {
+	db := openTestDatabase(t)
+	backend := newTestBackend(t, db, Config{Migrate: true})
+	startBackend(t, backend)
+
+	if len(appliedVersions(t, db)) == 0 {
+		t.Fatal("Expected migrations to be applied")
+	}
+}
+
+func TestNewBackendSQLite(t *testing.T) {
+	backend, err := NewBackend(Config{
+		Driver:  DriverSQLite,
+		DSN:     "file:" + filepath.Join(t.TempDir(), "hegel.db"),
+		Migrate: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	startBackend(t, backend)
+
+	if !backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected healthy once instances are loaded")
+	}
+}
+
+func TestNewBackendErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Config Config
+	}{
+		{Name: "MissingDriver", Config: Config{DSN: "file:hegel.db"}},
+		{Name: "UnsupportedDriver", Config: Config{Driver: "mysql", DSN: "hegel@/hegel"}},
+		{Name: "MissingDSN", Config: Config{Driver: DriverPostgres}},
+		{Name: "ListenWithSQLite", Config: Config{Driver: DriverSQLite, DSN: "file:hegel.db", Listen: true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := NewBackend(tc.Config); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,559 @@
+/*
+Package tinkgrpc provides a backend for the legacy Tink server gRPC HardwareService used by
+Tinkerbell deployments that predate the Kubernetes backend. Hardware is cached locally. The cache
+is populated using HardwareService.All and periodically resynchronized. Hardware that has been
+looked up is kept up to date using HardwareService.DeprecatedWatch.
+*/
+package tinkgrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/healthcheck"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errNotFound         = errors.New("no hardware found")
+	errMultipleHardware = errors.New("multiple hardware found")
+)
+
+// Defaults applied to Config.
+const (
+	defaultResyncInterval = 5 * time.Minute
+	defaultMaxWatches     = 1000
+)
+
+// The initial sync is retried with exponential backoff, starting at initialSyncBackoff and
+// capped at maxInitialSyncBackoff or the resync interval, whichever is smaller, so a Tink server
+// that is briefly unavailable at startup doesn't leave Hegel unready for a whole resync interval.
+const (
+	initialSyncBackoff    = time.Second
+	maxInitialSyncBackoff = 30 * time.Second
+)
+
+// Config configures a Backend.
+type Config struct {
+	// Address is the address of the Tink server gRPC endpoint, for example tink-server:42113.
+	Address string
+
+	// Insecure disables TLS. Optional.
+	Insecure bool
+
+	// CAFile is a path to a PEM encoded CA bundle used to verify the Tink server. If empty, the
+	// system roots are used. Optional.
+	CAFile string
+
+	// CertFile and KeyFile are paths to a PEM encoded client certificate and key used to
+	// authenticate with the Tink server using mutual TLS. Optional.
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the name used to verify the Tink server certificate. Optional.
+	ServerName string
+
+	// ResyncInterval is the interval at which all hardware is retrieved from the Tink server.
+	// Defaults to 5m. Optional.
+	ResyncInterval time.Duration
+
+	// MaxWatches is the maximum number of hardware watched for changes between resyncs.
+	// Defaults to 1000. Optional.
+	MaxWatches int
+
+	// Logger is used to log backend events. Optional.
+	Logger logr.Logger
+
+	// DialOptions are additional options used to connect to the Tink server. Optional.
+	DialOptions []grpc.DialOption
+}
+
+// Backend serves hardware from a Tink server.
+type Backend struct {
+	conn           *grpc.ClientConn
+	logger         logr.Logger
+	resyncInterval time.Duration
+	maxWatches     int
+
+	mtx       sync.RWMutex
+	byID      map[string]*instance
+	byIP      map[string][]*instance
+	watches   map[string]*hardwareWatch
+	runCtx    context.Context // Watches are bound to the context Start is called with.
+	synced    bool
+	lastErr   error
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// certificate is the client certificate used for mutual TLS. It is nil if none is used.
+	certificate *x509.Certificate
+
+	// syncBackoff is the first delay before retrying a failed initial sync. It exists for
+	// testing.
+	syncBackoff time.Duration
+}
+
+// hardwareWatch is a running watch of a single hardware.
+type hardwareWatch struct {
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the watch has ended.
+}
+
+// NewBackend creates a Backend. The Backend doesn't serve cached hardware until Start is called.
+func NewBackend(cfg Config) (*Backend, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("tink server address is required")
+	}
+
+	if cfg.ResyncInterval == 0 {
+		cfg.ResyncInterval = defaultResyncInterval
+	}
+
+	if cfg.MaxWatches == 0 {
+		cfg.MaxWatches = defaultMaxWatches
+	}
+
+	if cfg.Logger.GetSink() == nil {
+		cfg.Logger = logr.Discard()
+	}
+
+	creds, certificate, err := cfg.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	}, cfg.DialOptions...)
+
+	conn, err := grpc.NewClient(cfg.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create tink server client: %v", err)
+	}
+
+	return &Backend{
+		conn:           conn,
+		logger:         cfg.Logger,
+		resyncInterval: cfg.ResyncInterval,
+		maxWatches:     cfg.MaxWatches,
+		byID:           map[string]*instance{},
+		byIP:           map[string][]*instance{},
+		watches:        map[string]*hardwareWatch{},
+		ready:          make(chan struct{}),
+		certificate:    certificate,
+		syncBackoff:    initialSyncBackoff,
+	}, nil
+}
+
+// transportCredentials returns the credentials used to connect to the Tink server and the client
+// certificate, if any, they present.
+func (c Config) transportCredentials() (credentials.TransportCredentials, *x509.Certificate, error) {
+	if c.Insecure {
+		return insecure.NewCredentials(), nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.ServerName}
+	var certificate *x509.Certificate
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read ca file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in ca file: %v", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+
+		certificate, err = healthcheck.Leaf(cert)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse client certificate: %v", err)
+		}
+	}
+
+	return credentials.NewTLS(cfg), certificate, nil
+}
+
+// Start satisfies backend.Runner. It populates the cache and resynchronizes it every resync
+// interval until ctx is cancelled. Failures to resynchronize are logged and reported via
+// IsHealthy.
+func (b *Backend) Start(ctx context.Context) error {
+	defer b.conn.Close()
+
+	b.mtx.Lock()
+	b.runCtx = ctx
+	b.mtx.Unlock()
+
+	if !b.initialSync(ctx) {
+		return nil
+	}
+
+	ticker := time.NewTicker(b.resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := b.resync(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Error(err, "Resynchronizing hardware")
+		}
+	}
+}
+
+// initialSync populates the cache, retrying with backoff until it succeeds. It returns false if
+// ctx is cancelled first.
+func (b *Backend) initialSync(ctx context.Context) bool {
+	backoff := b.syncBackoff
+	maxBackoff := min(maxInitialSyncBackoff, b.resyncInterval)
+
+	for {
+		err := b.resync(ctx)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+
+		b.logger.Error(err, "Synchronizing hardware", "retryIn", backoff.String())
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// WaitForReady satisfies backend.ReadyWaiter. The Backend is ready once the cache has been
+// populated.
+func (b *Backend) WaitForReady(ctx context.Context) bool {
+	select {
+	case <-b.ready:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// HealthChecks satisfies healthcheck.CheckProvider.
+func (b *Backend) HealthChecks() []healthcheck.Checker {
+	if b.certificate == nil {
+		return nil
+	}
+	return []healthcheck.Checker{healthcheck.NewCertificateChecker("tink-client-certificate", b.certificate)}
+}
+
+// IsHealthy satisfies healthcheck.Client. The Backend is healthy when the cache is populated
+// and the most recent resync succeeded.
+func (b *Backend) IsHealthy(context.Context) bool {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	return b.synced && b.lastErr == nil
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	i, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return i.ec2, nil
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	i, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return hack.Instance{}, hack.ErrInstanceNotFound
+		}
+
+		return hack.Instance{}, err
+	}
+
+	return i.hack, nil
+}
+
+func (b *Backend) retrieveByIP(ctx context.Context, ip string) (*instance, error) {
+	normalized, err := ipaddr.Normalize(ip)
+	if err != nil {
+		return nil, errNotFound
+	}
+
+	b.mtx.RLock()
+	entries := b.byIP[normalized]
+	b.mtx.RUnlock()
+
+	switch len(entries) {
+	case 0:
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("%w: ip %v", errMultipleHardware, normalized)
+	}
+
+	// The hardware may have been created since the cache was last resynchronized so we ask the
+	// Tink server directly.
+	var hw hardware
+	err = b.conn.Invoke(ctx, methodByIP, &getRequest{IP: normalized}, &hw)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return nil, errNotFound
+	case err != nil:
+		return nil, fmt.Errorf("retrieve hardware by ip: %w", err)
+	case hw.ID == "":
+		// Legacy Tink servers respond with empty hardware when none is found.
+		return nil, errNotFound
+	}
+
+	i, err := toInstance(&hw)
+	if err != nil {
+		return nil, err
+	}
+
+	b.set(i)
+	b.watch(i.id)
+
+	return i, nil
+}
+
+// resync replaces the cache with all hardware from the Tink server.
+func (b *Backend) resync(ctx context.Context) error {
+	stream, err := b.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, methodAll)
+	if err != nil {
+		return b.recordSync(fmt.Errorf("list hardware: %w", err))
+	}
+
+	if err := stream.SendMsg(&empty{}); err != nil {
+		return b.recordSync(fmt.Errorf("list hardware: %w", err))
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return b.recordSync(fmt.Errorf("list hardware: %w", err))
+	}
+
+	byID := map[string]*instance{}
+	for {
+		var hw hardware
+		err := stream.RecvMsg(&hw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return b.recordSync(fmt.Errorf("list hardware: %w", err))
+		}
+
+		i, err := toInstance(&hw)
+		if err != nil {
+			// Skip malformed hardware so it doesn't prevent serving other hardware.
+			b.logger.Error(err, "Skipping hardware")
+			continue
+		}
+		byID[i.id] = i
+	}
+
+	byIP := map[string][]*instance{}
+	for _, i := range byID {
+		for _, ip := range i.ips {
+			byIP[ip] = append(byIP[ip], i)
+		}
+	}
+
+	b.mtx.Lock()
+	now := time.Now()
+	for _, i := range byID {
+		b.observeLocked(i, now)
+	}
+	b.byID = byID
+	b.byIP = byIP
+	for id, w := range b.watches {
+		if _, ok := byID[id]; !ok {
+			w.cancel()
+			delete(b.watches, id)
+		}
+	}
+	b.mtx.Unlock()
+
+	b.readyOnce.Do(func() { close(b.ready) })
+
+	return b.recordSync(nil)
+}
+
+func (b *Backend) recordSync(err error) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.lastErr = err
+	if err == nil {
+		b.synced = true
+	}
+
+	return err
+}
+
+// set adds or replaces i in the cache.
+func (b *Backend) set(i *instance) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.observeLocked(i, time.Now())
+	b.removeLocked(i.id)
+	b.byID[i.id] = i
+	for _, ip := range i.ips {
+		b.byIP[ip] = append(b.byIP[ip], i)
+	}
+}
+
+// observeLocked sets when i changed. Tink doesn't record when hardware changes so it's when the
+// Backend first observed i's version. Hardware observed by the initial sync may have changed at
+// any time, and has a zero time. b.mtx must be held.
+func (b *Backend) observeLocked(i *instance, now time.Time) {
+	if existing, ok := b.byID[i.id]; ok && existing.version == i.version {
+		i.ec2.Changed = existing.ec2.Changed
+		return
+	}
+	if b.synced {
+		i.ec2.Changed = now
+	}
+}
+
+// removeLocked removes the instance with id from the cache. b.mtx must be held.
+func (b *Backend) removeLocked(id string) {
+	existing, ok := b.byID[id]
+	if !ok {
+		return
+	}
+
+	delete(b.byID, id)
+	for _, ip := range existing.ips {
+		entries := b.byIP[ip]
+		for idx, e := range entries {
+			if e == existing {
+				entries = append(entries[:idx:idx], entries[idx+1:]...)
+				break
+			}
+		}
+		if len(entries) == 0 {
+			delete(b.byIP, ip)
+		} else {
+			b.byIP[ip] = entries
+		}
+	}
+}
+
+// watch keeps the hardware identified by id up to date until it is removed from the cache, the
+// watch fails or the Backend stops. It does nothing if the Backend isn't running, the hardware
+// is already watched or the maximum number of watches has been reached.
+func (b *Backend) watch(id string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.runCtx == nil || b.runCtx.Err() != nil || len(b.watches) >= b.maxWatches {
+		return
+	}
+
+	if _, ok := b.watches[id]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(b.runCtx)
+	w := &hardwareWatch{cancel: cancel, done: make(chan struct{})}
+	b.watches[id] = w
+
+	go func() {
+		defer func() {
+			// A resync may have removed this watch and a newer one been registered for the
+			// same id, which must be left running.
+			b.mtx.Lock()
+			if b.watches[id] == w {
+				delete(b.watches, id)
+			}
+			b.mtx.Unlock()
+			cancel()
+			close(w.done)
+		}()
+
+		if err := b.runWatch(ctx, id); err != nil && ctx.Err() == nil {
+			b.logger.Info("Hardware watch ended", "id", id, "error", err.Error())
+		}
+	}()
+}
+
+func (b *Backend) runWatch(ctx context.Context, id string) error {
+	stream, err := b.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, methodDeprecatedWatch)
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(&getRequest{ID: id}); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var hw hardware
+		if err := stream.RecvMsg(&hw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		// Ignore updates for other hardware.
+		if hw.ID != id {
+			continue
+		}
+
+		i, err := toInstance(&hw)
+		if err != nil {
+			b.logger.Error(err, "Skipping hardware update")
+			continue
+		}
+
+		b.set(i)
+	}
+}
@@ -0,0 +1,497 @@
+package tinkgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const testMetadata = `{
+	"state": "provisioning",
+	"facility": {"plan_slug": "c3.small", "facility_code": "dc1"},
+	"instance": {
+		"id": "instance-1",
+		"hostname": "machine1",
+		"userdata": "#cloud-config",
+		"tags": ["foo"],
+		"ssh_keys": ["ssh-ed25519 AAAA"],
+		"operating_system": {"slug": "ubuntu_22_04", "distro": "ubuntu", "version": "22.04", "image_tag": "latest"},
+		"ips": [
+			{"address": "10.0.0.1", "family": 4},
+			{"address": "203.0.113.1", "family": 4, "public": true},
+			{"address": "2001:db8::1", "family": 6, "public": true}
+		],
+		"storage": {
+			"disks": [{"device": "/dev/sda", "wipe_table": true, "partitions": [{"label": "ROOT", "number": 1, "size": 0}]}],
+			"filesystems": [{"mount": {"device": "/dev/sda1", "format": "ext4", "point": "/", "create": {"options": ["-L", "ROOT"]}}}]
+		}
+	}
+}`
+
+func newTestHardware(id, dhcpIP, metadata string) *hardware {
+	return &hardware{
+		ID:       id,
+		Version:  1,
+		Metadata: metadata,
+		Network: network{Interfaces: []networkInterface{
+			{DHCP: dhcp{MAC: "00:00:00:00:00:01", IP: ip{Address: dhcpIP}}},
+		}},
+	}
+}
+
+// fakeTinkServer is an in-process stand-in for the legacy HardwareService.
+type fakeTinkServer struct {
+	mtx       sync.Mutex
+	hardware  []*hardware
+	byIPCalls int
+	updates   map[string]chan *hardware
+
+	// allFailures is the number of subsequent All calls that fail.
+	allFailures int
+}
+
+func (s *fakeTinkServer) setHardware(hw ...*hardware) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.hardware = hw
+}
+
+func (s *fakeTinkServer) watcher(id string) chan *hardware {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.updates == nil {
+		s.updates = map[string]chan *hardware{}
+	}
+	if _, ok := s.updates[id]; !ok {
+		s.updates[id] = make(chan *hardware, 1)
+	}
+	return s.updates[id]
+}
+
+func (s *fakeTinkServer) serviceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "ByIP",
+			Handler: func(_ any, _ context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				var req getRequest
+				if err := dec(&req); err != nil {
+					return nil, err
+				}
+
+				s.mtx.Lock()
+				defer s.mtx.Unlock()
+				s.byIPCalls++
+
+				for _, hw := range s.hardware {
+					for _, iface := range hw.Network.Interfaces {
+						if iface.DHCP.IP.Address == req.IP {
+							return hw, nil
+						}
+					}
+				}
+
+				// Legacy servers return empty hardware when none is found.
+				return &hardware{}, nil
+			},
+		}},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "All",
+				ServerStreams: true,
+				Handler: func(_ any, stream grpc.ServerStream) error {
+					var req empty
+					if err := stream.RecvMsg(&req); err != nil {
+						return err
+					}
+
+					s.mtx.Lock()
+					hardware := s.hardware
+					fail := s.allFailures > 0
+					if fail {
+						s.allFailures--
+					}
+					s.mtx.Unlock()
+
+					if fail {
+						return errors.New("unavailable")
+					}
+
+					for _, hw := range hardware {
+						if err := stream.SendMsg(hw); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			{
+				StreamName:    "DeprecatedWatch",
+				ServerStreams: true,
+				Handler: func(_ any, stream grpc.ServerStream) error {
+					var req getRequest
+					if err := stream.RecvMsg(&req); err != nil {
+						return err
+					}
+
+					updates := s.watcher(req.ID)
+					for {
+						select {
+						case <-stream.Context().Done():
+							return nil
+						case hw := <-updates:
+							if err := stream.SendMsg(hw); err != nil {
+								return err
+							}
+						}
+					}
+				},
+			},
+		},
+	}
+}
+
+// newTestBackend starts srv in-process and returns a running Backend connected to it.
+func newTestBackend(t *testing.T, srv *fakeTinkServer) *Backend {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ForceServerCodec(codec{}))
+	server.RegisterService(srv.serviceDesc(), srv)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	backend, err := NewBackend(Config{
+		Address:  "passthrough:///bufnet",
+		Insecure: true,
+		DialOptions: []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backend
+}
+
+func startBackend(t *testing.T, backend *Backend) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = backend.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if !backend.WaitForReady(waitCtx) {
+		t.Fatal("Backend failed to become ready")
+	}
+
+	return ctx
+}
+
+func TestBackendLookups(t *testing.T) {
+	srv := &fakeTinkServer{}
+	srv.setHardware(newTestHardware("hw-1", "10.0.0.1", testMetadata))
+
+	backend := newTestBackend(t, srv)
+	if backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected unhealthy before the cache is populated")
+	}
+
+	startBackend(t, backend)
+
+	if !backend.IsHealthy(context.Background()) {
+		t.Fatal("Expected healthy once the cache is populated")
+	}
+
+	var expectEC2 ec2.Instance
+	expectEC2.Userdata = "#cloud-config"
+	expectEC2.Metadata.InstanceID = "instance-1"
+	expectEC2.Metadata.Hostname = "machine1"
+	expectEC2.Metadata.LocalHostname = "machine1"
+	expectEC2.Metadata.Plan = "c3.small"
+	expectEC2.Metadata.Facility = "dc1"
+	expectEC2.Metadata.Tags = []string{"foo"}
+	expectEC2.Metadata.PublicKeys = []string{"ssh-ed25519 AAAA"}
+	expectEC2.Metadata.LocalIPv4 = "10.0.0.1"
+	expectEC2.Metadata.PublicIPv4 = "203.0.113.1"
+	expectEC2.Metadata.PublicIPv6 = "2001:db8::1"
+	expectEC2.Metadata.OperatingSystem.Slug = "ubuntu_22_04"
+	expectEC2.Metadata.OperatingSystem.Distro = "ubuntu"
+	expectEC2.Metadata.OperatingSystem.Version = "22.04"
+	expectEC2.Metadata.OperatingSystem.ImageTag = "latest"
+	expectEC2.State = "provisioning"
+	expectEC2.Revision = "1"
+
+	// The hardware is retrievable by both its DHCP and metadata addresses.
+	for _, addr := range []string{"10.0.0.1", "::ffff:10.0.0.1", "203.0.113.1", "2001:db8::1"} {
+		instance, err := backend.GetEC2Instance(context.Background(), addr)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", addr, err)
+		}
+		if diff := cmp.Diff(expectEC2, instance); diff != "" {
+			t.Fatalf("%v: %v", addr, diff)
+		}
+	}
+
+	hackInstance, err := backend.GetHackInstance(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var fs hack.Filesystem
+	fs.Mount.Device = "/dev/sda1"
+	fs.Mount.Format = "ext4"
+	fs.Mount.Point = "/"
+	fs.Mount.Create.Options = []string{"-L", "ROOT"}
+	expectStorage := hack.Storage{
+		Disks: []hack.Disk{{
+			Device:     "/dev/sda",
+			WipeTable:  true,
+			Partitions: []hack.Partition{{Label: "ROOT", Number: 1}},
+		}},
+		Filesystems: []hack.Filesystem{fs},
+	}
+	if diff := cmp.Diff(expectStorage, hackInstance.Metadata.Instance.Storage); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if srv.byIPCalls != 0 {
+		t.Fatalf("Expected cached lookups; Received %v ByIP calls", srv.byIPCalls)
+	}
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.99"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+	if _, err := backend.GetHackInstance(context.Background(), "10.0.0.99"); !errors.Is(err, hack.ErrInstanceNotFound) {
+		t.Fatalf("Expected hack.ErrInstanceNotFound; Received %v", err)
+	}
+}
+
+func TestBackendCacheMissAndWatch(t *testing.T) {
+	srv := &fakeTinkServer{}
+	backend := newTestBackend(t, srv)
+	ctx := startBackend(t, backend)
+
+	// Hardware created after the cache was populated is retrieved from the server.
+	srv.setHardware(newTestHardware("hw-1", "10.0.0.1", `{"instance": {"hostname": "original"}}`))
+
+	instance, err := backend.GetEC2Instance(ctx, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if instance.Metadata.Hostname != "original" {
+		t.Fatalf("Expected hostname 'original'; Received %q", instance.Metadata.Hostname)
+	}
+
+	// Subsequent changes are received via the watch.
+	srv.watcher("hw-1") <- newTestHardware("hw-1", "10.0.0.1", `{"instance": {"hostname": "updated"}}`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		instance, err := backend.GetEC2Instance(ctx, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if instance.Metadata.Hostname == "updated" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for watch update")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if srv.byIPCalls != 1 {
+		t.Fatalf("Expected 1 ByIP call; Received %v", srv.byIPCalls)
+	}
+
+	// Resynchronizing removes deleted hardware and stops its watch.
+	srv.setHardware()
+	if err := backend.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.GetEC2Instance(ctx, "10.0.0.1"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected ec2.ErrInstanceNotFound; Received %v", err)
+	}
+
+	backend.mtx.RLock()
+	watches := len(backend.watches)
+	backend.mtx.RUnlock()
+	if watches != 0 {
+		t.Fatalf("Expected no watches; Received %v", watches)
+	}
+}
+
+func TestBackendChanged(t *testing.T) {
+	srv := &fakeTinkServer{}
+	hw := newTestHardware("hw-1", "10.0.0.1", "")
+	srv.setHardware(hw)
+
+	backend := newTestBackend(t, srv)
+	ctx := startBackend(t, backend)
+
+	changed := func() time.Time {
+		t.Helper()
+		instance, err := backend.GetEC2Instance(ctx, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return instance.Changed
+	}
+
+	// Hardware observed by the initial sync may have changed at any time.
+	if received := changed(); !received.IsZero() {
+		t.Fatalf("Expected zero changed time; Received %v", received)
+	}
+
+	// A new version is observed as changed when it's synchronized.
+	updated := newTestHardware("hw-1", "10.0.0.1", "")
+	updated.Version = 2
+	srv.setHardware(updated)
+
+	before := time.Now()
+	if err := backend.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	observed := changed()
+	if observed.Before(before) || observed.After(time.Now()) {
+		t.Fatalf("Expected changed time after %v; Received %v", before, observed)
+	}
+
+	// Resynchronizing the same version retains the time it was observed.
+	if err := backend.resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if received := changed(); !received.Equal(observed) {
+		t.Fatalf("Expected changed time %v; Received %v", observed, received)
+	}
+}
+
+func TestBackendRetriesInitialSync(t *testing.T) {
+	srv := &fakeTinkServer{allFailures: 3}
+	srv.setHardware(newTestHardware("hw-1", "10.0.0.1", ""))
+
+	backend := newTestBackend(t, srv)
+	backend.syncBackoff = 10 * time.Millisecond
+
+	// The resync interval is 5m so the backend is only ready in time if the failed syncs are
+	// retried sooner.
+	startBackend(t, backend)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestBackendWatchReplaced(t *testing.T) {
+	srv := &fakeTinkServer{}
+	srv.setHardware(newTestHardware("hw-1", "10.0.0.1", ""))
+
+	backend := newTestBackend(t, srv)
+	startBackend(t, backend)
+
+	backend.watch("hw-1")
+
+	// Replace the watch as a resync removing the hardware followed by a lookup re-adding it would.
+	backend.mtx.Lock()
+	old := backend.watches["hw-1"]
+	replacement := &hardwareWatch{cancel: func() {}, done: make(chan struct{})}
+	backend.watches["hw-1"] = replacement
+	backend.mtx.Unlock()
+
+	old.cancel()
+	<-old.done
+
+	backend.mtx.RLock()
+	received := backend.watches["hw-1"]
+	backend.mtx.RUnlock()
+	if received != replacement {
+		t.Fatal("Expected the ended watch to leave its replacement registered")
+	}
+}
+
+func TestBackendMultipleHardware(t *testing.T) {
+	srv := &fakeTinkServer{}
+	srv.setHardware(
+		newTestHardware("hw-1", "10.0.0.1", ""),
+		newTestHardware("hw-2", "10.0.0.1", ""),
+	)
+
+	backend := newTestBackend(t, srv)
+	startBackend(t, backend)
+
+	if _, err := backend.GetEC2Instance(context.Background(), "10.0.0.1"); !errors.Is(err, errMultipleHardware) {
+		t.Fatalf("Expected errMultipleHardware; Received %v", err)
+	}
+}
+
+func TestHardwareMessage(t *testing.T) {
+	hw := newTestHardware("hw-1", "10.0.0.1", testMetadata)
+	hw.Network.Interfaces[0].DHCP.IP.Netmask = "255.255.255.0"
+	hw.Network.Interfaces[0].DHCP.IP.Gateway = "10.0.0.254"
+	hw.Network.Interfaces[0].DHCP.Hostname = "machine1"
+
+	encoded := hw.marshal()
+
+	// Fields unknown to Hegel, such as netboot settings, must be skipped.
+	encoded = protowire.AppendTag(encoded, 99, protowire.Fixed64Type)
+	encoded = protowire.AppendFixed64(encoded, 1)
+	encoded = protowire.AppendTag(encoded, 100, protowire.VarintType)
+	encoded = protowire.AppendVarint(encoded, 1)
+
+	var decoded hardware
+	if err := decoded.unmarshal(encoded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(*hw, decoded); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := decoded.unmarshal([]byte{0xff}); err == nil {
+		t.Fatal("Expected error decoding malformed message")
+	}
+}
+
+func TestNewBackendErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Config Config
+	}{
+		{Name: "MissingAddress"},
+		{Name: "MissingCAFile", Config: Config{Address: "tink:42113", CAFile: "missing.pem"}},
+		{Name: "MissingKeyFile", Config: Config{Address: "tink:42113", CertFile: "cert.pem"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := NewBackend(tc.Config); err == nil {
+				t.Fatal("Expected error; Received nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package tinkgrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/tinkerbell/hegel/internal/backend/internal/ipaddr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+// legacyMetadata is the subset of the legacy hardware metadata JSON document that Hegel serves.
+type legacyMetadata struct {
+	State    string `json:"state"`
+	Facility struct {
+		PlanSlug     string `json:"plan_slug"`
+		FacilityCode string `json:"facility_code"`
+	} `json:"facility"`
+	Instance struct {
+		ID              string   `json:"id"`
+		Hostname        string   `json:"hostname"`
+		Userdata        string   `json:"userdata"`
+		Tags            []string `json:"tags"`
+		SSHKeys         []string `json:"ssh_keys"`
+		OperatingSystem struct {
+			Slug     string `json:"slug"`
+			Distro   string `json:"distro"`
+			Version  string `json:"version"`
+			ImageTag string `json:"image_tag"`
+		} `json:"operating_system"`
+		IPs []struct {
+			Address string `json:"address"`
+			Family  int    `json:"family"`
+			Public  bool   `json:"public"`
+		} `json:"ips"`
+	} `json:"instance"`
+}
+
+// instance holds the frontend models for a legacy hardware.
+type instance struct {
+	id      string
+	version int64
+	ips     []string
+	ec2     ec2.Instance
+	hack    hack.Instance
+}
+
+// toInstance maps hw to frontend models.
+func toInstance(hw *hardware) (*instance, error) {
+	i := &instance{id: hw.ID, version: hw.Version}
+
+	var md legacyMetadata
+	if hw.Metadata != "" {
+		if err := json.Unmarshal([]byte(hw.Metadata), &md); err != nil {
+			return nil, fmt.Errorf("hardware %v: decode metadata: %v", hw.ID, err)
+		}
+
+		// The hack instance is the metadata document nested under a metadata key.
+		wrapped := []byte(`{"metadata":` + hw.Metadata + `}`)
+		if err := json.Unmarshal(wrapped, &i.hack); err != nil {
+			return nil, fmt.Errorf("hardware %v: decode storage: %v", hw.ID, err)
+		}
+	}
+
+	i.ec2 = toEC2Instance(md)
+	i.ec2.Revision = strconv.FormatInt(hw.Version, 10)
+	i.ips = addresses(hw, md)
+
+	return i, nil
+}
+
+func toEC2Instance(md legacyMetadata) ec2.Instance {
+	var i ec2.Instance
+
+	i.Userdata = md.Instance.Userdata
+	i.State = md.State
+	i.Metadata.InstanceID = md.Instance.ID
+	i.Metadata.Hostname = md.Instance.Hostname
+	i.Metadata.LocalHostname = md.Instance.Hostname
+	i.Metadata.Tags = md.Instance.Tags
+	i.Metadata.PublicKeys = md.Instance.SSHKeys
+	i.Metadata.Plan = md.Facility.PlanSlug
+	i.Metadata.Facility = md.Facility.FacilityCode
+	i.Metadata.OperatingSystem.Slug = md.Instance.OperatingSystem.Slug
+	i.Metadata.OperatingSystem.Distro = md.Instance.OperatingSystem.Distro
+	i.Metadata.OperatingSystem.Version = md.Instance.OperatingSystem.Version
+	i.Metadata.OperatingSystem.ImageTag = md.Instance.OperatingSystem.ImageTag
+
+	// Use the first IP of each family and visibility as the instance addresses.
+	for _, ip := range md.Instance.IPs {
+		if ip.Family == 4 && ip.Public && i.Metadata.PublicIPv4 == "" {
+			i.Metadata.PublicIPv4 = ip.Address
+		}
+
+		if ip.Family == 4 && !ip.Public && i.Metadata.LocalIPv4 == "" {
+			i.Metadata.LocalIPv4 = ip.Address
+		}
+
+		if ip.Family == 6 && i.Metadata.PublicIPv6 == "" {
+			i.Metadata.PublicIPv6 = ip.Address
+		}
+	}
+
+	return i
+}
+
+// addresses returns the normalized, de-duplicated addresses hw can be looked up by.
+func addresses(hw *hardware, md legacyMetadata) []string {
+	var candidates []string
+	for _, iface := range hw.Network.Interfaces {
+		candidates = append(candidates, iface.DHCP.IP.Address)
+	}
+	for _, ip := range md.Instance.IPs {
+		candidates = append(candidates, ip.Address)
+	}
+
+	seen := map[string]bool{}
+	var ips []string
+	for _, c := range candidates {
+		normalized, err := ipaddr.Normalize(c)
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		ips = append(ips, normalized)
+	}
+
+	return ips
+}
@@ -0,0 +1,283 @@
+package tinkgrpc
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Tink no longer publishes the legacy hardware protobuf package so the subset of messages Hegel
+// uses are encoded by hand. Field numbers must match
+// github.com/tinkerbell/tink/protos/hardware/hardware.proto prior to its removal. Unknown fields
+// are ignored.
+
+// Fully qualified HardwareService method names.
+const (
+	serviceName           = "github.com.tinkerbell.tink.protos.hardware.HardwareService"
+	methodByIP            = "/" + serviceName + "/ByIP"
+	methodAll             = "/" + serviceName + "/All"
+	methodDeprecatedWatch = "/" + serviceName + "/DeprecatedWatch"
+)
+
+// message is implemented by the hand encoded messages.
+type message interface {
+	marshal() []byte
+	unmarshal([]byte) error
+}
+
+// codec is a google.golang.org/grpc/encoding.Codec for message types.
+type codec struct{}
+
+func (codec) Name() string { return "proto" }
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type: %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("unsupported message type: %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+// empty is the Empty message.
+type empty struct{}
+
+func (*empty) marshal() []byte          { return nil }
+func (*empty) unmarshal(b []byte) error { return consumeFields(b, nil) }
+
+// getRequest is the GetRequest message.
+type getRequest struct {
+	MAC string // 1
+	IP  string // 2
+	ID  string // 3
+}
+
+func (r *getRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.MAC)
+	b = appendString(b, 2, r.IP)
+	b = appendString(b, 3, r.ID)
+	return b
+}
+
+func (r *getRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			r.MAC = string(v)
+		case 2:
+			r.IP = string(v)
+		case 3:
+			r.ID = string(v)
+		}
+		return nil
+	})
+}
+
+// hardware is the Hardware message. Metadata is a JSON document; see legacyMetadata.
+type hardware struct {
+	Network  network // 1
+	ID       string  // 2
+	Version  int64   // 3
+	Metadata string  // 4
+}
+
+func (h *hardware) marshal() []byte {
+	var b []byte
+	if network := h.Network.marshal(); len(network) > 0 {
+		b = appendBytes(b, 1, network)
+	}
+	b = appendString(b, 2, h.ID)
+	if h.Version != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Version))
+	}
+	b = appendString(b, 4, h.Metadata)
+	return b
+}
+
+func (h *hardware) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return h.Network.unmarshal(v)
+		case num == 2 && typ == protowire.BytesType:
+			h.ID = string(v)
+		case num == 3 && typ == protowire.VarintType:
+			n, _ := protowire.ConsumeVarint(v)
+			h.Version = int64(n)
+		case num == 4 && typ == protowire.BytesType:
+			h.Metadata = string(v)
+		}
+		return nil
+	})
+}
+
+// network is the Hardware.Network message.
+type network struct {
+	Interfaces []networkInterface // 2
+}
+
+func (n *network) marshal() []byte {
+	var b []byte
+	for _, iface := range n.Interfaces {
+		b = appendBytes(b, 2, iface.marshal())
+	}
+	return b
+}
+
+func (n *network) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num == 2 && typ == protowire.BytesType {
+			var iface networkInterface
+			if err := iface.unmarshal(v); err != nil {
+				return err
+			}
+			n.Interfaces = append(n.Interfaces, iface)
+		}
+		return nil
+	})
+}
+
+// networkInterface is the Hardware.Network.Interface message.
+type networkInterface struct {
+	DHCP dhcp // 1
+}
+
+func (i *networkInterface) marshal() []byte {
+	return appendBytes(nil, 1, i.DHCP.marshal())
+}
+
+func (i *networkInterface) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num == 1 && typ == protowire.BytesType {
+			return i.DHCP.unmarshal(v)
+		}
+		return nil
+	})
+}
+
+// dhcp is the Hardware.DHCP message.
+type dhcp struct {
+	MAC      string // 1
+	Hostname string // 2
+	IP       ip     // 10
+}
+
+func (d *dhcp) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, d.MAC)
+	b = appendString(b, 2, d.Hostname)
+	if ip := d.IP.marshal(); len(ip) > 0 {
+		b = appendBytes(b, 10, ip)
+	}
+	return b
+}
+
+func (d *dhcp) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			d.MAC = string(v)
+		case num == 2 && typ == protowire.BytesType:
+			d.Hostname = string(v)
+		case num == 10 && typ == protowire.BytesType:
+			return d.IP.unmarshal(v)
+		}
+		return nil
+	})
+}
+
+// ip is the Hardware.IP message.
+type ip struct {
+	Address string // 1
+	Netmask string // 2
+	Gateway string // 3
+}
+
+func (i *ip) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, i.Address)
+	b = appendString(b, 2, i.Netmask)
+	b = appendString(b, 3, i.Gateway)
+	return b
+}
+
+func (i *ip) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			i.Address = string(v)
+		case 2:
+			i.Netmask = string(v)
+		case 3:
+			i.Gateway = string(v)
+		}
+		return nil
+	})
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// consumeFields iterates the fields of a message calling fn for each. For bytes fields v is the
+// field's content. For varint fields v is the encoded varint. Other field types are skipped. fn
+// may be nil.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			var m int
+			v, m = protowire.ConsumeBytes(b)
+			n = m
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errMalformed
+		}
+		b = b[n:]
+
+		if fn != nil && v != nil {
+			if err := fn(num, typ, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/equinix-labs/otel-init-go/otelinit"
 	"github.com/gin-gonic/gin"
@@ -16,14 +20,22 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tinkerbell/hegel/internal/audit"
 	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/backend/cache"
+	"github.com/tinkerbell/hegel/internal/backend/crd"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	"github.com/tinkerbell/hegel/internal/backend/rest"
+	"github.com/tinkerbell/hegel/internal/backend/sqldb"
+	"github.com/tinkerbell/hegel/internal/backend/tinkgrpc"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
 	"github.com/tinkerbell/hegel/internal/healthcheck"
 	hegelhttp "github.com/tinkerbell/hegel/internal/http"
 	hegellogger "github.com/tinkerbell/hegel/internal/logger"
 	"github.com/tinkerbell/hegel/internal/metrics"
+	"github.com/tinkerbell/hegel/internal/policy"
 	"github.com/tinkerbell/hegel/internal/xff"
 )
 
@@ -44,14 +56,66 @@ const EnvNamePrefix = "HEGEL"
 
 // RootCommandOptions encompasses all the configurability of the RootCommand.
 type RootCommandOptions struct {
-	TrustedProxies       string `mapstructure:"trusted-proxies"`
-	HTTPAddr             string `mapstructure:"http-addr"`
-	Backend              string `mapstructure:"backend"`
-	KubernetesAPIServer  string `mapstructure:"kubernetes-apiserver"`
-	KubernetesKubeconfig string `mapstructure:"kubernetes-kubeconfig"`
-	KubernetesNamespace  string `mapstructure:"kubernetes-namespace"`
-	FlatfilePath         string `mapstructure:"flatfile-path"`
-	Debug                bool   `mapstructure:"debug"`
+	TrustedProxies                 string        `mapstructure:"trusted-proxies"`
+	HTTPAddr                       string        `mapstructure:"http-addr"`
+	Backend                        string        `mapstructure:"backend"`
+	BackendRoutes                  []string      `mapstructure:"backend-routes"`
+	KubernetesAPIServer            string        `mapstructure:"kubernetes-apiserver"`
+	KubernetesKubeconfig           string        `mapstructure:"kubernetes-kubeconfig"`
+	KubernetesContexts             []string      `mapstructure:"kubernetes-contexts"`
+	KubernetesNamespace            string        `mapstructure:"kubernetes-namespace"`
+	KubernetesNamespaces           []string      `mapstructure:"kubernetes-namespaces"`
+	KubernetesLabelSelector        string        `mapstructure:"kubernetes-label-selector"`
+	KubernetesIPSources            []string      `mapstructure:"kubernetes-ip-sources"`
+	KubernetesIPConflictPolicy     string        `mapstructure:"kubernetes-ip-conflict-policy"`
+	KubernetesStaleTolerance       time.Duration `mapstructure:"kubernetes-stale-tolerance"`
+	KubernetesSnapshotPath         string        `mapstructure:"kubernetes-snapshot-path"`
+	KubernetesSnapshotInterval     time.Duration `mapstructure:"kubernetes-snapshot-interval"`
+	KubernetesPrecompute           bool          `mapstructure:"kubernetes-precompute"`
+	KubernetesLazyUserdata         bool          `mapstructure:"kubernetes-lazy-userdata"`
+	KubernetesUserdataCacheSize    int           `mapstructure:"kubernetes-userdata-cache-size"`
+	KubernetesRecordAccess         bool          `mapstructure:"kubernetes-record-access"`
+	KubernetesRecordAccessInterval time.Duration `mapstructure:"kubernetes-record-access-interval"`
+	KubernetesForwardEvents        bool          `mapstructure:"kubernetes-forward-events"`
+	RescueUserdata                 string        `mapstructure:"rescue-userdata"`
+	RescueVendordata               string        `mapstructure:"rescue-vendordata"`
+	FlatfilePath                   string        `mapstructure:"flatfile-path"`
+	RESTURL                        string        `mapstructure:"rest-url"`
+	RESTHeaders                    []string      `mapstructure:"rest-headers"`
+	RESTBearerTokenFile            string        `mapstructure:"rest-bearer-token-file"`
+	RESTCAFile                     string        `mapstructure:"rest-ca-file"`
+	RESTCertFile                   string        `mapstructure:"rest-cert-file"`
+	RESTKeyFile                    string        `mapstructure:"rest-key-file"`
+	RESTTimeout                    time.Duration `mapstructure:"rest-timeout"`
+	RESTRetries                    int           `mapstructure:"rest-retries"`
+	RESTBreakerThreshold           int           `mapstructure:"rest-breaker-threshold"`
+	RESTBreakerCooldown            time.Duration `mapstructure:"rest-breaker-cooldown"`
+	TinkServerAddress              string        `mapstructure:"tink-server-address"`
+	TinkServerInsecure             bool          `mapstructure:"tink-server-insecure"`
+	TinkServerCAFile               string        `mapstructure:"tink-server-ca-file"`
+	TinkServerCertFile             string        `mapstructure:"tink-server-cert-file"`
+	TinkServerKeyFile              string        `mapstructure:"tink-server-key-file"`
+	TinkServerName                 string        `mapstructure:"tink-server-name"`
+	TinkResyncInterval             time.Duration `mapstructure:"tink-resync-interval"`
+	SQLDriver                      string        `mapstructure:"sql-driver"`
+	SQLDSN                         string        `mapstructure:"sql-dsn"`
+	SQLPollInterval                time.Duration `mapstructure:"sql-poll-interval"`
+	SQLListen                      bool          `mapstructure:"sql-listen"`
+	SQLMigrate                     bool          `mapstructure:"sql-migrate"`
+	CRDMappingFile                 string        `mapstructure:"crd-mapping-file"`
+	PhoneHome                      bool          `mapstructure:"phone-home"`
+	PhoneHomeEventsPerInstance     int           `mapstructure:"phone-home-events-per-instance"`
+	PhoneHomeMaxInstances          int           `mapstructure:"phone-home-max-instances"`
+	AdminAddr                      string        `mapstructure:"admin-addr"`
+	UserdataStates                 []string      `mapstructure:"userdata-states"`
+	UserdataWindow                 time.Duration `mapstructure:"userdata-window"`
+	UserdataMaxReads               int           `mapstructure:"userdata-max-reads"`
+	AuditLog                       string        `mapstructure:"audit-log"`
+	AuditLogMaxSize                int           `mapstructure:"audit-log-max-size"`
+	AuditLogMaxBackups             int           `mapstructure:"audit-log-max-backups"`
+	CacheTTL                       time.Duration `mapstructure:"cache-ttl"`
+	CacheNegativeTTL               time.Duration `mapstructure:"cache-negative-ttl"`
+	Debug                          bool          `mapstructure:"debug"`
 
 	// Hidden CLI flags.
 	HegelAPI bool `mapstructure:"hegel-api"`
@@ -108,7 +172,16 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 	ctx, otelShutdown := otelinit.InitOpenTelemetry(cmd.Context(), "hegel")
 	defer otelShutdown(ctx)
 
-	be, err := backend.New(ctx, toBackendOptions(c.Opts))
+	registry := prometheus.NewRegistry()
+
+	backendOpts, err := toBackendOptions(c.Opts)
+	if err != nil {
+		return err
+	}
+	backendOpts.Registerer = registry
+	backendOpts.Logger = logger
+
+	be, err := backend.New(ctx, backendOpts)
 	if err != nil {
 		return errors.Errorf("initialize backend: %v", err)
 	}
@@ -118,8 +191,6 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	registry := prometheus.NewRegistry()
-
 	router := gin.New()
 	router.Use(
 		metrics.InstrumentRequestCount(registry),
@@ -129,20 +200,175 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 		xffmw,
 	)
 
+	// The HTTP server starts before the backend is ready so health and metrics are available
+	// during startup. Metadata routes are rejected until the backend is ready.
+	var readiness healthcheck.Readiness
+
 	metrics.Configure(router, registry)
 	healthcheck.Configure(router, be)
+	healthcheck.ConfigureProbes(router, &readiness, be)
+
+	metadataRouter := router.Group("", healthcheck.RequireReady(&readiness, healthcheck.DefaultRetryAfter))
+	if source, ok := be.(healthcheck.DataAgeSource); ok {
+		metadataRouter.Use(healthcheck.DataAge(source))
+	}
+
+	// Frontends may use a caching decorator so lifecycle and health behaviors must continue to
+	// use be directly.
+	var frontendClient cache.Client = be
+	if c.Opts.CacheTTL > 0 {
+		frontendClient, err = cache.New(be, cache.Options{
+			TTL:         c.Opts.CacheTTL,
+			NegativeTTL: c.Opts.CacheNegativeTTL,
+			Registerer:  registry,
+		})
+		if err != nil {
+			return errors.Errorf("initialize backend cache: %v", err)
+		}
+	}
+
+	// Access and served metadata are recorded by the backend directly as the caching decorator
+	// doesn't record them.
+	var ec2Opts []ec2.Option
+	if recorder, ok := be.(ec2.AccessRecorder); ok {
+		ec2Opts = append(ec2Opts, ec2.WithAccessRecorder(recorder))
+	}
+
+	// Backends that retrieve userdata separately retrieve it directly so it isn't cached.
+	if userdata, ok := be.(ec2.UserdataClient); ok {
+		ec2Opts = append(ec2Opts, ec2.WithUserdataClient(userdata))
+	}
+
+	if rescue, ok := be.(ec2.RescueClient); ok {
+		ec2Opts = append(ec2Opts, ec2.WithRescueClient(rescue))
+	}
+
+	var hackOpts []hack.Option
+	if recorder, ok := be.(ec2.ServeRecorder); ok {
+		ec2Opts = append(ec2Opts, ec2.WithServeRecorder(recorder))
+		hackOpts = append(hackOpts, hack.WithServeRecorder(recorder))
+	}
+
+	userdataPolicy := policy.New(policy.Options{
+		States:   c.Opts.UserdataStates,
+		Window:   c.Opts.UserdataWindow,
+		MaxReads: c.Opts.UserdataMaxReads,
+		Logger:   logger,
+	})
+	if userdataPolicy.Enabled() {
+		ec2Opts = append(ec2Opts, ec2.WithUserdataPolicy(userdataPolicy))
+	}
+
+	switch c.Opts.AuditLog {
+	case "":
+	case "-":
+		ec2Opts = append(ec2Opts, ec2.WithAuditor(audit.New(os.Stdout, logger)))
+	default:
+		f, err := audit.OpenFile(
+			c.Opts.AuditLog,
+			int64(c.Opts.AuditLogMaxSize)*1024*1024,
+			c.Opts.AuditLogMaxBackups,
+		)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		ec2Opts = append(ec2Opts, ec2.WithAuditor(audit.New(f, logger)))
+	}
 
 	// TODO(chrisdoherty4) Handle multiple frontends.
-	fe := ec2.New(be)
-	fe.Configure(router)
+	fe := ec2.New(frontendClient, ec2Opts...)
+	fe.Configure(metadataRouter)
+
+	hack.Configure(metadataRouter, frontendClient, hackOpts...)
 
-	hack.Configure(router, be)
+	var bootLog *phonehome.Log
+	if c.Opts.PhoneHome {
+		bootLog = phonehome.NewLog(c.Opts.PhoneHomeEventsPerInstance, c.Opts.PhoneHomeMaxInstances)
+
+		var phOpts []phonehome.Option
+		if forwarder, ok := be.(phonehome.Forwarder); ok {
+			phOpts = append(phOpts, phonehome.WithForwarder(forwarder))
+		}
+		if identifier, ok := be.(phonehome.InstanceIdentifier); ok {
+			phOpts = append(phOpts, phonehome.WithInstanceIdentifier(identifier))
+		}
+		phonehome.New(frontendClient, bootLog, phOpts...).Configure(metadataRouter)
+	}
 
 	// Listen for signals to gracefully shutdown.
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	defer cancel()
 
-	return hegelhttp.Serve(ctx, logger, c.Opts.HTTPAddr, router)
+	startBackend := func(ctx context.Context) error {
+		if runner, ok := be.(backend.Runner); ok {
+			return runner.Start(ctx)
+		}
+		return nil
+	}
+
+	waitForReady := func(ctx context.Context) error {
+		if waiter, ok := be.(backend.ReadyWaiter); ok {
+			if !waiter.WaitForReady(ctx) {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("backend failed to become ready")
+			}
+		}
+		readiness.SetReady()
+		logger.Info("Backend ready")
+		return nil
+	}
+
+	serve := func(ctx context.Context) error {
+		return hegelhttp.Serve(ctx, logger, c.Opts.HTTPAddr, router)
+	}
+
+	fns := []func(context.Context) error{startBackend, waitForReady, serve}
+
+	// The admin API is served on a separate address so it needn't be reachable by instances.
+	if c.Opts.AdminAddr != "" {
+		adminRouter := gin.New()
+		adminRouter.Use(gin.Recovery(), hegellogger.Middleware(logger))
+		if bootLog != nil {
+			phonehome.ConfigureAdmin(adminRouter, bootLog)
+		}
+
+		fns = append(fns, func(ctx context.Context) error {
+			return hegelhttp.Serve(ctx, logger, c.Opts.AdminAddr, adminRouter)
+		})
+	}
+
+	return run(ctx, fns...)
+}
+
+// run launches each fn in its own goroutine and waits for all of them to return. When any fn
+// returns an error the context passed to all fns is cancelled. The first error is returned.
+func run(ctx context.Context, fns ...func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(fns))
+	for _, fn := range fns {
+		go func(fn func(context.Context) error) {
+			err := fn(ctx)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}(fn)
+	}
+
+	var first error
+	for range fns {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
 }
 
 func (c *RootCommand) configureFlags() error {
@@ -154,16 +380,197 @@ func (c *RootCommand) configureFlags() error {
 
 	c.Flags().String("http-addr", ":50061", "Port to listen on for HTTP requests")
 
-	c.Flags().String("backend", "kubernetes", "Backend to use for metadata. Options: flatfile, kubernetes")
+	c.Flags().String(
+		"backend",
+		"kubernetes",
+		"Comma separated list of backends to use for metadata queried in order. Options: flatfile, kubernetes, rest, tink, sql, crd",
+	)
+	c.Flags().StringSlice(
+		"backend-routes",
+		nil,
+		"Restrict a backend to lookups for IPs within a CIDR in the form <backend>=<cidr>; may be repeated",
+	)
 
 	// Kubernetes backend specific flags.
-	c.Flags().String("kubernetes-kubeconfig", "", "Path to a kubeconfig file")
-	c.Flags().String("kubernetes-apiserver", "", "URL of the Kubernetes API Server")
+	c.Flags().String("kubernetes-kubeconfig", "", "Path to a kubeconfig file; multiple files are merged like KUBECONFIG")
+	c.Flags().StringSlice(
+		"kubernetes-contexts",
+		nil,
+		"Kubeconfig contexts to serve Hardware from; multiple contexts are queried concurrently",
+	)
+	c.Flags().String("kubernetes-apiserver", "", "URL of the Kubernetes API Server; can't be used with multiple --kubernetes-contexts")
 	c.Flags().String("kubernetes-namespace", "", "The Kubernetes namespace to target; defaults to the service account")
+	c.Flags().StringSlice(
+		"kubernetes-namespaces",
+		nil,
+		"Additional Kubernetes namespaces to target; combined with --kubernetes-namespace",
+	)
+	c.Flags().String(
+		"kubernetes-label-selector",
+		"",
+		"Label selector restricting the Hardware served, for example hegel.tinkerbell.org/site=dc2",
+	)
+	c.Flags().StringSlice(
+		"kubernetes-ip-sources",
+		[]string{string(kubernetes.IPSourceDHCP), string(kubernetes.IPSourceInstance)},
+		"Hardware fields to source lookup IP addresses from. Options: dhcp, instance",
+	)
+	c.Flags().String(
+		"kubernetes-ip-conflict-policy",
+		string(kubernetes.ConflictPolicyError),
+		"How to choose between Hardware that share an IP. Options: error, oldest, provisioning",
+	)
+	c.Flags().Duration(
+		"kubernetes-stale-tolerance",
+		0,
+		"How long to report healthy while serving cached data when the API server is unreachable; 0 disables",
+	)
+	c.Flags().String(
+		"kubernetes-snapshot-path",
+		"",
+		"Path to a snapshot file used to serve Hardware before the cache syncs; empty disables snapshots",
+	)
+	c.Flags().Duration("kubernetes-snapshot-interval", time.Minute, "Interval at which changes are written to the snapshot")
+	c.Flags().Bool(
+		"kubernetes-precompute",
+		false,
+		"Map Hardware to metadata responses when Hardware changes instead of on every request",
+	)
+	c.Flags().Bool(
+		"kubernetes-lazy-userdata",
+		false,
+		"Keep userdata out of the cache and retrieve it from the API server when requested",
+	)
+	c.Flags().Int(
+		"kubernetes-userdata-cache-size",
+		1000,
+		"Number of Hardware userdata retained when lazy userdata is enabled",
+	)
+	c.Flags().Bool(
+		"kubernetes-record-access",
+		false,
+		"Record userdata retrievals onto Hardware annotations; requires permission to patch Hardware",
+	)
+	c.Flags().Duration(
+		"kubernetes-record-access-interval",
+		time.Minute,
+		"Minimum interval between recording userdata retrievals onto the same Hardware",
+	)
+	c.Flags().Bool(
+		"kubernetes-forward-events",
+		false,
+		"Record boot progress reported to the phone home endpoints as Kubernetes Events on Hardware",
+	)
+	c.Flags().String(
+		"rescue-userdata",
+		"",
+		"Path to userdata served to Hardware in rescue mode that don't reference a rescue userdata ConfigMap",
+	)
+	c.Flags().String(
+		"rescue-vendordata",
+		"",
+		"Path to vendor-data served with --rescue-userdata to Hardware in rescue mode",
+	)
+
+	// Phone home specific flags.
+	c.Flags().Bool(
+		"phone-home",
+		false,
+		"Serve endpoints receiving boot progress from the cloud-init phone_home module and webhook reporting handler",
+	)
+	c.Flags().Int(
+		"phone-home-events-per-instance",
+		phonehome.DefaultEventsPerInstance,
+		"Number of boot progress events retained for each instance",
+	)
+	c.Flags().Int(
+		"phone-home-max-instances",
+		phonehome.DefaultMaxInstances,
+		"Number of instances boot progress events are retained for",
+	)
+
+	// Userdata policy flags.
+	c.Flags().StringSlice(
+		"userdata-states",
+		nil,
+		"Instance states, such as provisioning, in which userdata and vendor-data are served; empty serves them in any state",
+	)
+	c.Flags().Duration(
+		"userdata-window",
+		0,
+		"How long userdata and vendor-data are served for after an instance's data changes; 0 disables",
+	)
+	c.Flags().Int(
+		"userdata-max-reads",
+		0,
+		"Number of times userdata, and separately vendor-data, is served for each revision of an instance; 0 is unlimited",
+	)
+
+	// Audit log flags.
+	c.Flags().String(
+		"audit-log",
+		"",
+		"Path to a JSON lines file recording every userdata and vendor-data request; '-' writes to stdout; empty disables",
+	)
+	c.Flags().Int(
+		"audit-log-max-size",
+		100,
+		"Size in megabytes at which the audit log file is rotated; 0 disables rotation",
+	)
+	c.Flags().Int("audit-log-max-backups", 5, "Number of rotated audit log files retained")
+
+	c.Flags().String("admin-addr", "", "Address to serve the admin API on; empty disables the admin API")
 
 	// Flatfile backend specific flags.
 	c.Flags().String("flatfile-path", "", "Path to the flatfile metadata")
 
+	// REST backend specific flags.
+	c.Flags().String("rest-url", "", "Base URL of the inventory service")
+	c.Flags().StringSlice(
+		"rest-headers",
+		nil,
+		"Headers added to inventory service requests in the form <name>=<value>; may be repeated",
+	)
+	c.Flags().String("rest-bearer-token-file", "", "Path to a file containing a bearer token for the inventory service")
+	c.Flags().String("rest-ca-file", "", "Path to a PEM encoded CA bundle used to verify the inventory service")
+	c.Flags().String("rest-cert-file", "", "Path to a PEM encoded client certificate for mutual TLS")
+	c.Flags().String("rest-key-file", "", "Path to a PEM encoded client key for mutual TLS")
+	c.Flags().Duration("rest-timeout", 5*time.Second, "Timeout for each inventory service request")
+	c.Flags().Int("rest-retries", 2, "Number of times failed inventory service requests are retried; 0 disables")
+	c.Flags().Int(
+		"rest-breaker-threshold",
+		5,
+		"Consecutive failed lookups after which inventory service lookups are rejected",
+	)
+	c.Flags().Duration(
+		"rest-breaker-cooldown",
+		30*time.Second,
+		"How long inventory service lookups are rejected before a trial lookup is permitted",
+	)
+
+	// Tink gRPC backend specific flags.
+	c.Flags().String("tink-server-address", "", "Address of the legacy Tink server gRPC endpoint")
+	c.Flags().Bool("tink-server-insecure", false, "Connect to the Tink server without TLS")
+	c.Flags().String("tink-server-ca-file", "", "Path to a PEM encoded CA bundle used to verify the Tink server")
+	c.Flags().String("tink-server-cert-file", "", "Path to a PEM encoded client certificate for mutual TLS")
+	c.Flags().String("tink-server-key-file", "", "Path to a PEM encoded client key for mutual TLS")
+	c.Flags().String("tink-server-name", "", "Name used to verify the Tink server certificate")
+	c.Flags().Duration("tink-resync-interval", 5*time.Minute, "Interval at which all hardware is retrieved from the Tink server")
+
+	// SQL backend specific flags.
+	c.Flags().String("sql-driver", sqldb.DriverPostgres, "SQL database driver. Options: postgres, sqlite")
+	c.Flags().String("sql-dsn", "", "Data source name used to connect to the SQL database")
+	c.Flags().Duration("sql-poll-interval", 30*time.Second, "Interval at which instances are reloaded from the SQL database")
+	c.Flags().Bool("sql-listen", false, "Reload instances when PostgreSQL notifies Hegel of changes")
+	c.Flags().Bool("sql-migrate", true, "Apply pending SQL schema migrations at startup")
+
+	// CRD backend specific flags. The cluster is configured using the Kubernetes backend flags.
+	c.Flags().String("crd-mapping-file", "", "Path to a YAML file mapping a Kubernetes resource to metadata")
+
+	// Backend cache flags.
+	c.Flags().Duration("cache-ttl", 0, "How long to cache backend lookups; 0 disables caching")
+	c.Flags().Duration("cache-negative-ttl", 0, "How long to cache backend lookups that found no instance; 0 disables")
+
 	c.Flags().Bool("debug", false, "Enable debug logging")
 
 	c.Flags().Bool("hegel-api", false, "Toggle to true to enable Hegel's new experimental API. Default is false.")
@@ -186,23 +593,145 @@ func (c *RootCommand) configureFlags() error {
 	return err
 }
 
-func toBackendOptions(opts RootCommandOptions) backend.Options {
+func toBackendOptions(opts RootCommandOptions) (backend.Options, error) {
 	var backndOpts backend.Options
-	switch opts.Backend {
-	case "flatfile":
-		backndOpts = backend.Options{
-			Flatfile: &backend.Flatfile{
+
+	for _, name := range strings.Split(opts.Backend, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case backend.NameFlatfile:
+			backndOpts.Flatfile = &backend.Flatfile{
 				Path: opts.FlatfilePath,
-			},
-		}
-	case "kubernetes":
-		backndOpts = backend.Options{
-			Kubernetes: &kubernetes.Config{
-				APIServerAddress: opts.KubernetesAPIServer,
+			}
+		case backend.NameKubernetes:
+			backndOpts.Kubernetes = &kubernetes.Config{
+				APIServerAddress:     opts.KubernetesAPIServer,
+				Kubeconfig:           opts.KubernetesKubeconfig,
+				Namespace:            opts.KubernetesNamespace,
+				Namespaces:           opts.KubernetesNamespaces,
+				LabelSelector:        opts.KubernetesLabelSelector,
+				IPSources:            toIPSources(opts.KubernetesIPSources),
+				ConflictPolicy:       kubernetes.ConflictPolicy(opts.KubernetesIPConflictPolicy),
+				StaleTolerance:       opts.KubernetesStaleTolerance,
+				SnapshotPath:         opts.KubernetesSnapshotPath,
+				SnapshotInterval:     opts.KubernetesSnapshotInterval,
+				Precompute:           opts.KubernetesPrecompute,
+				LazyUserdata:         opts.KubernetesLazyUserdata,
+				UserdataCacheSize:    opts.KubernetesUserdataCacheSize,
+				RecordAccess:         opts.KubernetesRecordAccess,
+				AccessRecordInterval: opts.KubernetesRecordAccessInterval,
+				ForwardEvents:        opts.KubernetesForwardEvents,
+			}
+
+			if opts.RescueUserdata != "" {
+				userdata, err := os.ReadFile(opts.RescueUserdata)
+				if err != nil {
+					return backend.Options{}, errors.Errorf("read rescue userdata: %v", err)
+				}
+				backndOpts.Kubernetes.RescueUserdata = string(userdata)
+			}
+
+			if opts.RescueVendordata != "" {
+				vendordata, err := os.ReadFile(opts.RescueVendordata)
+				if err != nil {
+					return backend.Options{}, errors.Errorf("read rescue vendordata: %v", err)
+				}
+				backndOpts.Kubernetes.RescueVendordata = string(vendordata)
+			}
+
+			switch len(opts.KubernetesContexts) {
+			case 0:
+			case 1:
+				backndOpts.Kubernetes.Context = opts.KubernetesContexts[0]
+			default:
+				backndOpts.KubernetesContexts = opts.KubernetesContexts
+			}
+		case backend.NameREST:
+			headers := http.Header{}
+			for _, h := range opts.RESTHeaders {
+				k, v, ok := strings.Cut(h, "=")
+				if !ok {
+					return backend.Options{}, errors.Errorf("invalid rest header %q: expected <name>=<value>", h)
+				}
+				headers.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+			}
+
+			backndOpts.REST = &rest.Config{
+				BaseURL:          opts.RESTURL,
+				Headers:          headers,
+				BearerTokenFile:  opts.RESTBearerTokenFile,
+				CAFile:           opts.RESTCAFile,
+				CertFile:         opts.RESTCertFile,
+				KeyFile:          opts.RESTKeyFile,
+				Timeout:          opts.RESTTimeout,
+				Retries:          &opts.RESTRetries,
+				BreakerThreshold: opts.RESTBreakerThreshold,
+				BreakerCooldown:  opts.RESTBreakerCooldown,
+			}
+		case backend.NameTink:
+			backndOpts.Tink = &tinkgrpc.Config{
+				Address:        opts.TinkServerAddress,
+				Insecure:       opts.TinkServerInsecure,
+				CAFile:         opts.TinkServerCAFile,
+				CertFile:       opts.TinkServerCertFile,
+				KeyFile:        opts.TinkServerKeyFile,
+				ServerName:     opts.TinkServerName,
+				ResyncInterval: opts.TinkResyncInterval,
+			}
+		case backend.NameSQL:
+			backndOpts.SQL = &sqldb.Config{
+				Driver:       opts.SQLDriver,
+				DSN:          opts.SQLDSN,
+				PollInterval: opts.SQLPollInterval,
+				Listen:       opts.SQLListen,
+				Migrate:      opts.SQLMigrate,
+			}
+		case backend.NameCRD:
+			if opts.CRDMappingFile == "" {
+				return backend.Options{}, errors.New("crd backend requires --crd-mapping-file")
+			}
+
+			mapping, err := crd.LoadMapping(opts.CRDMappingFile)
+			if err != nil {
+				return backend.Options{}, errors.Errorf("load crd mapping: %v", err)
+			}
+
+			backndOpts.CRD = &crd.Config{
 				Kubeconfig:       opts.KubernetesKubeconfig,
-				Namespace:        opts.KubernetesNamespace,
-			},
+				APIServerAddress: opts.KubernetesAPIServer,
+				Mapping:          mapping,
+			}
+		default:
+			return backend.Options{}, errors.Errorf("unknown backend: %q", name)
+		}
+		backndOpts.Order = append(backndOpts.Order, name)
+	}
+
+	for _, route := range opts.BackendRoutes {
+		name, cidr, ok := strings.Cut(route, "=")
+		if !ok {
+			return backend.Options{}, errors.Errorf("invalid backend route %q: expected <backend>=<cidr>", route)
 		}
+
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return backend.Options{}, errors.Errorf("invalid backend route %q: %v", route, err)
+		}
+
+		if backndOpts.Routes == nil {
+			backndOpts.Routes = map[string][]netip.Prefix{}
+		}
+		name = strings.TrimSpace(name)
+		backndOpts.Routes[name] = append(backndOpts.Routes[name], prefix.Masked())
+	}
+
+	return backndOpts, nil
+}
+
+func toIPSources(sources []string) []kubernetes.IPSource {
+	var ipSources []kubernetes.IPSource
+	for _, s := range sources {
+		ipSources = append(ipSources, kubernetes.IPSource(s))
 	}
-	return backndOpts
+	return ipSources
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2/internal/staticroute"
@@ -23,17 +24,146 @@ type Client interface {
 	GetEC2Instance(_ context.Context, ip string) (Instance, error)
 }
 
+// Access describes an instance retrieving its userdata.
+type Access struct {
+	// IP is the address of the instance.
+	IP string
+
+	// UserAgent is the user agent of the request.
+	UserAgent string
+
+	// Time is when the userdata was served.
+	Time time.Time
+}
+
+// AccessRecorder records instances retrieving their userdata.
+type AccessRecorder interface {
+	// RecordAccess records access. It is called while serving requests so it must not block.
+	RecordAccess(_ context.Context, access Access)
+}
+
+// UserdataClient retrieves userdata for backends that don't retrieve it with the rest of an
+// instance's data.
+type UserdataClient interface {
+	// GetEC2Userdata retrieves the userdata of the instance associated with ip. If no Instance
+	// can be found, it should return ErrInstanceNotFound. It is only called when userdata is
+	// requested.
+	GetEC2Userdata(_ context.Context, ip string) (string, error)
+}
+
+// RescueClient retrieves the payloads served to instances in rescue mode.
+type RescueClient interface {
+	// GetEC2Rescue retrieves the rescue payload of the instance associated with ip. ok is false
+	// if the instance has no rescue payload, in which case its own payload is served. It is only
+	// called when an instance in rescue mode requests its userdata or vendor-data.
+	GetEC2Rescue(_ context.Context, ip string) (_ Rescue, ok bool, _ error)
+}
+
+// ServeRecorder records instances being served metadata.
+type ServeRecorder interface {
+	// RecordServe records that metadata was served to ip. It is called after a response was
+	// written so it must not block.
+	RecordServe(_ context.Context, ip string)
+}
+
+// Payload identifies a document, such as userdata, that is served to instances and often
+// contains credentials.
+type Payload string
+
+// Payloads served by the Frontend.
+const (
+	PayloadUserdata   Payload = "user-data"
+	PayloadVendordata Payload = "vendor-data"
+)
+
+// UserdataPolicy decides whether instances may retrieve their payloads.
+type UserdataPolicy interface {
+	// AllowUserdata returns nil if instance, requesting from ip, may retrieve payload. It is
+	// called for every payload request.
+	AllowUserdata(ip string, instance Instance, payload Payload) error
+
+	// UserdataServed records payload being served to instance requesting from ip. It is called
+	// after a response was written.
+	UserdataServed(ip string, instance Instance, payload Payload)
+}
+
+// Auditor audits payload requests.
+type Auditor interface {
+	// AuditPayload audits r requesting payload. instance is the instance r resolved to and is
+	// the zero value if none was resolved. status is the HTTP status code of the response.
+	AuditPayload(r *http.Request, instance Instance, payload Payload, status int)
+}
+
 // Frontend is an EC2 HTTP API frontend. It is responsible for configuring routers with handlers
 // for the AWS EC2 instance metadata API.
 type Frontend struct {
-	client Client
+	client        Client
+	recorder      AccessRecorder
+	serveRecorder ServeRecorder
+	userdata      UserdataClient
+	rescue        RescueClient
+	policy        UserdataPolicy
+	auditor       Auditor
+}
+
+// Option configures a Frontend.
+type Option func(*Frontend)
+
+// WithAccessRecorder configures the Frontend to record successful userdata requests with r.
+func WithAccessRecorder(r AccessRecorder) Option {
+	return func(f *Frontend) {
+		f.recorder = r
+	}
+}
+
+// WithServeRecorder configures the Frontend to record every successful metadata request with r.
+func WithServeRecorder(r ServeRecorder) Option {
+	return func(f *Frontend) {
+		f.serveRecorder = r
+	}
+}
+
+// WithUserdataClient configures the Frontend to serve the userdata retrieved with c rather than
+// the userdata returned by the Client.
+func WithUserdataClient(c UserdataClient) Option {
+	return func(f *Frontend) {
+		f.userdata = c
+	}
+}
+
+// WithRescueClient configures the Frontend to serve instances in rescue mode the userdata and
+// vendor-data retrieved with c.
+func WithRescueClient(c RescueClient) Option {
+	return func(f *Frontend) {
+		f.rescue = c
+	}
+}
+
+// WithUserdataPolicy configures the Frontend to serve payloads only when p allows it. Denied
+// requests receive a 403.
+func WithUserdataPolicy(p UserdataPolicy) Option {
+	return func(f *Frontend) {
+		f.policy = p
+	}
+}
+
+// WithAuditor configures the Frontend to audit every payload request, whether successful or
+// not, with a.
+func WithAuditor(a Auditor) Option {
+	return func(f *Frontend) {
+		f.auditor = a
+	}
 }
 
 // New creates a new Frontend.
-func New(client Client) Frontend {
-	return Frontend{
+func New(client Client, opts ...Option) Frontend {
+	f := Frontend{
 		client: client,
 	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
 }
 
 // Configure configures router with the supported AWS EC2 instance metadata API endpoints.
@@ -45,22 +175,58 @@ func (f Frontend) Configure(router gin.IRouter) {
 	v20090404 := ginutil.TrailingSlashRouteHelper{IRouter: router.Group("/2009-04-04")}
 
 	dataEndpointBinder := func(router gin.IRouter, endpoint string, filter filterFunc) {
+		payload, isPayload := payloadEndpoints[endpoint]
+
 		router.GET(endpoint, func(ctx *gin.Context) {
+			var instance Instance
+			if isPayload && f.auditor != nil {
+				defer func() {
+					f.auditor.AuditPayload(ctx.Request, instance, payload, ctx.Writer.Status())
+				}()
+			}
+
 			instance, err := f.getInstance(ctx, ctx.Request)
 			if err != nil {
-				// If there's an error containing an http status code, use that status code else
-				// assume its an internal server error.
-				var httpErr *httperror.E
-				if errors.As(err, &httpErr) {
-					_ = ctx.AbortWithError(httpErr.StatusCode, err)
-				} else {
-					_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+				abortWithError(ctx, err)
+				return
+			}
+
+			if isPayload && f.policy != nil {
+				if err := f.allowUserdata(ctx.Request, instance, payload); err != nil {
+					_ = ctx.AbortWithError(http.StatusForbidden, err)
+					return
 				}
+			}
 
-				return
+			if endpoint == userdataEndpoint && f.userdata != nil {
+				if instance.Userdata, err = f.getUserdata(ctx, ctx.Request); err != nil {
+					abortWithError(ctx, err)
+					return
+				}
+			}
+
+			// Rescue payloads are only resolved when they're served as resolving them may be
+			// expensive.
+			if isPayload && instance.Metadata.Rescue && f.rescue != nil {
+				if instance, err = f.applyRescue(ctx, ctx.Request, instance); err != nil {
+					abortWithError(ctx, err)
+					return
+				}
 			}
 
 			ctx.String(http.StatusOK, filter(instance))
+
+			if isPayload && f.policy != nil {
+				f.userdataServed(ctx.Request, instance, payload)
+			}
+
+			if f.serveRecorder != nil {
+				f.recordServe(ctx, ctx.Request)
+			}
+
+			if endpoint == userdataEndpoint && f.recorder != nil {
+				f.recordAccess(ctx, ctx.Request)
+			}
 		})
 	}
 
@@ -108,6 +274,109 @@ func (f Frontend) getInstance(ctx context.Context, r *http.Request) (Instance, e
 	return instance, nil
 }
 
+// getUserdata retrieves the userdata of the instance associated with r.
+func (f Frontend) getUserdata(ctx context.Context, r *http.Request) (string, error) {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return "", httperror.New(http.StatusBadRequest, "invalid remote addr")
+	}
+
+	userdata, err := f.userdata.GetEC2Userdata(ctx, ip)
+	if err != nil {
+		if errors.Is(err, ErrInstanceNotFound) {
+			return "", httperror.New(http.StatusNotFound, "no hardware found for source ip")
+		}
+		return "", httperror.Wrap(http.StatusInternalServerError, err)
+	}
+
+	return userdata, nil
+}
+
+// applyRescue replaces the userdata and vendor-data of instance, retrieved for r, with its rescue
+// payload if it has one.
+func (f Frontend) applyRescue(ctx context.Context, r *http.Request, instance Instance) (Instance, error) {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return Instance{}, httperror.New(http.StatusBadRequest, "invalid remote addr")
+	}
+
+	rescue, ok, err := f.rescue.GetEC2Rescue(ctx, ip)
+	if err != nil {
+		if errors.Is(err, ErrInstanceNotFound) {
+			return Instance{}, httperror.New(http.StatusNotFound, "no hardware found for source ip")
+		}
+		return Instance{}, httperror.Wrap(http.StatusInternalServerError, err)
+	}
+
+	if ok {
+		instance.Userdata = rescue.Userdata
+		instance.Vendordata = rescue.Vendordata
+	}
+
+	return instance, nil
+}
+
+// abortWithError aborts ctx with err. If err contains an HTTP status code that status code is
+// used, otherwise the status is 500.
+func abortWithError(ctx *gin.Context, err error) {
+	var httpErr *httperror.E
+	if errors.As(err, &httpErr) {
+		_ = ctx.AbortWithError(httpErr.StatusCode, err)
+		return
+	}
+	_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+}
+
+// allowUserdata checks the instance, retrieved for r, may retrieve payload.
+func (f Frontend) allowUserdata(r *http.Request, instance Instance, payload Payload) error {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return err
+	}
+
+	return f.policy.AllowUserdata(ip, instance, payload)
+}
+
+// userdataServed records payload being served to the instance retrieved for r.
+func (f Frontend) userdataServed(r *http.Request, instance Instance, payload Payload) {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return
+	}
+
+	f.policy.UserdataServed(ip, instance, payload)
+}
+
+// recordAccess records r retrieving userdata.
+func (f Frontend) recordAccess(ctx context.Context, r *http.Request) {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return
+	}
+
+	f.recorder.RecordAccess(ctx, Access{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Time:      time.Now(),
+	})
+}
+
+// recordServe records metadata being served for r.
+func (f Frontend) recordServe(ctx context.Context, r *http.Request) {
+	// The address was validated when retrieving the instance.
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return
+	}
+
+	f.serveRecorder.RecordServe(ctx, ip)
+}
+
 func join(v []string) string {
 	return strings.Join(v, "\n")
 }
@@ -1,6 +1,7 @@
 package ec2_test
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
 	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
 )
 
@@ -33,6 +35,14 @@ func TestFrontendDynamicEndpoints(t *testing.T) {
 			},
 			Expect: "userdata",
 		},
+		{
+			Name:     "Vendordata",
+			Endpoint: "/2009-04-04/vendor-data",
+			Instance: Instance{
+				Vendordata: "vendordata",
+			},
+			Expect: "vendordata",
+		},
 		{
 			Name:     "InstanceID",
 			Endpoint: "/2009-04-04/meta-data/instance-id",
@@ -143,6 +153,16 @@ func TestFrontendDynamicEndpoints(t *testing.T) {
 			},
 			Expect: "local-ipv4",
 		},
+		{
+			Name:     "Rescue",
+			Endpoint: "/2009-04-04/meta-data/rescue",
+			Instance: Instance{
+				Metadata: Metadata{
+					Rescue: true,
+				},
+			},
+			Expect: "true",
+		},
 		{
 			Name:     "OperatingSystemSlug",
 			Endpoint: "/2009-04-04/meta-data/operating-system/slug",
@@ -238,7 +258,8 @@ func TestFrontendStaticEndpoints(t *testing.T) {
 			Name:     "Root",
 			Endpoint: "/2009-04-04",
 			Expect: `meta-data/
-user-data`,
+user-data
+vendor-data`,
 		},
 		{
 			Name:     "Metadata",
@@ -254,6 +275,7 @@ plan
 public-ipv4
 public-ipv6
 public-keys
+rescue
 tags`,
 		},
 		{
@@ -393,3 +415,502 @@ func Test400OnInvalidRemoteAddr(t *testing.T) {
 		}
 	}
 }
+
+// accessRecorderFunc adapts a function to an AccessRecorder.
+type accessRecorderFunc func(context.Context, Access)
+
+func (fn accessRecorderFunc) RecordAccess(ctx context.Context, access Access) {
+	fn(ctx, access)
+}
+
+func TestFrontendRecordsUserdataAccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "#cloud-config"}, nil).
+		AnyTimes()
+
+	var accesses []Access
+	recorder := accessRecorderFunc(func(_ context.Context, access Access) {
+		accesses = append(accesses, access)
+	})
+
+	router := gin.New()
+
+	fe := New(client, WithAccessRecorder(recorder))
+	fe.Configure(router)
+
+	for _, endpoint := range []string{"/2009-04-04/meta-data/hostname", "/2009-04-04/user-data"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", endpoint, nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		r.Header.Set("User-Agent", "cloud-init/23.4")
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected: 200; Received: %d", w.Code)
+		}
+	}
+
+	// Only userdata retrievals are recorded.
+	if len(accesses) != 1 {
+		t.Fatalf("Expected 1 access; Received %v", len(accesses))
+	}
+	if accesses[0].IP != "10.10.10.10" || accesses[0].UserAgent != "cloud-init/23.4" || accesses[0].Time.IsZero() {
+		t.Fatalf("Unexpected access: %+v", accesses[0])
+	}
+}
+
+// serveRecorderFunc adapts a function to a ServeRecorder.
+type serveRecorderFunc func(context.Context, string)
+
+func (fn serveRecorderFunc) RecordServe(ctx context.Context, ip string) {
+	fn(ctx, ip)
+}
+
+func TestFrontendRecordsServedMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.10").
+		Return(Instance{Userdata: "#cloud-config"}, nil).
+		AnyTimes()
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.11").
+		Return(Instance{}, ErrInstanceNotFound).
+		AnyTimes()
+
+	var served []string
+	recorder := serveRecorderFunc(func(_ context.Context, ip string) {
+		served = append(served, ip)
+	})
+
+	policy := &fakeUserdataPolicy{allow: func(string, Instance, Payload) error {
+		return errors.New("denied")
+	}}
+
+	router := gin.New()
+
+	fe := New(client, WithServeRecorder(recorder), WithUserdataPolicy(policy))
+	fe.Configure(router)
+
+	cases := []struct {
+		Endpoint   string
+		RemoteAddr string
+		Status     int
+	}{
+		{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0", Status: http.StatusOK},
+		{Endpoint: "/2009-04-04/user-data", RemoteAddr: "10.10.10.10:0", Status: http.StatusForbidden},
+		{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.11:0", Status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tc.Endpoint, nil)
+		r.RemoteAddr = tc.RemoteAddr
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != tc.Status {
+			t.Fatalf("%v: Expected: %d; Received: %d", tc.Endpoint, tc.Status, w.Code)
+		}
+	}
+
+	// Only successful requests are recorded.
+	if diff := cmp.Diff([]string{"10.10.10.10"}, served); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// userdataClientFunc adapts a function to a UserdataClient.
+type userdataClientFunc func(context.Context, string) (string, error)
+
+func (fn userdataClientFunc) GetEC2Userdata(ctx context.Context, ip string) (string, error) {
+	return fn(ctx, ip)
+}
+
+func TestFrontendUserdataClient(t *testing.T) {
+	var instance Instance
+	instance.Metadata.Hostname = "hostname"
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Error    error
+		Status   int
+		Expect   string
+		Calls    int
+	}{
+		{
+			Name:     "Userdata",
+			Endpoint: "/2009-04-04/user-data",
+			Status:   http.StatusOK,
+			Expect:   "#cloud-config",
+			Calls:    1,
+		},
+		{
+			Name:     "Metadata",
+			Endpoint: "/2009-04-04/meta-data/hostname",
+			Error:    errors.New("unreachable"),
+			Status:   http.StatusOK,
+			Expect:   "hostname",
+		},
+		{
+			Name:     "NotFound",
+			Endpoint: "/2009-04-04/user-data",
+			Error:    ErrInstanceNotFound,
+			Status:   http.StatusNotFound,
+			Calls:    1,
+		},
+		{
+			Name:     "Error",
+			Endpoint: "/2009-04-04/user-data",
+			Error:    errors.New("unreachable"),
+			Status:   http.StatusInternalServerError,
+			Calls:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), "10.10.10.10").
+				Return(instance, nil)
+
+			var calls int
+			userdataClient := userdataClientFunc(func(context.Context, string) (string, error) {
+				calls++
+				return "#cloud-config", tc.Error
+			})
+
+			router := gin.New()
+
+			fe := New(client, WithUserdataClient(userdataClient))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.Status {
+				t.Fatalf("Expected: %d; Received: %d", tc.Status, w.Code)
+			}
+			if tc.Status == http.StatusOK && w.Body.String() != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, w.Body.String())
+			}
+
+			// Userdata is only retrieved when it's served.
+			if calls != tc.Calls {
+				t.Fatalf("Expected %v userdata lookups; Received %v", tc.Calls, calls)
+			}
+		})
+	}
+}
+
+// rescueClientFunc adapts a function to a RescueClient.
+type rescueClientFunc func(context.Context, string) (Rescue, bool, error)
+
+func (fn rescueClientFunc) GetEC2Rescue(ctx context.Context, ip string) (Rescue, bool, error) {
+	return fn(ctx, ip)
+}
+
+func TestFrontendRescue(t *testing.T) {
+	instance := Instance{Userdata: "userdata", Vendordata: "vendordata"}
+	instance.Metadata.Hostname = "hostname"
+	rescued := instance
+	rescued.Metadata.Rescue = true
+
+	rescue := Rescue{Userdata: "rescue-userdata", Vendordata: "rescue-vendordata"}
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Instance Instance
+		OK       bool
+		Error    error
+		Status   int
+		Expect   string
+		Calls    int
+	}{
+		{
+			Name:     "NotRescued",
+			Endpoint: "/2009-04-04/user-data",
+			Instance: instance,
+			OK:       true,
+			Status:   http.StatusOK,
+			Expect:   "userdata",
+		},
+		{
+			Name:     "Userdata",
+			Endpoint: "/2009-04-04/user-data",
+			Instance: rescued,
+			OK:       true,
+			Status:   http.StatusOK,
+			Expect:   "rescue-userdata",
+			Calls:    1,
+		},
+		{
+			Name:     "Vendordata",
+			Endpoint: "/2009-04-04/vendor-data",
+			Instance: rescued,
+			OK:       true,
+			Status:   http.StatusOK,
+			Expect:   "rescue-vendordata",
+			Calls:    1,
+		},
+		{
+			Name:     "Metadata",
+			Endpoint: "/2009-04-04/meta-data/hostname",
+			Instance: rescued,
+			OK:       true,
+			Status:   http.StatusOK,
+			Expect:   "hostname",
+		},
+		{
+			Name:     "NoRescuePayload",
+			Endpoint: "/2009-04-04/user-data",
+			Instance: rescued,
+			Status:   http.StatusOK,
+			Expect:   "userdata",
+			Calls:    1,
+		},
+		{
+			Name:     "Error",
+			Endpoint: "/2009-04-04/user-data",
+			Instance: rescued,
+			Error:    errors.New("generic error"),
+			Status:   http.StatusInternalServerError,
+			Calls:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), "10.10.10.10").
+				Return(tc.Instance, nil)
+
+			var calls int
+			rescueClient := rescueClientFunc(func(context.Context, string) (Rescue, bool, error) {
+				calls++
+				return rescue, tc.OK, tc.Error
+			})
+
+			router := gin.New()
+
+			fe := New(client, WithRescueClient(rescueClient))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.Status {
+				t.Fatalf("Expected: %d; Received: %d", tc.Status, w.Code)
+			}
+			if tc.Status == http.StatusOK && w.Body.String() != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, w.Body.String())
+			}
+
+			// Rescue payloads are only retrieved when they're served.
+			if calls != tc.Calls {
+				t.Fatalf("Expected %v rescue lookups; Received %v", tc.Calls, calls)
+			}
+		})
+	}
+}
+
+// fakeUserdataPolicy is a UserdataPolicy that allows retrievals according to allow and records
+// the payloads served.
+type fakeUserdataPolicy struct {
+	allow  func(string, Instance, Payload) error
+	served []Payload
+}
+
+func (p *fakeUserdataPolicy) AllowUserdata(ip string, instance Instance, payload Payload) error {
+	return p.allow(ip, instance, payload)
+}
+
+func (p *fakeUserdataPolicy) UserdataServed(_ string, _ Instance, payload Payload) {
+	p.served = append(p.served, payload)
+}
+
+func TestFrontendUserdataPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "#cloud-config", State: "ready"}, nil).
+		AnyTimes()
+
+	var recorded int
+	recorder := accessRecorderFunc(func(context.Context, Access) {
+		recorded++
+	})
+
+	var checked []string
+	policy := &fakeUserdataPolicy{allow: func(ip string, instance Instance, payload Payload) error {
+		checked = append(checked, ip+" "+instance.State+" "+string(payload))
+		return errors.New("denied")
+	}}
+
+	router := gin.New()
+
+	fe := New(client, WithAccessRecorder(recorder), WithUserdataPolicy(policy))
+	fe.Configure(router)
+
+	requests := []struct {
+		Endpoint string
+		Status   int
+	}{
+		{"/2009-04-04/meta-data/hostname", http.StatusOK},
+		{"/2009-04-04/user-data", http.StatusForbidden},
+		{"/2009-04-04/vendor-data", http.StatusForbidden},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", req.Endpoint, nil)
+		r.RemoteAddr = "10.10.10.10:0"
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != req.Status {
+			t.Fatalf("%v: Expected: %d; Received: %d", req.Endpoint, req.Status, w.Code)
+		}
+	}
+
+	// Only payload retrievals are subject to the policy and denied retrievals aren't recorded.
+	expect := []string{"10.10.10.10 ready user-data", "10.10.10.10 ready vendor-data"}
+	if diff := cmp.Diff(expect, checked); diff != "" {
+		t.Fatal(diff)
+	}
+	if recorded != 0 {
+		t.Fatalf("Expected 0 accesses; Received %v", recorded)
+	}
+	if len(policy.served) != 0 {
+		t.Fatalf("Expected no served payloads; Received %v", policy.served)
+	}
+}
+
+func TestFrontendUserdataPolicyServed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.10").
+		Return(Instance{Userdata: "#cloud-config"}, nil).
+		AnyTimes()
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.11").
+		Return(Instance{Metadata: Metadata{Rescue: true}}, nil).
+		AnyTimes()
+
+	policy := &fakeUserdataPolicy{allow: func(string, Instance, Payload) error {
+		return nil
+	}}
+	rescueClient := rescueClientFunc(func(context.Context, string) (Rescue, bool, error) {
+		return Rescue{}, false, errors.New("generic error")
+	})
+
+	router := gin.New()
+
+	fe := New(client, WithUserdataPolicy(policy), WithRescueClient(rescueClient))
+	fe.Configure(router)
+
+	requests := []struct {
+		Endpoint   string
+		RemoteAddr string
+		Status     int
+	}{
+		{"/2009-04-04/user-data", "10.10.10.10:0", http.StatusOK},
+		{"/2009-04-04/vendor-data", "10.10.10.10:0", http.StatusOK},
+		{"/2009-04-04/user-data", "10.10.10.11:0", http.StatusInternalServerError},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", req.Endpoint, nil)
+		r.RemoteAddr = req.RemoteAddr
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != req.Status {
+			t.Fatalf("%v: Expected: %d; Received: %d", req.Endpoint, req.Status, w.Code)
+		}
+	}
+
+	// Only payloads that were served count as reads.
+	expect := []Payload{PayloadUserdata, PayloadVendordata}
+	if diff := cmp.Diff(expect, policy.served); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+// auditorFunc adapts a function to an Auditor.
+type auditorFunc func(*http.Request, Instance, Payload, int)
+
+func (fn auditorFunc) AuditPayload(r *http.Request, instance Instance, payload Payload, status int) {
+	fn(r, instance, payload, status)
+}
+
+func TestFrontendAuditsPayloads(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.10").
+		Return(Instance{Userdata: "#cloud-config", Metadata: Metadata{InstanceID: "instance"}}, nil).
+		AnyTimes()
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.11").
+		Return(Instance{}, ErrInstanceNotFound).
+		AnyTimes()
+
+	type audit struct {
+		ID      string
+		Payload Payload
+		Status  int
+	}
+	var audits []audit
+	auditor := auditorFunc(func(_ *http.Request, instance Instance, payload Payload, status int) {
+		audits = append(audits, audit{ID: instance.Metadata.InstanceID, Payload: payload, Status: status})
+	})
+
+	router := gin.New()
+
+	fe := New(client, WithAuditor(auditor))
+	fe.Configure(router)
+
+	requests := []struct {
+		Endpoint   string
+		RemoteAddr string
+	}{
+		{"/2009-04-04/meta-data/hostname", "10.10.10.10:0"},
+		{"/2009-04-04/user-data", "10.10.10.10:0"},
+		{"/2009-04-04/vendor-data", "10.10.10.10:0"},
+		{"/2009-04-04/user-data", "10.10.10.11:0"},
+	}
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", req.Endpoint, nil)
+		r.RemoteAddr = req.RemoteAddr
+
+		router.ServeHTTP(w, r)
+	}
+
+	// Only payload requests are audited, including those that fail.
+	expect := []audit{
+		{ID: "instance", Payload: PayloadUserdata, Status: http.StatusOK},
+		{ID: "instance", Payload: PayloadVendordata, Status: http.StatusOK},
+		{Payload: PayloadUserdata, Status: http.StatusNotFound},
+	}
+	if diff := cmp.Diff(expect, audits); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -1,5 +1,7 @@
 package ec2
 
+import "time"
+
 // Instance is a struct that contains the hardware data exposed from the EC2 API endpoints. For
 // an explanation of the endpoints refer to the AWS EC2 Instance Metadata documentation.
 //
@@ -8,8 +10,26 @@ package ec2
 // Note not all AWS EC2 Instance Metadata categories are supported as some are not applicable.
 // Deviations from the AWS EC2 Instance Metadata should be documented here.
 type Instance struct {
-	Userdata string
-	Metadata Metadata
+	Userdata   string
+	Vendordata string
+	Metadata   Metadata
+
+	// State is the provisioning state of the instance, for example provisioning. It isn't
+	// served and is used to decide whether userdata may be served.
+	State string
+
+	// Revision identifies the version of the instance's data and changes when the data changes.
+	// It is empty if the backend can't determine it. It isn't served and is used to decide
+	// whether userdata may be served.
+	Revision string
+
+	// Changed is when the instance's data last changed. It is zero if the backend can't determine
+	// it. It isn't served and is used to decide whether userdata may be served.
+	Changed time.Time
+
+	// Namespace is the namespace of the instance in backends that support namespaces. It isn't
+	// served and is used to audit access.
+	Namespace string
 }
 
 // Metadata is a part of Instance.
@@ -26,6 +46,17 @@ type Metadata struct {
 	PublicIPv6      string
 	LocalIPv4       string
 	OperatingSystem OperatingSystem
+
+	// Rescue indicates the instance should boot into a rescue environment. It isn't part of the
+	// AWS EC2 Instance Metadata.
+	Rescue bool
+}
+
+// Rescue is the payload served to an instance in rescue mode in place of its own userdata and
+// vendor-data.
+type Rescue struct {
+	Userdata   string
+	Vendordata string
 }
 
 // OperatingSystem is part of Metadata.
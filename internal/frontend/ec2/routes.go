@@ -1,21 +1,41 @@
 package ec2
 
+import "strconv"
+
 // TODO(chrisdoherty4) Figure out a better way to model routes; this approach is clunky and
 // error prone. Ideally we have a way to define routes and retrieve the children of a route without
 // manually defining everything.
 
 type filterFunc func(i Instance) string
 
+// userdataEndpoint serves an instance's userdata.
+const userdataEndpoint = "/user-data"
+
+// vendordataEndpoint serves an instance's vendor-data.
+const vendordataEndpoint = "/vendor-data"
+
+// payloadEndpoints are the endpoints serving payloads.
+var payloadEndpoints = map[string]Payload{
+	userdataEndpoint:   PayloadUserdata,
+	vendordataEndpoint: PayloadVendordata,
+}
+
 var dataRoutes = []struct {
 	Endpoint string
 	Filter   filterFunc
 }{
 	{
-		Endpoint: "/user-data",
+		Endpoint: userdataEndpoint,
 		Filter: func(i Instance) string {
 			return i.Userdata
 		},
 	},
+	{
+		Endpoint: vendordataEndpoint,
+		Filter: func(i Instance) string {
+			return i.Vendordata
+		},
+	},
 	{
 		Endpoint: "/meta-data/instance-id",
 		Filter: func(i Instance) string {
@@ -82,6 +102,12 @@ var dataRoutes = []struct {
 			return join(i.Metadata.PublicKeys)
 		},
 	},
+	{
+		Endpoint: "/meta-data/rescue",
+		Filter: func(i Instance) string {
+			return strconv.FormatBool(i.Metadata.Rescue)
+		},
+	},
 	{
 		Endpoint: "/meta-data/operating-system/slug",
 		Filter: func(i Instance) string {
@@ -15,8 +15,17 @@ import (
 	"github.com/tinkerbell/hegel/internal/http/request"
 )
 
+// ErrInstanceNotFound indicates an instance could not be found for the given identifier.
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// ErrUnsupported indicates the backend is incapable of providing hack instance data.
+var ErrUnsupported = errors.New("hack instance data unsupported by backend")
+
 // Client is a backend for retrieving hack instance data.
 type Client interface {
+	// GetHackInstance retrieves an Instance associated with ip. If no Instance can be found, it
+	// should return ErrInstanceNotFound. If the backend cannot provide hack instance data it
+	// should return ErrUnsupported.
 	GetHackInstance(ctx context.Context, ip string) (Instance, error)
 }
 
@@ -25,45 +34,98 @@ type Client interface {
 type Instance struct {
 	Metadata struct {
 		Instance struct {
-			Storage struct {
-				Disks []struct {
-					Device     string `json:"device"`
-					Partitions []struct {
-						Label  string `json:"label"`
-						Number int    `json:"number"`
-						Size   uint64 `json:"size"`
-					} `json:"partitions"`
-					WipeTable bool `json:"wipe_table"`
-				} `json:"disks"`
-				Filesystems []struct {
-					Mount struct {
-						Create struct {
-							Options []string `json:"options"`
-						} `json:"create"`
-						Device string `json:"device"`
-						Format string `json:"format"`
-						Point  string `json:"point"`
-					} `json:"mount"`
-				} `json:"filesystems"`
-			} `json:"storage"`
+			Storage Storage `json:"storage"`
 		} `json:"instance"`
 	} `json:"metadata"`
 }
 
+// Storage is part of Instance.
+type Storage struct {
+	Disks       []Disk       `json:"disks"`
+	Filesystems []Filesystem `json:"filesystems"`
+}
+
+// Disk is part of Storage.
+type Disk struct {
+	Device     string      `json:"device"`
+	Partitions []Partition `json:"partitions"`
+	WipeTable  bool        `json:"wipe_table"`
+}
+
+// Partition is part of Disk.
+type Partition struct {
+	Label  string `json:"label"`
+	Number int    `json:"number"`
+	Size   uint64 `json:"size"`
+}
+
+// Filesystem is part of Storage.
+type Filesystem struct {
+	Mount Mount `json:"mount"`
+}
+
+// Mount is part of Filesystem.
+type Mount struct {
+	Create struct {
+		Options []string `json:"options"`
+	} `json:"create"`
+	Device string `json:"device"`
+	Format string `json:"format"`
+	Point  string `json:"point"`
+}
+
+// ServeRecorder records instances being served metadata.
+type ServeRecorder interface {
+	// RecordServe records that metadata was served to ip. It is called after a response was
+	// written so it must not block.
+	RecordServe(_ context.Context, ip string)
+}
+
+// Option configures the /metadata endpoint.
+type Option func(*config)
+
+type config struct {
+	recorder ServeRecorder
+}
+
+// WithServeRecorder configures the endpoint to record every successful request with r.
+func WithServeRecorder(r ServeRecorder) Option {
+	return func(c *config) {
+		c.recorder = r
+	}
+}
+
 // Configure configures router with a `/metadata` endpoint using client to retrieve instance data.
-func Configure(router gin.IRouter, client Client) {
+func Configure(router gin.IRouter, client Client, opts ...Option) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	router.GET("/metadata", func(ctx *gin.Context) {
 		ip, err := request.RemoteAddrIP(ctx.Request)
 		if err != nil {
 			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid remote address"))
+			return
 		}
 
 		instance, err := client.GetHackInstance(ctx, ip)
 		if err != nil {
-			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			switch {
+			case errors.Is(err, ErrInstanceNotFound):
+				_ = ctx.AbortWithError(http.StatusNotFound, err)
+			case errors.Is(err, ErrUnsupported):
+				_ = ctx.AbortWithError(http.StatusNotImplemented, err)
+			default:
+				_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			}
 			return
 		}
 
 		ctx.JSON(200, instance)
+
+		if cfg.recorder != nil {
+			cfg.recorder.RecordServe(ctx, ip)
+		}
 	})
 }
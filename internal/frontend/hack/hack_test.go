@@ -0,0 +1,124 @@
+package hack_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+// clientFunc adapts a function to a Client.
+type clientFunc func(context.Context, string) (Instance, error)
+
+func (fn clientFunc) GetHackInstance(ctx context.Context, ip string) (Instance, error) {
+	return fn(ctx, ip)
+}
+
+// serveRecorderFunc adapts a function to a ServeRecorder.
+type serveRecorderFunc func(context.Context, string)
+
+func (fn serveRecorderFunc) RecordServe(ctx context.Context, ip string) {
+	fn(ctx, ip)
+}
+
+func TestConfigure(t *testing.T) {
+	var instance Instance
+	instance.Metadata.Instance.Storage.Disks = []Disk{{Device: "/dev/sda", WipeTable: true}}
+
+	cases := []struct {
+		Name       string
+		RemoteAddr string
+		Instance   Instance
+		Error      error
+		Status     int
+	}{
+		{
+			Name:       "OK",
+			RemoteAddr: "10.10.10.10:0",
+			Instance:   instance,
+			Status:     http.StatusOK,
+		},
+		{
+			Name:       "NotFound",
+			RemoteAddr: "10.10.10.10:0",
+			Error:      ErrInstanceNotFound,
+			Status:     http.StatusNotFound,
+		},
+		{
+			Name:       "Unsupported",
+			RemoteAddr: "10.10.10.10:0",
+			Error:      ErrUnsupported,
+			Status:     http.StatusNotImplemented,
+		},
+		{
+			Name:       "Error",
+			RemoteAddr: "10.10.10.10:0",
+			Error:      errors.New("generic error"),
+			Status:     http.StatusInternalServerError,
+		},
+		{
+			Name:       "InvalidRemoteAddr",
+			RemoteAddr: "invalid",
+			Status:     http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var requested, served string
+			client := clientFunc(func(_ context.Context, ip string) (Instance, error) {
+				requested = ip
+				return tc.Instance, tc.Error
+			})
+			recorder := serveRecorderFunc(func(_ context.Context, ip string) {
+				served = ip
+			})
+
+			router := gin.New()
+			Configure(router, client, WithServeRecorder(recorder))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+			r.RemoteAddr = tc.RemoteAddr
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.Status {
+				t.Fatalf("Expected: %d; Received: %d", tc.Status, w.Code)
+			}
+
+			// Only successful requests are recorded.
+			if tc.Status != http.StatusOK {
+				if served != "" {
+					t.Fatalf("Expected no served metadata to be recorded; Received %q", served)
+				}
+				return
+			}
+
+			if requested != "10.10.10.10" {
+				t.Fatalf("Expected lookup of 10.10.10.10; Received %q", requested)
+			}
+			if served != "10.10.10.10" {
+				t.Fatalf("Expected served metadata to be recorded for 10.10.10.10; Received %q", served)
+			}
+
+			var received Instance
+			if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil {
+				t.Fatal(err)
+			}
+			disks := received.Metadata.Instance.Storage.Disks
+			if len(disks) != 1 || disks[0].Device != "/dev/sda" || !disks[0].WipeTable {
+				t.Fatalf("Unexpected disks: %+v", disks)
+			}
+		})
+	}
+}
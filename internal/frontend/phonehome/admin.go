@@ -0,0 +1,27 @@
+package phonehome
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigureAdmin configures router with endpoints exposing the events in log. The endpoints
+// aren't authenticated so router should only be reachable by operators.
+//
+//	GET /admin/v1/events            Summarizes every instance with events.
+//	GET /admin/v1/events/:instance  Lists the events of an instance, oldest first.
+func ConfigureAdmin(router gin.IRouter, log *Log) {
+	router.GET("/admin/v1/events", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, log.Instances())
+	})
+
+	router.GET("/admin/v1/events/:instance", func(ctx *gin.Context) {
+		events, ok := log.Events(ctx.Param("instance"))
+		if !ok {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "no events for instance"})
+			return
+		}
+		ctx.JSON(http.StatusOK, events)
+	})
+}
@@ -0,0 +1,125 @@
+package phonehome
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultEventsPerInstance is the default number of events retained for each instance.
+	DefaultEventsPerInstance = 100
+
+	// DefaultMaxInstances is the default number of instances events are retained for.
+	DefaultMaxInstances = 10000
+)
+
+// Log retains the most recent events of each instance in memory. It is bounded in both the
+// number of events retained for each instance and the number of instances. When the number of
+// instances is exceeded, the instance that least recently received an event is forgotten.
+type Log struct {
+	eventsPerInstance int
+	maxInstances      int
+
+	mtx       sync.Mutex
+	lru       *list.List
+	instances map[string]*list.Element
+}
+
+type instanceLog struct {
+	instance string
+	events   []Event
+}
+
+// NewLog creates a Log. Values less than 1 use the defaults.
+func NewLog(eventsPerInstance, maxInstances int) *Log {
+	if eventsPerInstance < 1 {
+		eventsPerInstance = DefaultEventsPerInstance
+	}
+	if maxInstances < 1 {
+		maxInstances = DefaultMaxInstances
+	}
+
+	return &Log{
+		eventsPerInstance: eventsPerInstance,
+		maxInstances:      maxInstances,
+		lru:               list.New(),
+		instances:         map[string]*list.Element{},
+	}
+}
+
+// Append adds e to the events of instance, discarding the oldest event if the instance has
+// reached its limit.
+func (l *Log) Append(instance string, e Event) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	elem, ok := l.instances[instance]
+	if !ok {
+		elem = l.lru.PushFront(&instanceLog{instance: instance})
+		l.instances[instance] = elem
+
+		if l.lru.Len() > l.maxInstances {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.instances, oldest.Value.(*instanceLog).instance) //nolint:forcetypeassert // Only *instanceLog is stored.
+		}
+	} else {
+		l.lru.MoveToFront(elem)
+	}
+
+	il := elem.Value.(*instanceLog) //nolint:forcetypeassert // Only *instanceLog is stored.
+	if len(il.events) == l.eventsPerInstance {
+		il.events = append(il.events[:0:0], il.events[1:]...)
+	}
+	il.events = append(il.events, e)
+}
+
+// Events returns the events of instance, oldest first. ok is false if there are no events for
+// instance.
+func (l *Log) Events(instance string) (events []Event, ok bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	elem, ok := l.instances[instance]
+	if !ok {
+		return nil, false
+	}
+
+	il := elem.Value.(*instanceLog) //nolint:forcetypeassert // Only *instanceLog is stored.
+	return append([]Event(nil), il.events...), true
+}
+
+// InstanceSummary summarizes the events of an instance.
+type InstanceSummary struct {
+	// Instance identifies the instance.
+	Instance string `json:"instance"`
+
+	// Events is the number of events retained for the instance.
+	Events int `json:"events"`
+
+	// Last is the most recent event of the instance.
+	Last Event `json:"last"`
+}
+
+// Instances summarizes every instance with events, ordered by instance.
+func (l *Log) Instances() []InstanceSummary {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	summaries := make([]InstanceSummary, 0, len(l.instances))
+	for elem := l.lru.Front(); elem != nil; elem = elem.Next() {
+		il := elem.Value.(*instanceLog) //nolint:forcetypeassert // Only *instanceLog is stored.
+		summaries = append(summaries, InstanceSummary{
+			Instance: il.instance,
+			Events:   len(il.events),
+			Last:     il.events[len(il.events)-1],
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Instance < summaries[j].Instance
+	})
+
+	return summaries
+}
@@ -0,0 +1,60 @@
+package phonehome
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogBoundsEventsPerInstance(t *testing.T) {
+	log := NewLog(2, 10)
+
+	for _, name := range []string{"first", "second", "third"} {
+		log.Append("instance", Event{Name: name})
+	}
+
+	events, ok := log.Events("instance")
+	if !ok {
+		t.Fatal("Expected events for instance")
+	}
+
+	if diff := cmp.Diff([]Event{{Name: "second"}, {Name: "third"}}, events); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogBoundsInstances(t *testing.T) {
+	log := NewLog(10, 2)
+
+	log.Append("a", Event{Name: "a"})
+	log.Append("b", Event{Name: "b"})
+
+	// Appending to a marks it as recently used so b is forgotten when c is added.
+	log.Append("a", Event{Name: "a2"})
+	log.Append("c", Event{Name: "c"})
+
+	if _, ok := log.Events("b"); ok {
+		t.Fatal("Expected b to be forgotten")
+	}
+
+	expect := []InstanceSummary{
+		{Instance: "a", Events: 2, Last: Event{Name: "a2"}},
+		{Instance: "c", Events: 1, Last: Event{Name: "c"}},
+	}
+	if diff := cmp.Diff(expect, log.Instances()); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestLogEventsAreCopied(t *testing.T) {
+	log := NewLog(10, 10)
+	log.Append("instance", Event{Name: "original"})
+
+	events, _ := log.Events("instance")
+	events[0].Name = "modified"
+
+	events, _ = log.Events("instance")
+	if events[0].Name != "original" {
+		t.Fatalf("Expected original; Received %v", events[0].Name)
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Package phonehome contains a frontend that receives boot progress from instances. It provides
+endpoints for the cloud-init phone_home module and the cloud-init webhook reporting handler.
+Callers are authenticated by their source IP using the backend so instances can only report
+progress for themselves. Events are retained in a bounded in-memory Log that is exposed through
+an admin API and can be forwarded to the backend, for example as Kubernetes Events.
+*/
+package phonehome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/http/request"
+)
+
+const (
+	// PhoneHomeEndpoint receives requests from the cloud-init phone_home module.
+	PhoneHomeEndpoint = "/hegel/v1/phone-home"
+
+	// EventsEndpoint receives events from the cloud-init webhook reporting handler.
+	EventsEndpoint = "/hegel/v1/events"
+)
+
+// maxBodySize bounds request bodies. Phone home requests and reporting events are small;
+// reporting events may include files which are discarded.
+const maxBodySize = 1 << 20
+
+// Phone home requests with more fields, or with longer field values, than these limits are
+// rejected so retained events stay small. cloud-init posts fewer than 10 fields, the longest of
+// which is an RSA host key. Reporting events with fields longer than maxDataValueLength are
+// rejected too.
+const (
+	maxDataFields      = 32
+	maxDataValueLength = 4096
+)
+
+// Event kinds.
+const (
+	// KindPhoneHome is an event received from the cloud-init phone_home module.
+	KindPhoneHome = "phone-home"
+
+	// KindReport is an event received from the cloud-init webhook reporting handler.
+	KindReport = "report"
+)
+
+// Event is boot progress reported by an instance.
+type Event struct {
+	// Received is when Hegel received the event.
+	Received time.Time `json:"received"`
+
+	// IP is the address the event was received from.
+	IP string `json:"ip"`
+
+	// Kind is KindPhoneHome or KindReport.
+	Kind string `json:"kind"`
+
+	// Name, Type, Origin, Result and Description are the fields of a reporting event. Type is
+	// the event type, for example start or finish. Result is only set on finish events.
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Origin      string `json:"origin,omitempty"`
+	Result      string `json:"result,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Timestamp is when the instance generated a reporting event.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Data holds the fields of a phone home request, such as the instance's hostname and SSH
+	// host keys.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Failed reports whether e reports a failure or warning.
+func (e Event) Failed() bool {
+	return e.Result == "FAIL" || e.Result == "WARN"
+}
+
+// Forwarder forwards events to a backend.
+type Forwarder interface {
+	// ForwardEvent forwards e. It is called while serving requests so it must not block.
+	ForwardEvent(_ context.Context, e Event)
+}
+
+// InstanceIdentifier identifies instances by IP. Unlike retrieving an instance's metadata,
+// identifying an instance must not have side effects such as recording access or retrieving
+// userdata.
+type InstanceIdentifier interface {
+	// InstanceID returns the ID of the instance with ip. It returns ec2.ErrInstanceNotFound when
+	// there's no instance with ip.
+	InstanceID(_ context.Context, ip string) (string, error)
+}
+
+// Frontend serves the phone home and reporting endpoints.
+type Frontend struct {
+	client     ec2.Client
+	log        *Log
+	forwarder  Forwarder
+	identifier InstanceIdentifier
+}
+
+// Option configures a Frontend.
+type Option func(*Frontend)
+
+// WithForwarder configures the Frontend to forward events with f.
+func WithForwarder(f Forwarder) Option {
+	return func(fe *Frontend) {
+		fe.forwarder = f
+	}
+}
+
+// WithInstanceIdentifier configures the Frontend to authenticate callers with i instead of
+// retrieving their metadata.
+func WithInstanceIdentifier(i InstanceIdentifier) Option {
+	return func(fe *Frontend) {
+		fe.identifier = i
+	}
+}
+
+// New creates a Frontend that authenticates callers using client and retains events in log.
+func New(client ec2.Client, log *Log, opts ...Option) Frontend {
+	f := Frontend{
+		client: client,
+		log:    log,
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
+}
+
+// Configure configures router with the phone home and reporting endpoints.
+func (f Frontend) Configure(router gin.IRouter) {
+	router.POST(PhoneHomeEndpoint, func(ctx *gin.Context) {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodySize)
+		if err := ctx.Request.ParseForm(); err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if len(ctx.Request.PostForm) > maxDataFields {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("more than %v fields", maxDataFields))
+			return
+		}
+
+		data := map[string]string{}
+		for k, v := range ctx.Request.PostForm {
+			if len(v) == 0 {
+				continue
+			}
+			if len(v[0]) > maxDataValueLength {
+				_ = ctx.AbortWithError(http.StatusBadRequest,
+					fmt.Errorf("field %q exceeds %v bytes", k, maxDataValueLength))
+				return
+			}
+			data[k] = v[0]
+		}
+
+		f.record(ctx, Event{Kind: KindPhoneHome, Name: KindPhoneHome, Data: data})
+	})
+
+	router.POST(EventsEndpoint, func(ctx *gin.Context) {
+		var r report
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodySize)
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&r); err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if err := r.validate(); err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		f.record(ctx, r.toEvent())
+	})
+}
+
+// record authenticates the caller and records e.
+func (f Frontend) record(ctx *gin.Context, e Event) {
+	ip, err := request.RemoteAddrIP(ctx.Request)
+	if err != nil {
+		_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid remote address"))
+		return
+	}
+
+	id, err := f.instanceID(ctx, ip)
+	if err != nil {
+		if errors.Is(err, ec2.ErrInstanceNotFound) {
+			_ = ctx.AbortWithError(http.StatusNotFound, errors.New("no hardware found for source ip"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	e.IP = ip
+	e.Received = time.Now()
+
+	f.log.Append(instanceKey(id, ip), e)
+
+	if f.forwarder != nil {
+		f.forwarder.ForwardEvent(ctx, e)
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// instanceID returns the ID of the instance with ip. Backends that can't identify instances
+// are asked for the instance's metadata.
+func (f Frontend) instanceID(ctx context.Context, ip string) (string, error) {
+	if f.identifier != nil {
+		return f.identifier.InstanceID(ctx, ip)
+	}
+
+	instance, err := f.client.GetEC2Instance(ctx, ip)
+	if err != nil {
+		return "", err
+	}
+	return instance.Metadata.InstanceID, nil
+}
+
+// instanceKey identifies an instance in the Log. Instances without an ID are identified by ip.
+func instanceKey(id, ip string) string {
+	if id != "" {
+		return id
+	}
+	return ip
+}
+
+// report is an event sent by the cloud-init webhook reporting handler.
+type report struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	EventType   string  `json:"event_type"`
+	Origin      string  `json:"origin"`
+	Result      string  `json:"result"`
+	Timestamp   float64 `json:"timestamp"`
+}
+
+// validate checks r has the required fields and that none exceed maxDataValueLength.
+func (r report) validate() error {
+	if r.Name == "" || r.EventType == "" {
+		return errors.New("name and event_type are required")
+	}
+
+	fields := map[string]string{
+		"name":        r.Name,
+		"description": r.Description,
+		"event_type":  r.EventType,
+		"origin":      r.Origin,
+		"result":      r.Result,
+	}
+	for k, v := range fields {
+		if len(v) > maxDataValueLength {
+			return fmt.Errorf("field %q exceeds %v bytes", k, maxDataValueLength)
+		}
+	}
+
+	return nil
+}
+
+func (r report) toEvent() Event {
+	e := Event{
+		Kind:        KindReport,
+		Name:        r.Name,
+		Type:        r.EventType,
+		Origin:      r.Origin,
+		Result:      r.Result,
+		Description: r.Description,
+	}
+
+	if r.Timestamp > 0 {
+		sec, frac := math.Modf(r.Timestamp)
+		ts := time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+		e.Timestamp = &ts
+	}
+
+	return e
+}
@@ -0,0 +1,293 @@
+package phonehome
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+// fakeClient serves an instance for 10.10.10.10 only.
+type fakeClient struct {
+	err error
+}
+
+func (c fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	if c.err != nil {
+		return ec2.Instance{}, c.err
+	}
+	if ip != "10.10.10.10" {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	var i ec2.Instance
+	i.Metadata.InstanceID = "instance-1"
+	return i, nil
+}
+
+// identifierFunc adapts a function to an InstanceIdentifier.
+type identifierFunc func(context.Context, string) (string, error)
+
+func (fn identifierFunc) InstanceID(ctx context.Context, ip string) (string, error) {
+	return fn(ctx, ip)
+}
+
+// forwarderFunc adapts a function to a Forwarder.
+type forwarderFunc func(context.Context, Event)
+
+func (fn forwarderFunc) ForwardEvent(ctx context.Context, e Event) {
+	fn(ctx, e)
+}
+
+// newRequest creates a request from the address fakeClient serves.
+func newRequest(method, endpoint, body string) *http.Request {
+	r := httptest.NewRequest(method, endpoint, strings.NewReader(body))
+	r.RemoteAddr = "10.10.10.10:0"
+	return r
+}
+
+func serve(router *gin.Engine, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestFrontendRecordsEvents(t *testing.T) {
+	log := NewLog(10, 10)
+
+	var forwarded []Event
+	forwarder := forwarderFunc(func(_ context.Context, e Event) {
+		forwarded = append(forwarded, e)
+	})
+
+	router := gin.New()
+	New(fakeClient{}, log, WithForwarder(forwarder)).Configure(router)
+
+	form := url.Values{"instance_id": {"instance-1"}, "hostname": {"machine1"}}
+	r := newRequest(http.MethodPost, PhoneHomeEndpoint, form.Encode())
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := serve(router, r); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected: 204; Received: %d", w.Code)
+	}
+
+	report := `{
+		"name": "init-network/config-ssh",
+		"description": "running config-ssh",
+		"event_type": "finish",
+		"origin": "cloudinit",
+		"timestamp": 1700000000.5,
+		"result": "FAIL"
+	}`
+	r = newRequest(http.MethodPost, EventsEndpoint, report)
+	if w := serve(router, r); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected: 204; Received: %d", w.Code)
+	}
+
+	timestamp := time.Unix(1700000000, int64(500*time.Millisecond)).UTC()
+	expect := []Event{
+		{
+			IP:   "10.10.10.10",
+			Kind: KindPhoneHome,
+			Name: KindPhoneHome,
+			Data: map[string]string{"instance_id": "instance-1", "hostname": "machine1"},
+		},
+		{
+			IP:          "10.10.10.10",
+			Kind:        KindReport,
+			Name:        "init-network/config-ssh",
+			Type:        "finish",
+			Origin:      "cloudinit",
+			Result:      "FAIL",
+			Description: "running config-ssh",
+			Timestamp:   &timestamp,
+		},
+	}
+
+	events, ok := log.Events("instance-1")
+	if !ok {
+		t.Fatal("Expected events for instance-1")
+	}
+
+	ignoreReceived := cmpopts.IgnoreFields(Event{}, "Received")
+	if diff := cmp.Diff(expect, events, ignoreReceived); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(expect, forwarded, ignoreReceived); diff != "" {
+		t.Fatal(diff)
+	}
+	if !events[1].Failed() {
+		t.Fatal("Expected the event to report a failure")
+	}
+}
+
+func TestFrontendUsesInstanceIdentifier(t *testing.T) {
+	log := NewLog(10, 10)
+
+	identifier := identifierFunc(func(_ context.Context, ip string) (string, error) {
+		if ip != "10.10.10.10" {
+			return "", ec2.ErrInstanceNotFound
+		}
+		return "identified", nil
+	})
+
+	// The client fails so requests only succeed if the identifier is used.
+	router := gin.New()
+	New(fakeClient{err: errors.New("unavailable")}, log, WithInstanceIdentifier(identifier)).Configure(router)
+
+	r := newRequest(http.MethodPost, EventsEndpoint, `{"name": "init", "event_type": "start"}`)
+	if w := serve(router, r); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected: 204; Received: %d", w.Code)
+	}
+
+	if _, ok := log.Events("identified"); !ok {
+		t.Fatal("Expected events for the identified instance")
+	}
+
+	r = newRequest(http.MethodPost, EventsEndpoint, `{"name": "init", "event_type": "start"}`)
+	r.RemoteAddr = "10.10.10.11:0"
+	if w := serve(router, r); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+}
+
+func TestFrontendErrors(t *testing.T) {
+	tooManyFields := url.Values{}
+	for i := 0; i <= maxDataFields; i++ {
+		tooManyFields.Set(fmt.Sprintf("field%d", i), "value")
+	}
+
+	tooLong := url.Values{"pub_key_rsa": {strings.Repeat("a", maxDataValueLength+1)}}
+
+	longReport := fmt.Sprintf(`{"name": "init", "event_type": "start", "description": %q}`,
+		strings.Repeat("a", maxDataValueLength+1))
+
+	cases := []struct {
+		Name       string
+		Client     fakeClient
+		RemoteAddr string
+		Endpoint   string
+		Body       string
+		ExpectCode int
+	}{
+		{
+			Name:       "UnknownInstance",
+			RemoteAddr: "10.10.10.11:0",
+			Endpoint:   EventsEndpoint,
+			Body:       `{"name": "init", "event_type": "start"}`,
+			ExpectCode: http.StatusNotFound,
+		},
+		{
+			Name:       "BackendError",
+			Client:     fakeClient{err: errors.New("unavailable")},
+			Endpoint:   PhoneHomeEndpoint,
+			ExpectCode: http.StatusInternalServerError,
+		},
+		{
+			Name:       "TooManyFields",
+			Endpoint:   PhoneHomeEndpoint,
+			Body:       tooManyFields.Encode(),
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "FieldTooLong",
+			Endpoint:   PhoneHomeEndpoint,
+			Body:       tooLong.Encode(),
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "ReportFieldTooLong",
+			Endpoint:   EventsEndpoint,
+			Body:       longReport,
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "InvalidReport",
+			Endpoint:   EventsEndpoint,
+			Body:       `{`,
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "IncompleteReport",
+			Endpoint:   EventsEndpoint,
+			Body:       `{"name": "init"}`,
+			ExpectCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			log := NewLog(10, 10)
+
+			router := gin.New()
+			New(tc.Client, log).Configure(router)
+
+			r := newRequest(http.MethodPost, tc.Endpoint, tc.Body)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.RemoteAddr != "" {
+				r.RemoteAddr = tc.RemoteAddr
+			}
+
+			if w := serve(router, r); w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+
+			if len(log.Instances()) != 0 {
+				t.Fatal("Expected no events to be recorded")
+			}
+		})
+	}
+}
+
+func TestConfigureAdmin(t *testing.T) {
+	log := NewLog(10, 10)
+	log.Append("instance-1", Event{Kind: KindReport, Name: "init"})
+
+	router := gin.New()
+	ConfigureAdmin(router, log)
+
+	w := serve(router, newRequest(http.MethodGet, "/admin/v1/events", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var summaries []InstanceSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summaries); err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Instance != "instance-1" {
+		t.Fatalf("Unexpected summaries: %+v", summaries)
+	}
+
+	w = serve(router, newRequest(http.MethodGet, "/admin/v1/events/instance-1", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var events []Event
+	if err := json.Unmarshal(w.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Name != "init" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	w = serve(router, newRequest(http.MethodGet, "/admin/v1/events/instance-2", ""))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+}
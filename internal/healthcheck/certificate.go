@@ -0,0 +1,36 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// NewCertificateChecker creates a Checker called name that fails when cert has expired or isn't
+// yet valid. It's intended for certificates loaded once at startup, such as client certificates
+// used for mutual TLS, that Hegel must be restarted to renew.
+func NewCertificateChecker(name string, cert *x509.Certificate) Checker {
+	return NewChecker(name, func(context.Context) error {
+		t := time.Now()
+		if t.After(cert.NotAfter) {
+			return fmt.Errorf("certificate %q expired at %v", cert.Subject.CommonName, cert.NotAfter.UTC())
+		}
+		if t.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate %q isn't valid until %v", cert.Subject.CommonName, cert.NotBefore.UTC())
+		}
+		return nil
+	})
+}
+
+// Leaf returns the parsed leaf certificate of cert.
+func Leaf(cert tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("no certificate data")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
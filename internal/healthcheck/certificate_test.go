@@ -0,0 +1,46 @@
+package healthcheck_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	. "github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+func TestCertificateChecker(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		Name      string
+		NotBefore time.Time
+		NotAfter  time.Time
+		Error     bool
+	}{
+		{Name: "Valid", NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		{Name: "Expired", NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour), Error: true},
+		{Name: "NotYetValid", NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour), Error: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cert := &x509.Certificate{
+				Subject:   pkix.Name{CommonName: "hegel"},
+				NotBefore: tc.NotBefore,
+				NotAfter:  tc.NotAfter,
+			}
+
+			checker := NewCertificateChecker("client-certificate", cert)
+			if checker.Name() != "client-certificate" {
+				t.Fatalf("Unexpected name: %v", checker.Name())
+			}
+
+			err := checker.Check(context.Background())
+			if (err != nil) != tc.Error {
+				t.Fatalf("Expected error: %v; Received: %v", tc.Error, err)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package healthcheck
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,30 +19,165 @@ type Client interface {
 	IsHealthy(context.Context) bool
 }
 
+// Checker is an individual health check for a component.
+type Checker interface {
+	// Name identifies the check in health check responses.
+	Name() string
+
+	// Check returns nil if the component is healthy, else an error describing the problem.
+	Check(context.Context) error
+}
+
+// CheckProvider is implemented by Clients that expose health checks for their individual
+// components. The checks are included in responses from handlers created with NewHandler.
+type CheckProvider interface {
+	HealthChecks() []Checker
+}
+
+// NewChecker creates a Checker called name that runs fn.
+func NewChecker(name string, fn func(context.Context) error) Checker {
+	return checkerFunc{name: name, fn: fn}
+}
+
+type checkerFunc struct {
+	name string
+	fn   func(context.Context) error
+}
+
+func (c checkerFunc) Name() string                    { return c.name }
+func (c checkerFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// backendCheckName is the name of the check derived from Client.IsHealthy.
+const backendCheckName = "backend"
+
+// checkResult is the outcome of running a Checker.
+type checkResult struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Latency       float64    `json:"latency_seconds"`
+}
+
+// lastError records the most recent error observed for each check so it can be reported after
+// the check recovers.
+type lastError struct {
+	mtx    sync.Mutex
+	errors map[string]checkResult
+}
+
+func (l *lastError) record(r *checkResult) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if r.Error != "" {
+		now := time.Now()
+		l.errors[r.Name] = checkResult{LastError: r.Error, LastErrorTime: &now}
+	}
+
+	if last, ok := l.errors[r.Name]; ok {
+		r.LastError = last.LastError
+		r.LastErrorTime = last.LastErrorTime
+	}
+}
+
 // NewHandler returns a gin.HandlerFunc that provides a health check endpoint behavior. On each
-// request it queries client.IsHealthy and returns a 200 if the backend is healthy, else a 500.
+// request it queries client.IsHealthy and, if client is a CheckProvider, each of its checks.
+// It returns a 200 if all checks pass, else a 500.
+//
+// If the request contains a verbose query parameter, a plain text summary of each check is
+// returned in the style of the Kubernetes API server health endpoints.
 func NewHandler(client Client) gin.HandlerFunc {
 	start := time.Now()
+
+	checkers := []Checker{NewChecker(backendCheckName, func(ctx context.Context) error {
+		if !client.IsHealthy(ctx) {
+			return fmt.Errorf("backend is unhealthy")
+		}
+		return nil
+	})}
+
+	if p, ok := client.(CheckProvider); ok {
+		checkers = append(checkers, p.HealthChecks()...)
+	}
+
+	last := lastError{errors: map[string]checkResult{}}
+
 	return func(ctx *gin.Context) {
-		isHealthy := client.IsHealthy(ctx)
+		isHealthy := true
+		results := make([]checkResult, 0, len(checkers))
+		for _, c := range checkers {
+			r := runCheck(ctx, c)
+			last.record(&r)
+			results = append(results, r)
+
+			if r.Error != "" {
+				isHealthy = false
+			}
+		}
+
+		status := http.StatusOK
+		if !isHealthy {
+			status = http.StatusInternalServerError
+		}
+
+		if _, verbose := ctx.GetQuery("verbose"); verbose {
+			ctx.String(status, verboseResponse(results, isHealthy))
+			return
+		}
 
 		res := struct {
-			GitRev                  string  `json:"git_rev"`
-			Uptime                  float64 `json:"uptime"`
-			Goroutines              int     `json:"goroutines"`
-			HardwareClientAvailable bool    `json:"hardware_client_status"`
+			GitRev                  string        `json:"git_rev"`
+			Uptime                  float64       `json:"uptime"`
+			Goroutines              int           `json:"goroutines"`
+			HardwareClientAvailable bool          `json:"hardware_client_status"`
+			Checks                  []checkResult `json:"checks"`
 		}{
 			GitRev:                  build.GetGitRevision(),
 			Uptime:                  time.Since(start).Seconds(),
 			Goroutines:              runtime.NumGoroutine(),
-			HardwareClientAvailable: isHealthy,
+			HardwareClientAvailable: results[0].Error == "",
+			Checks:                  results,
 		}
 
-		status := http.StatusOK
-		if !isHealthy {
-			status = http.StatusInternalServerError
+		ctx.JSON(status, res)
+	}
+}
+
+func runCheck(ctx context.Context, c Checker) checkResult {
+	start := time.Now()
+	err := c.Check(ctx)
+
+	r := checkResult{
+		Name:    c.Name(),
+		Status:  "ok",
+		Latency: time.Since(start).Seconds(),
+	}
+
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+func verboseResponse(results []checkResult, isHealthy bool) string {
+	var b strings.Builder
+	for _, r := range results {
+		if r.Error == "" {
+			fmt.Fprintf(&b, "[+]%v ok\n", r.Name)
+		} else {
+			fmt.Fprintf(&b, "[-]%v failed: %v\n", r.Name, r.Error)
 		}
+	}
 
-		ctx.JSON(status, res)
+	if isHealthy {
+		b.WriteString("healthz check passed\n")
+	} else {
+		b.WriteString("healthz check failed\n")
 	}
+
+	return b.String()
 }
@@ -1,6 +1,9 @@
 package healthcheck_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -56,3 +59,69 @@ func TestHealthCheck(t *testing.T) {
 		})
 	}
 }
+
+type checkProviderClient struct {
+	healthy bool
+	checks  []Checker
+}
+
+func (c checkProviderClient) IsHealthy(context.Context) bool { return c.healthy }
+func (c checkProviderClient) HealthChecks() []Checker        { return c.checks }
+
+func TestHealthCheckComponents(t *testing.T) {
+	client := checkProviderClient{
+		healthy: true,
+		checks: []Checker{
+			NewChecker("passing", func(context.Context) error { return nil }),
+			NewChecker("failing", func(context.Context) error { return errors.New("boom") }),
+		},
+	}
+
+	router := gin.New()
+	Configure(router, client)
+
+	t.Run("JSON", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusInternalServerError, w.Code)
+		}
+
+		var res struct {
+			HardwareClientAvailable bool `json:"hardware_client_status"`
+			Checks                  []struct {
+				Name      string `json:"name"`
+				Status    string `json:"status"`
+				Error     string `json:"error"`
+				LastError string `json:"last_error"`
+			} `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+
+		if !res.HardwareClientAvailable {
+			t.Fatal("Expected hardware_client_status to be true")
+		}
+
+		if len(res.Checks) != 3 {
+			t.Fatalf("Expected 3 checks; Received: %v", len(res.Checks))
+		}
+
+		failing := res.Checks[2]
+		if failing.Name != "failing" || failing.Status != "failed" || failing.Error != "boom" || failing.LastError != "boom" {
+			t.Fatalf("Unexpected check result: %+v", failing)
+		}
+	})
+
+	t.Run("Verbose", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz?verbose", nil))
+
+		expect := "[+]backend ok\n[+]passing ok\n[-]failing failed: boom\nhealthz check failed\n"
+		if w.Body.String() != expect {
+			t.Fatalf("Expected:\n%v\nReceived:\n%v", expect, w.Body.String())
+		}
+	})
+}
@@ -6,3 +6,10 @@ import "github.com/gin-gonic/gin"
 func Configure(router gin.IRouter, client Client) {
 	router.GET("/healthz", NewHandler(client))
 }
+
+// ConfigureProbes configures router with /readyz and /livez endpoints using handlers created
+// with NewReadyHandler and NewLiveHandler.
+func ConfigureProbes(router gin.IRouter, r *Readiness, client Client) {
+	router.GET("/readyz", NewReadyHandler(r, client))
+	router.GET("/livez", NewLiveHandler())
+}
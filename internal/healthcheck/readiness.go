@@ -0,0 +1,63 @@
+package healthcheck
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultRetryAfter is the duration clients are asked to wait before retrying requests rejected
+// because Hegel isn't ready.
+const DefaultRetryAfter = 5 * time.Second
+
+// Readiness tracks whether Hegel is ready to serve metadata. The zero value is not ready.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+// SetReady marks Hegel as ready to serve metadata.
+func (r *Readiness) SetReady() {
+	r.ready.Store(true)
+}
+
+// IsReady returns true if SetReady has been called.
+func (r *Readiness) IsReady() bool {
+	return r.ready.Load()
+}
+
+// RequireReady returns a middleware that aborts requests with a 503 and a Retry-After header
+// until r is ready.
+func RequireReady(r *Readiness, retryAfter time.Duration) gin.HandlerFunc {
+	seconds := strconv.Itoa(int(retryAfter.Seconds()))
+	return func(ctx *gin.Context) {
+		if !r.IsReady() {
+			ctx.Header("Retry-After", seconds)
+			ctx.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// NewReadyHandler returns a gin.HandlerFunc for a readiness probe. It returns a 200 when r is
+// ready and client is healthy, else a 503.
+func NewReadyHandler(r *Readiness, client Client) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !r.IsReady() || !client.IsHealthy(ctx) {
+			ctx.String(http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		ctx.String(http.StatusOK, "ok")
+	}
+}
+
+// NewLiveHandler returns a gin.HandlerFunc for a liveness probe. It returns a 200 for as long as
+// the HTTP server is able to serve requests.
+func NewLiveHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "ok")
+	}
+}
@@ -0,0 +1,98 @@
+package healthcheck_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gomock "github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+func TestRequireReady(t *testing.T) {
+	var readiness Readiness
+
+	router := gin.New()
+	router.Use(RequireReady(&readiness, 10*time.Second))
+	router.GET("/", func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Fatalf("Expected Retry-After: 10; Received: %q", retryAfter)
+	}
+
+	readiness.SetReady()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Path         string
+		Ready        bool
+		Healthy      bool
+		ExpectedCode int
+	}{
+		{
+			Name:         "ReadyzNotReady",
+			Path:         "/readyz",
+			Healthy:      true,
+			ExpectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			Name:         "ReadyzUnhealthy",
+			Path:         "/readyz",
+			Ready:        true,
+			ExpectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			Name:         "ReadyzReady",
+			Path:         "/readyz",
+			Ready:        true,
+			Healthy:      true,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			Name:         "LivezNotReady",
+			Path:         "/livez",
+			ExpectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().IsHealthy(gomock.Any()).Return(tc.Healthy).AnyTimes()
+
+			var readiness Readiness
+			if tc.Ready {
+				readiness.SetReady()
+			}
+
+			router := gin.New()
+			ConfigureProbes(router, &readiness, client)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+
+			if w.Code != tc.ExpectedCode {
+				t.Fatalf("Expected status code: %d; Received status code: %d", tc.ExpectedCode, w.Code)
+			}
+		})
+	}
+}
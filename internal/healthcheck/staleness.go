@@ -0,0 +1,25 @@
+package healthcheck
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DataAgeHeader is the response header containing the age, in seconds, of the data served.
+const DataAgeHeader = "X-Hegel-Data-Age"
+
+// DataAgeSource is implemented by clients that serve cached data and can report how long it has
+// been since the data was known to be in sync with its source.
+type DataAgeSource interface {
+	DataAge() time.Duration
+}
+
+// DataAge returns a middleware that sets the DataAgeHeader on every response using source.
+func DataAge(source DataAgeSource) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header(DataAgeHeader, strconv.Itoa(int(source.DataAge().Seconds())))
+		ctx.Next()
+	}
+}
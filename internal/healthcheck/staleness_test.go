@@ -0,0 +1,28 @@
+package healthcheck_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+type dataAgeSource time.Duration
+
+func (d dataAgeSource) DataAge() time.Duration { return time.Duration(d) }
+
+func TestDataAge(t *testing.T) {
+	router := gin.New()
+	router.Use(DataAge(dataAgeSource(90 * time.Second)))
+	router.GET("/", func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if age := w.Header().Get(DataAgeHeader); age != "90" {
+		t.Fatalf("Expected %v: 90; Received: %q", DataAgeHeader, age)
+	}
+}
@@ -1,6 +1,7 @@
 package request
 
 import (
+	"context"
 	"net"
 	"net/http"
 )
@@ -13,3 +14,31 @@ func RemoteAddrIP(r *http.Request) (string, error) {
 	}
 	return addr, nil
 }
+
+// peerKey is the context key for the address of the peer that connected to Hegel.
+type peerKey struct{}
+
+// WithPeer returns a shallow copy of r recording its current remote address as the peer. It
+// should be called before the remote address is rewritten, for example from an X-Forwarded-For
+// header. If r already has a peer, r is returned.
+func WithPeer(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(peerKey{}).(string); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr))
+}
+
+// PeerIP retrieves the IP of the peer that connected to Hegel. It differs from RemoteAddrIP when
+// the remote address was rewritten after calling WithPeer.
+func PeerIP(r *http.Request) (string, error) {
+	addr, ok := r.Context().Value(peerKey{}).(string)
+	if !ok {
+		addr = r.RemoteAddr
+	}
+
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	return ip, nil
+}
@@ -0,0 +1,270 @@
+/*
+Package policy restricts when instances may retrieve sensitive data, such as userdata and
+vendor-data, that often contains bootstrap secrets. A Policy may permit retrievals only while an instance is in certain
+states, for a period after the instance's data changes or for a limited number of reads. Static
+metadata, such as the hostname, isn't subject to the Policy.
+*/
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+// ErrDenied indicates a Policy denied a retrieval.
+var ErrDenied = errors.New("denied by policy")
+
+// Options configures a Policy. The zero value permits every retrieval.
+type Options struct {
+	// States are the instance states, for example provisioning, in which retrievals are
+	// permitted. States are compared case insensitively. If empty, retrievals are permitted in
+	// any state.
+	States []string
+
+	// Window is how long retrievals are permitted for after an instance's data changes. If the
+	// backend doesn't report when the data changed, the window starts when the Policy first
+	// observes the revision. If 0, retrievals are permitted indefinitely.
+	Window time.Duration
+
+	// MaxReads is the number of retrievals of each payload permitted for each revision of an
+	// instance. If 0, retrievals are unlimited.
+	MaxReads int
+
+	// Logger is used to log denied retrievals. Optional.
+	Logger logr.Logger
+}
+
+// Subject describes an instance attempting a retrieval.
+type Subject struct {
+	// ID identifies the instance. If empty, IP is used.
+	ID string
+
+	// IP is the address the retrieval was requested from.
+	IP string
+
+	// State is the state of the instance.
+	State string
+
+	// Revision identifies the version of the instance's data. When it changes the window and
+	// read count restart. If empty, the instance is treated as never changing.
+	Revision string
+
+	// Changed is when the instance's data last changed. If zero, the window starts when the
+	// Policy first observes Revision.
+	Changed time.Time
+
+	// Payload identifies the data retrieved, for example user-data. Reads are counted for each
+	// payload.
+	Payload string
+}
+
+// key returns the key the Policy tracks s by.
+func (s Subject) key() string {
+	if s.ID != "" {
+		return s.ID
+	}
+	return s.IP
+}
+
+// Policy decides whether instances may retrieve sensitive data. Windows and read counts are
+// tracked in memory so each Hegel replica enforces them independently and forgets them when it
+// restarts. Policy is safe for concurrent use.
+type Policy struct {
+	states   map[string]struct{}
+	window   time.Duration
+	maxReads int
+	logger   logr.Logger
+
+	mtx       sync.Mutex
+	subjects  map[string]*record
+	lastPrune time.Time // Records are pruned at most once every pruneInterval.
+
+	// now is used to retrieve the current time. It exists for testing.
+	now func() time.Time
+}
+
+// record tracks retrievals for a single revision of an instance.
+type record struct {
+	revision string
+
+	// start is when the window started. changed is true if start is when the instance's data
+	// changed rather than when the revision was first observed.
+	start   time.Time
+	changed bool
+
+	// reads counts the retrievals of each payload.
+	reads map[string]int
+}
+
+const pruneInterval = time.Minute
+
+// New creates a new Policy.
+func New(opts Options) *Policy {
+	var states map[string]struct{}
+	if len(opts.States) > 0 {
+		states = make(map[string]struct{}, len(opts.States))
+		for _, s := range opts.States {
+			states[strings.ToLower(s)] = struct{}{}
+		}
+	}
+
+	logger := opts.Logger
+	if logger.GetSink() == nil {
+		logger = logr.Discard()
+	}
+
+	return &Policy{
+		states:   states,
+		window:   opts.Window,
+		maxReads: opts.MaxReads,
+		logger:   logger,
+		subjects: map[string]*record{},
+		now:      time.Now,
+	}
+}
+
+// Enabled reports whether p restricts any retrievals.
+func (p *Policy) Enabled() bool {
+	return p.states != nil || p.window > 0 || p.maxReads > 0
+}
+
+// Allow returns nil if s may perform a retrieval. Otherwise it logs the denial and returns an
+// error wrapping ErrDenied. Retrievals count toward s's read limit once Served is called.
+func (p *Policy) Allow(s Subject) error {
+	if err := p.allow(s); err != nil {
+		p.logger.Info("Denied sensitive data retrieval",
+			"ip", s.IP,
+			"instance_id", s.ID,
+			"state", s.State,
+			"reason", err.Error(),
+		)
+		return fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	return nil
+}
+
+func (p *Policy) allow(s Subject) error {
+	if p.states != nil {
+		if _, ok := p.states[strings.ToLower(s.State)]; !ok {
+			return fmt.Errorf("instance state %q is not permitted", s.State)
+		}
+	}
+
+	if p.window == 0 && p.maxReads == 0 {
+		return nil
+	}
+
+	now := p.now()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.pruneLocked(now)
+
+	r := p.recordLocked(s, now)
+
+	if p.window > 0 && now.Sub(r.start) > p.window {
+		if r.changed {
+			return fmt.Errorf("window of %v since the instance changed has elapsed", p.window)
+		}
+		return fmt.Errorf("window of %v since revision was first observed has elapsed", p.window)
+	}
+
+	if p.maxReads > 0 && r.reads[s.Payload] >= p.maxReads {
+		return fmt.Errorf("read limit of %v reached", p.maxReads)
+	}
+
+	return nil
+}
+
+// Served counts a retrieval by s toward its read limit. It should be called once the data has
+// been served so failed retrievals aren't counted. Concurrent retrievals that were allowed may
+// exceed the limit.
+func (p *Policy) Served(s Subject) {
+	if p.maxReads == 0 {
+		return
+	}
+
+	now := p.now()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	r := p.recordLocked(s, now)
+	if r.reads == nil {
+		r.reads = map[string]int{}
+	}
+	r.reads[s.Payload]++
+}
+
+// recordLocked returns the record of s's revision, creating it if necessary. p.mtx must be held.
+func (p *Policy) recordLocked(s Subject, now time.Time) *record {
+	r, ok := p.subjects[s.key()]
+	if !ok || r.revision != s.Revision {
+		r = &record{revision: s.Revision, start: now}
+		if !s.Changed.IsZero() {
+			r.start = s.Changed
+			r.changed = true
+		}
+		p.subjects[s.key()] = r
+	}
+	return r
+}
+
+// pruneLocked forgets records that would be recreated identically so instances that are
+// deleted, or whose IP changes, don't accumulate. Other records are kept until the instance's
+// revision changes. p.mtx must be held.
+func (p *Policy) pruneLocked(now time.Time) {
+	if now.Sub(p.lastPrune) < pruneInterval {
+		return
+	}
+	p.lastPrune = now
+
+	for key, r := range p.subjects {
+		if p.stale(r, now) {
+			delete(p.subjects, key)
+		}
+	}
+}
+
+// stale reports whether r can be forgotten. Only records whose window started when the instance
+// changed are recreated with the same start, and they're only recreated identically if their
+// window has elapsed, so retrievals are still denied, or nothing has been read.
+func (p *Policy) stale(r *record, now time.Time) bool {
+	if !r.changed {
+		return false
+	}
+
+	if p.window > 0 && now.Sub(r.start) > p.window {
+		return true
+	}
+
+	return len(r.reads) == 0
+}
+
+// AllowUserdata satisfies ec2.UserdataPolicy.
+func (p *Policy) AllowUserdata(ip string, instance ec2.Instance, payload ec2.Payload) error {
+	return p.Allow(subject(ip, instance, payload))
+}
+
+// UserdataServed satisfies ec2.UserdataPolicy.
+func (p *Policy) UserdataServed(ip string, instance ec2.Instance, payload ec2.Payload) {
+	p.Served(subject(ip, instance, payload))
+}
+
+func subject(ip string, instance ec2.Instance, payload ec2.Payload) Subject {
+	return Subject{
+		ID:       instance.Metadata.InstanceID,
+		IP:       ip,
+		State:    instance.State,
+		Revision: instance.Revision,
+		Changed:  instance.Changed,
+		Payload:  string(payload),
+	}
+}
@@ -0,0 +1,219 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestPolicyStates(t *testing.T) {
+	p := New(Options{States: []string{"provisioning"}})
+
+	if err := p.Allow(Subject{ID: "machine", State: "Provisioning"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err := p.Allow(Subject{ID: "machine", State: "ready"})
+	if !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+}
+
+func TestPolicyWindow(t *testing.T) {
+	now := time.Now()
+	p := New(Options{Window: time.Minute})
+	p.now = func() time.Time { return now }
+
+	subject := Subject{ID: "machine", Revision: "1"}
+	if err := p.Allow(subject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := p.Allow(subject); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+
+	// A new revision opens a new window.
+	subject.Revision = "2"
+	if err := p.Allow(subject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPolicyWindowStartsWhenChanged(t *testing.T) {
+	now := time.Now()
+	p := New(Options{Window: time.Minute})
+	p.now = func() time.Time { return now }
+
+	// The window started when the instance changed, not when it's first requested.
+	if err := p.Allow(Subject{ID: "stale", Revision: "1", Changed: now.Add(-2 * time.Minute)}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+
+	subject := Subject{ID: "machine", Revision: "1", Changed: now.Add(-30 * time.Second)}
+	if err := p.Allow(subject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	now = now.Add(31 * time.Second)
+	if err := p.Allow(subject); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+}
+
+func TestPolicyPrune(t *testing.T) {
+	now := time.Now()
+	p := New(Options{Window: time.Minute, MaxReads: 1})
+	p.now = func() time.Time { return now }
+
+	changed := Subject{ID: "changed", Revision: "1", Changed: now}
+	unknown := Subject{ID: "unknown", Revision: "1"}
+	for _, s := range []Subject{changed, unknown} {
+		if err := p.Allow(s); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		p.Served(s)
+	}
+
+	// Records whose window started when the instance changed are pruned once it has elapsed.
+	now = now.Add(2 * time.Minute)
+	if err := p.Allow(Subject{ID: "other"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := p.subjects[changed.key()]; ok {
+		t.Fatal("Expected record with elapsed window to be pruned")
+	}
+
+	// Pruned records are recreated with the same window so retrievals are still denied.
+	if err := p.Allow(changed); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+
+	// Records without a change time are kept so waiting doesn't open a new window.
+	now = now.Add(48 * time.Hour)
+	if err := p.Allow(unknown); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+}
+
+func TestPolicyPruneReads(t *testing.T) {
+	now := time.Now()
+	p := New(Options{MaxReads: 1})
+	p.now = func() time.Time { return now }
+
+	read := Subject{ID: "read", Revision: "1", Changed: now}
+	unread := Subject{ID: "unread", Revision: "1", Changed: now}
+	for _, s := range []Subject{read, unread} {
+		if err := p.Allow(s); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	p.Served(read)
+
+	now = now.Add(2 * pruneInterval)
+	if err := p.Allow(Subject{ID: "other"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Records without reads are recreated identically so they're pruned. Records with reads
+	// are kept so the read limit isn't reset.
+	if _, ok := p.subjects[unread.key()]; ok {
+		t.Fatal("Expected record without reads to be pruned")
+	}
+	if err := p.Allow(read); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+}
+
+func TestPolicyMaxReads(t *testing.T) {
+	p := New(Options{MaxReads: 2})
+
+	subject := Subject{IP: "10.10.10.10", Revision: "1", Payload: "user-data"}
+	for i := 0; i < 2; i++ {
+		if err := p.Allow(subject); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		p.Served(subject)
+	}
+	if err := p.Allow(subject); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+
+	// Other payloads have their own limit.
+	vendordata := subject
+	vendordata.Payload = "vendor-data"
+	if err := p.Allow(vendordata); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Other instances have their own limit.
+	if err := p.Allow(Subject{IP: "10.10.10.11", Revision: "1", Payload: "user-data"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A new revision resets the limit.
+	subject.Revision = "2"
+	if err := p.Allow(subject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPolicyOnlyServedRetrievalsCountReads(t *testing.T) {
+	p := New(Options{MaxReads: 1})
+
+	// Retrievals that are allowed but fail before the data is served aren't counted.
+	subject := Subject{ID: "machine", Revision: "1"}
+	for i := 0; i < 2; i++ {
+		if err := p.Allow(subject); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	p.Served(subject)
+	if err := p.Allow(subject); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+}
+
+func TestPolicyDeniedStatesDontCountReads(t *testing.T) {
+	p := New(Options{States: []string{"provisioning"}, MaxReads: 1})
+
+	if err := p.Allow(Subject{ID: "machine", State: "ready"}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+	if err := p.Allow(Subject{ID: "machine", State: "provisioning"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPolicyAllowUserdata(t *testing.T) {
+	p := New(Options{States: []string{"provisioning"}, MaxReads: 1})
+
+	var instance ec2.Instance
+	instance.Metadata.InstanceID = "machine"
+	instance.State = "provisioning"
+
+	if err := p.AllowUserdata("10.10.10.10", instance, ec2.PayloadUserdata); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.UserdataServed("10.10.10.10", instance, ec2.PayloadUserdata)
+
+	if err := p.AllowUserdata("10.10.10.10", instance, ec2.PayloadUserdata); !errors.Is(err, ErrDenied) {
+		t.Fatalf("Expected ErrDenied; Received %v", err)
+	}
+	if err := p.AllowUserdata("10.10.10.10", instance, ec2.PayloadVendordata); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPolicyEnabled(t *testing.T) {
+	if New(Options{}).Enabled() {
+		t.Fatal("Expected zero Options to be disabled")
+	}
+	if !New(Options{MaxReads: 1}).Enabled() {
+		t.Fatal("Expected MaxReads to enable the policy")
+	}
+}
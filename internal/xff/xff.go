@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/packethost/xff"
 	"github.com/pkg/errors"
+	"github.com/tinkerbell/hegel/internal/http/request"
 )
 
 // Parse parses a string of comma separated trusted proxies. A trusted proxy can be a CIDR or an IP.
@@ -51,7 +52,7 @@ func Parse(trustedProxies string) ([]string, error) {
 // Middleware creates an X-Forward-For middlware in the form of an http.Handler. The middleware
 // will replace the http.Request.RemoteAddr with the X-Forward-For header address if the
 // http.Request.RemoteAddr is in allowedSubnets. It then calls handler with the newly configured
-// http.Request.
+// http.Request. The original address remains available through request.PeerIP.
 //
 // allowedSubnets is a slice of CIDR blocks. Individual IPs should be formatted with /32 or /128
 // for IPv4 and IPv6 respectively.
@@ -71,6 +72,10 @@ func Middleware(proxies []string) (gin.HandlerFunc, error) {
 	//
 	// When we separate from packethost packages we can tidy this up with our own implementation.
 	return func(ctx *gin.Context) {
+		// Record the peer before its address is rewritten so it remains available, for example
+		// for auditing.
+		ctx.Request = request.WithPeer(ctx.Request)
+
 		xffmw.ServeHTTP(
 			ctx.Writer,
 			ctx.Request,
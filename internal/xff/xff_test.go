@@ -2,6 +2,7 @@ package xff_test
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -9,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-cmp/cmp"
 	"github.com/tinkerbell/hegel/internal/ginutil"
+	"github.com/tinkerbell/hegel/internal/http/request"
 	. "github.com/tinkerbell/hegel/internal/xff"
 )
 
@@ -188,14 +190,23 @@ func TestMiddleware(t *testing.T) {
 				t.Fatalf("unexpected status code: %d", w.Code)
 			}
 
-			// The request is passed through as a pointer so we can check the original request.
-			if req.RemoteAddr != tc.ExpectedRemoteAddr {
+			// The middleware replaces the request on the context.
+			if ctx.Request.RemoteAddr != tc.ExpectedRemoteAddr {
 				t.Fatalf(
 					"unexpected remote addr: got %s, want %s",
-					req.RemoteAddr,
+					ctx.Request.RemoteAddr,
 					tc.ExpectedRemoteAddr,
 				)
 			}
+
+			// The original address is retained as the peer.
+			peer, err := request.PeerIP(ctx.Request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expect, _, _ := net.SplitHostPort(tc.RemoteAddr); peer != expect {
+				t.Fatalf("unexpected peer: got %s, want %s", peer, expect)
+			}
 		})
 	}
 }